@@ -43,7 +43,8 @@
 // operational structures.
 //
 // Runx allows to run a function which raises exception, and return exception
-// as regular error, if any. Similarly XRun allows to run a function which
+// as regular error, if any. Catchf, used under defer, does similar for the
+// function it is called in. Similarly XRun allows to run a function which
 // returns regular error, and raise exception if error is not nil.
 //
 // Last but not least it has to be taken into account that exceptions
@@ -147,6 +148,39 @@ func Catch(f func(e *Error)) {
 	f(e)
 }
 
+// CatchAll catches any panic and calls f(v, isExc) if it was caught.
+//
+// Contrary to Catch, panics with values that are not *Error are caught as
+// well. v is the panic value, and isExc tells whether it is *Error. f decides
+// what to do with caught value, e.g. it can report it together with location
+// where it was caught, or repanic it.
+//
+// Must be called under defer.
+func CatchAll(f func(v interface{}, isExc bool)) {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	_, isExc := r.(*Error)
+	f(r, isExc)
+}
+
+// Catchf catches error and returns it via *errp with formatted context added.
+//
+// It is shorthand for Catch that sets *errp to caught error with
+// Addcontext(e, fmt.Sprintf(format, a...)). Non-Error panics are not caught.
+//
+// Must be called under defer.
+func Catchf(errp *error, format string, a ...interface{}) {
+	e := _errcatch(recover())
+	if e == nil {
+		return
+	}
+
+	*errp = Addcontext(e, fmt.Sprintf(format, a...))
+}
+
 // Onunwind installs error filter to be applied on error unwinding.
 //
 // It hooks into unwinding process with f() call. Returned error is reraised.
@@ -212,9 +246,19 @@ func init() {
 	_errorraise	= _errorpkgname + ".Raise"
 }
 
+// SkipFrame tells Addcallingcontext which calling frames to skip.
+//
+// By default frames of functions with name ending with "_" are considered
+// to be intermediates and are skipped. SkipFrame can be replaced to hide
+// other frames, e.g. intermediary wrappers of a program.
+var SkipFrame = func(f runtime.Frame) bool {
+	return strings.HasSuffix(f.Function, "_")
+}
+
 // Addcallingcontext adds calling context to error.
 //
 // Add calling function frames as error context up-to topfunc not including.
+// Frames for which SkipFrame returns true are not added.
 //
 // See also: Addcontext()
 func Addcallingcontext(topfunc string, e *Error) *Error {
@@ -235,7 +279,7 @@ func Addcallingcontext(topfunc string, e *Error) *Error {
 		}
 
 		// skip intermediates
-		if strings.HasSuffix(f.Function, "_") { // XXX -> better skipfunc
+		if SkipFrame(f) {
 			continue
 		}
 
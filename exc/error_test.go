@@ -23,6 +23,7 @@ import (
 	"errors"
 	"reflect"
 	"runtime"
+	"strings"
 	"testing"
 
 	"lab.nexedi.com/kirr/go123/my"
@@ -123,6 +124,67 @@ func TestErrContextf(t *testing.T) {
 	t.Fatal("error not caught")
 }
 
+func do_catchf(v interface{}) (err error) {
+	defer Catchf(&err, "do %d", 123)
+	if v != nil {
+		panic(v)
+	}
+	do_raise1()
+	return nil
+}
+
+func TestCatchf(t *testing.T) {
+	err := do_catchf(nil)
+	e, ok := err.(*Error)
+	if !ok {
+		t.Fatalf("Catchf: err = %#v  ; want *Error", err)
+	}
+	verifyErrChain(t, e, "do 123", 1)
+	if msg := err.Error(); msg != "do 123: 1" {
+		t.Fatalf("Catchf: err = %q  ; want %q", msg, "do 123: 1")
+	}
+
+	// non-exc panic is not caught
+	func() {
+		defer func() {
+			r := recover()
+			if r != "bug" {
+				t.Fatalf("Catchf: recovered %#v  ; want \"bug\"", r)
+			}
+		}()
+		do_catchf("bug")
+		t.Fatal("non-exc panic caught")
+	}()
+}
+
+// do_catchall runs f under CatchAll and returns what was caught.
+func do_catchall(f func()) (v interface{}, isExc bool) {
+	defer CatchAll(func(v_ interface{}, isExc_ bool) {
+		v, isExc = v_, isExc_
+	})
+	f()
+	return nil, false
+}
+
+func TestCatchAll(t *testing.T) {
+	v, isExc := do_catchall(do_raise1)
+	e, ok := v.(*Error)
+	if !(isExc && ok) {
+		t.Fatalf("CatchAll: caught %#v, isExc=%v  ; want *Error, isExc=true", v, isExc)
+	}
+	verifyErrChain(t, e, 1)
+
+	v, isExc = do_catchall(func() { panic("boom") })
+	if !(v == "boom" && !isExc) {
+		t.Fatalf("CatchAll: caught %#v, isExc=%v  ; want \"boom\", isExc=false", v, isExc)
+	}
+
+	v, isExc = do_catchall(func() {})
+	if !(v == nil && !isExc) {
+		t.Fatalf("CatchAll: caught %#v, isExc=%v  ; want nil, isExc=false", v, isExc)
+	}
+}
+
 func do_raise11() {
 	do_raise1()
 }
@@ -173,6 +235,41 @@ func TestErrAddCallingContext(t *testing.T) {
 	}
 }
 
+func do_raise1_() {
+	do_raise1()
+}
+
+func do_raise1helper() {
+	do_raise1_()
+}
+
+func do_raise1helper1() {
+	do_raise1helper()
+}
+
+func TestErrAddCallingContextSkipFrame(t *testing.T) {
+	// by default only frames with "_" suffix are skipped
+	err := Runx(do_raise1helper1)
+	want := "do_raise1helper1: do_raise1helper: do_raise1: 1"
+	if msg := err.Error(); msg != want {
+		t.Fatalf("default SkipFrame: err + calling context: %q  ; want %q", msg, want)
+	}
+
+	// custom predicate hides helper frames as well
+	defer func(skipFrame func(runtime.Frame) bool) {
+		SkipFrame = skipFrame
+	}(SkipFrame)
+	SkipFrame = func(f runtime.Frame) bool {
+		return strings.HasSuffix(f.Function, "_") || strings.HasSuffix(f.Function, "helper")
+	}
+
+	err = Runx(do_raise1helper1)
+	want = "do_raise1helper1: do_raise1: 1"
+	if msg := err.Error(); msg != want {
+		t.Fatalf("custom SkipFrame: err + calling context: %q  ; want %q", msg, want)
+	}
+}
+
 func TestRunx(t *testing.T) {
 	var tests = []struct { f func(); wanterr string } {
 		{func() {},	""},
@@ -51,8 +51,34 @@ func FuncName() string {
 //
 // package is fully qualified package/name.
 func PkgName() string {
+	f := _myframe(3)
+	pkg, _ := _splitfunc(f.Function)
+	return pkg
+}
+
+// ShortFuncName returns short name of currently running function.
+//
+// It is the last dot-separated component of FuncName, e.g. "Function" for
+// "lab.nexedi.com/kirr/go123/my.Function".
+func ShortFuncName() string {
 	f := _myframe(3)
 	myfunc := f.Function
+	return myfunc[strings.LastIndexByte(myfunc, '.')+1:]
+}
+
+// ShortPkgName returns short name of currently running function's package.
+//
+// It is the final path element of PkgName, e.g. "my" for
+// "lab.nexedi.com/kirr/go123/my".
+func ShortPkgName() string {
+	f := _myframe(3)
+	pkg, _ := _splitfunc(f.Function)
+	pkg = pkg[strings.LastIndexByte(pkg, '/')+1:]
+	return strings.ReplaceAll(pkg, "%2e", ".")
+}
+
+// _splitfunc splits fully qualified function name into package and function parts.
+func _splitfunc(myfunc string) (pkg, fun string) {
 	// NOTE dots in package name are after last slash are escaped by go as %2e
 	// this way the first '.' after last '/' is delimiter between package and function
 	//
@@ -64,7 +90,7 @@ func PkgName() string {
 	if idot == -1 {
 		panic(fmt.Errorf("funcname %q is not fully qualified", myfunc))
 	}
-	return myfunc[:iafterslash+idot]
+	return myfunc[:iafterslash+idot], myfunc[iafterslash+idot+1:]
 }
 
 // File returns path of currently running function's file.
@@ -46,6 +46,37 @@ func TestMyFuncName(t *testing.T) {
 
 // XXX how to test PkgName? (go test changes full package name - see ^^^)
 
+func TestMyShortFuncName(t *testing.T) {
+	myfunc := ShortFuncName()
+	want := "TestMyShortFuncName"
+	if myfunc != want {
+		t.Errorf("my.ShortFuncName() -> %v  ; want %v", myfunc, want)
+	}
+}
+
+func TestMyShortPkgName(t *testing.T) {
+	mypkg := ShortPkgName()
+	want := "my"
+	if mypkg != want {
+		t.Errorf("my.ShortPkgName() -> %v  ; want %v", mypkg, want)
+	}
+}
+
+func TestSplitFunc(t *testing.T) {
+	testv := []struct{ in, pkg, fun string }{
+		{"a/b/c.F", "a/b/c", "F"},
+		{"a/b/c.(*T).M", "a/b/c", "(*T).M"},
+		{"a/b.c/d%2ee.F.func1", "a/b.c/d%2ee", "F.func1"},
+		{"main.main", "main", "main"},
+	}
+	for _, tt := range testv {
+		pkg, fun := _splitfunc(tt.in)
+		if !(pkg == tt.pkg && fun == tt.fun) {
+			t.Errorf("splitfunc(%q) -> %q %q  ; want %q %q", tt.in, pkg, fun, tt.pkg, tt.fun)
+		}
+	}
+}
+
 func TestMyFile(t *testing.T) {
 	myfile := File()
 	wantsuffix := "my_test.go"
@@ -37,8 +37,10 @@ var (
 //
 // The goroutine which sent the message will wait for Ack before continue.
 type _Msg struct {
-	Event interface{}
-	ack   chan<- error // nil on Ack; !nil on nak
+	Event  interface{}
+	tags   map[string]string // metadata attached via RxEventTagged
+	rxTime time.Time         // when the event was received via RxEvent
+	ack    chan<- error      // nil on Ack; !nil on nak
 }
 
 // _chan provides synchronous channel associated with a stream.
@@ -59,25 +61,38 @@ type _chan struct {
 	// T includes these in final printout for pending events
 	// protected by t.mu
 	unsentv []*_Msg
+
+	// rxTime of the last message received from the channel.
+	// accessed only from main testing goroutine
+	rxLast time.Time
 }
 
 // Send sends event to a consumer and waits for ack.
 // if main testing goroutine detects any problem Send panics.
-func (ch *_chan) Send(event interface{}) {
+func (ch *_chan) Send(event interface{}, tags map[string]string, rxTime time.Time) {
+	err := ch.SendAck(event, tags, rxTime)
+	if err != nil {
+		ch.t.fatalfInNonMain("%s", err)
+	}
+}
+
+// SendAck sends event to a consumer and waits for ack.
+// if main testing goroutine detects any problem SendAck returns corresponding error.
+func (ch *_chan) SendAck(event interface{}, tags map[string]string, rxTime time.Time) error {
 	t := ch.t
 	if *chatty {
-		fmt.Printf("%s <- %T %v\n", ch.name, event, event)
+		fmt.Printf("%s <- %T %v%s\n", ch.name, event, event, tagsSuffix(tags))
 	}
 	ack := make(chan error, 1)
-	msg := &_Msg{event, ack}
+	msg := &_Msg{event, tags, rxTime, ack}
 	unsentWhy := ""
 	select {
 	case ch.msgq <- msg:
 		err := <-ack
 		if err != nil {
-			t.fatalfInNonMain("%s: send: %s", ch.name, err)
+			return fmt.Errorf("%s: send: %s", ch.name, err)
 		}
-		return
+		return nil
 
 	case <-ch.down:
 		unsentWhy = "channel was closed"
@@ -91,7 +106,7 @@ func (ch *_chan) Send(event interface{}) {
 	ch.unsentv = append(ch.unsentv, msg)
 	t.mu.Unlock()
 
-	t.fatalfInNonMain("%s: send: %s", ch.name, unsentWhy)
+	return fmt.Errorf("%s: send: %s", ch.name, unsentWhy)
 }
 
 // Close closes the sending side of the channel.
@@ -123,21 +138,31 @@ func (ch *_chan) RecvInto(eventp interface{}) *_Msg {
 		t.Fatalf("%s: recv: deadlock waiting for %T\n", ch.name, eventp)
 	}
 
+	ch.xsetInto(msg, eventp)
+	return msg
+}
+
+// xsetInto verifies that type of received event is the same as type of
+// *event, and saves the event there.
+//
+// Must be called from main testing thread.
+func (ch *_chan) xsetInto(msg *_Msg, eventp interface{}) {
+	t := ch.t; t.Helper()
+
 	reventp := reflect.ValueOf(eventp)
 	if reventp.Type().Elem() != reflect.TypeOf(msg.Event) {
 		t.queuenak(msg, "unexpected event type")
-		t.Fatalf("%s: expect: %s:  got %T %v", ch.name, reventp.Elem().Type(), msg.Event, msg.Event)
+		t.Fatalf("%s: expect: %s:  got %T %v%s", ch.name, reventp.Elem().Type(), msg.Event, msg.Event, tagsSuffix(msg.tags))
 	}
 
 	// *eventp = msg.Event
 	reventp.Elem().Set(reflect.ValueOf(msg.Event))
-
-	return msg
 }
 
 func (ch *_chan) recv() *_Msg {
 	select {
 	case msg := <-ch.msgq:
+		ch.rxLast = msg.rxTime
 		return msg // ok
 
 	case <-time.After(*deadTime):
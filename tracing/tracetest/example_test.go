@@ -239,6 +239,48 @@ func TestExpectValue(t *testing.T) {
 }
 
 
+// hasPrefix returns matcher for ExpectMatch that checks eventHi to start with prefix.
+func hasPrefix(prefix string) func(event interface{}) error {
+	return func(event interface{}) error {
+		if !strings.HasPrefix(string(event.(eventHi)), prefix) {
+			return fmt.Errorf("want prefix %q", prefix)
+		}
+		return nil
+	}
+}
+
+// TestExpectMatchOK demonstrates ExpectMatch passing on event that matches predicate.
+func TestExpectMatchOK(t *testing.T) {
+	verify(t, func(t *tracetest.T) {
+		var wg sync.WaitGroup
+		defer wg.Wait()
+		wg.Add(1)
+
+		go func() { // thread 1
+			defer wg.Done()
+			hi("T1·A")
+		}()
+
+		t.ExpectMatch("t1", eventHi(""), hasPrefix("T1·"))
+	})
+}
+
+// TestExpectMatchMismatch demonstrates ExpectMatch asserting with matcher's error.
+func TestExpectMatchMismatch(t *testing.T) {
+	verify(t, func(t *tracetest.T) {
+		var wg sync.WaitGroup
+		defer wg.Wait()
+		wg.Add(1)
+
+		go func() { // thread 1
+			defer wg.Done()
+			hi("T1·A")
+		}()
+
+		t.ExpectMatch("t1", eventHi(""), hasPrefix("T2·"))
+	})
+}
+
 
 // ----------------------------------------
 
@@ -365,4 +407,94 @@ var testExpectMap = map[string]testExpect{
 
     tracetest.go:<LINE>: chan.go:<LINE>: t1: send: unexpected event data
 `},
+
+	"TestExpectMatchOK": {0, "Hi, T1·A\n"},
+
+	"TestExpectMatchMismatch": {1,
+`--- FAIL: TestExpectMatchMismatch (<TIME>)
+    example_test.go:<LINE>: t1: expect: tracetest_test.eventHi: mismatch: want prefix "T2·"
+        have: T1·A
+
+    example_test.go:<LINE>: test shutdown: #streams: 1,  #(pending events): 0
+        # t1
+
+    tracetest.go:<LINE>: chan.go:<LINE>: t1: send: want prefix "T2·"
+`},
+
+	"TestAssertTraceMismatch": {1,
+`--- FAIL: TestAssertTraceMismatch (<TIME>)
+    tracefile_test.go:<LINE>: trace does not match expected:
+        diff:
+         {
+          n1/a: [
+           {
+            Stream: "n1/a",
+            Type: "tracetest_test.eventOn",
+        -   Data: "a2",
+        +   Data: "a1",
+           },
+          ],
+         }
+
+    tracefile_test.go:<LINE>: test shutdown: #streams: 1,  #(pending events): 0
+        # n1/a
+`},
+
+	"TestStrictStreams": {1,
+`--- FAIL: TestStrictStreams (<TIME>)
+    tracetest.go:<LINE>: test shutdown: #streams: 1,  #(pending events): 0
+        # n1/a
+
+    tracetest.go:<LINE>: tracetest.go:<LINE>: n1/c: unexpected stream: tracetest_test.eventOn {n1/c c1}
+`},
+
+	"TestRxEventAck": {1,
+`--- FAIL: TestRxEventAck (<TIME>)
+    tracetest_test.go:<LINE>: n1/a: expect: tracetest_test.eventOn:
+        want: {n1/a a2}
+        have: {n1/a a1}
+        diff:
+         {
+          Stream: "n1/a",
+        - What: "a2",
+        + What: "a1",
+         }
+
+    tracetest_test.go:<LINE>: test shutdown: #streams: 1,  #(pending events): 0
+        # n1/a
+
+    tracetest_test.go:<LINE>: producer: cleanup after n1/a: send: unexpected event data
+`},
+
+	"TestRxEventTagged": {1,
+`--- FAIL: TestRxEventTagged (<TIME>)
+    tracetest_test.go:<LINE>: n1/a: expect: tracetest_test.eventOn:
+        want: {n1/a a2}
+        have: {n1/a a1}
+        tags: [node=n1 req=1]
+        diff:
+         {
+          Stream: "n1/a",
+        - What: "a2",
+        + What: "a1",
+         }
+
+    tracetest_test.go:<LINE>: test shutdown: #streams: 2,  #(pending events): 1
+        n1/b	<- tracetest_test.eventOn {n1/b b1}  [req=2]
+        # n1/a
+`},
+
+	"TestExpectWithinFail": {1,
+`--- FAIL: TestExpectWithinFail (<TIME>)
+    tracetest_test.go:<LINE>: n1/a: expect within 10ms: tracetest_test.eventOn {n1/a a2} came after <TIME>
+    tracetest_test.go:<LINE>: test shutdown: #streams: 1,  #(pending events): 0
+        # n1/a
+
+    tracetest.go:<LINE>: chan.go:<LINE>: n1/a: send: event came too late
+`},
+
+	"TestRaceSummary": {1,
+`tracetest: race summary: 1 failure(s)
+	TestRaceSummary/race: delay@0(=x:0)
+`},
 }
@@ -0,0 +1,106 @@
+// Copyright (C) 2026  Nexedi SA and Contributors.
+//                     Kirill Smelkov <kirr@nexedi.com>
+//
+// This program is free software: you can Use, Study, Modify and Redistribute
+// it under the terms of the GNU General Public License version 3, or (at your
+// option) any later version, as published by the Free Software Foundation.
+//
+// You can also Link and Combine this program with other software covered by
+// the terms of any of the Free Software licenses or any of the Open Source
+// Initiative approved licenses and Convey the resulting work. Corresponding
+// source of such a combination shall include the source code for all other
+// software used.
+//
+// This program is distributed WITHOUT ANY WARRANTY; without even the implied
+// warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+//
+// See COPYING file for full licensing terms.
+// See https://www.nexedi.com/licensing for rationale and options.
+
+package tracetest
+// saving and loading traces for offline comparison.
+
+import (
+	"encoding/json"
+	"io"
+	"reflect"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+// TraceEntry represents one event of a saved trace.
+//
+// Since events are of arbitrary types, an event is saved in the form provided
+// by user encoder - as name of event type and event data.
+type TraceEntry struct {
+	Stream string `json:"stream"`
+	Type   string `json:"type"`
+	Data   string `json:"data"`
+}
+
+// EventEncoder encodes an event into name of its type and its data.
+type EventEncoder func(event interface{}) (typ, data string)
+
+// TraceEntries returns events received by t so far encoded with enc.
+//
+// Events are returned in the order they were received via RxEvent.
+// See also Trace which returns the events as they are.
+func (t *T) TraceEntries(enc EventEncoder) []TraceEntry {
+	t.mu.Lock()
+	tracev := make([]eventTrace, len(t.tracev))
+	copy(tracev, t.tracev)
+	t.mu.Unlock()
+
+	entryv := []TraceEntry{}
+	for _, e := range tracev {
+		typ, data := enc(e.event)
+		entryv = append(entryv, TraceEntry{Stream: e.stream, Type: typ, Data: data})
+	}
+	return entryv
+}
+
+// SaveTrace saves events received by t so far to w in JSON format.
+//
+// Events are encoded with enc. The saved trace can be loaded back with LoadTrace.
+func (t *T) SaveTrace(w io.Writer, enc EventEncoder) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	return e.Encode(t.TraceEntries(enc))
+}
+
+// LoadTrace loads trace previously saved by SaveTrace.
+func LoadTrace(r io.Reader) ([]TraceEntry, error) {
+	var entryv []TraceEntry
+	err := json.NewDecoder(r).Decode(&entryv)
+	if err != nil {
+		return nil, err
+	}
+	return entryv, nil
+}
+
+// AssertTraceMatches asserts that events received by t so far match expected trace.
+//
+// Events are encoded with enc for the comparison. Since events on different
+// streams are not ordered with respect to each other, the traces are compared
+// stream by stream: for every stream the sequence of its events must be the
+// same in both traces.
+//
+// If traces do not match - fatal testing error is raised.
+func (t *T) AssertTraceMatches(expected []TraceEntry, enc EventEncoder) {
+	t.Helper()
+
+	have := streamEntries(t.TraceEntries(enc))
+	want := streamEntries(expected)
+	if !reflect.DeepEqual(have, want) {
+		t.Fatalf("trace does not match expected:\ndiff:\n%s\n\n", pretty.Compare(want, have))
+	}
+}
+
+// streamEntries splits trace into per-stream sequences of events.
+func streamEntries(entryv []TraceEntry) map[/*stream*/string][]TraceEntry {
+	streamTab := make(map[string][]TraceEntry)
+	for _, e := range entryv {
+		streamTab[e.Stream] = append(streamTab[e.Stream], e)
+	}
+	return streamTab
+}
@@ -0,0 +1,124 @@
+// Copyright (C) 2026  Nexedi SA and Contributors.
+//                     Kirill Smelkov <kirr@nexedi.com>
+//
+// This program is free software: you can Use, Study, Modify and Redistribute
+// it under the terms of the GNU General Public License version 3, or (at your
+// option) any later version, as published by the Free Software Foundation.
+//
+// You can also Link and Combine this program with other software covered by
+// the terms of any of the Free Software licenses or any of the Open Source
+// Initiative approved licenses and Convey the resulting work. Corresponding
+// source of such a combination shall include the source code for all other
+// software used.
+//
+// This program is distributed WITHOUT ANY WARRANTY; without even the implied
+// warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+//
+// See COPYING file for full licensing terms.
+// See https://www.nexedi.com/licensing for rationale and options.
+
+package tracetest_test
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+
+	"lab.nexedi.com/kirr/go123/tracing/tracetest"
+)
+
+// TestSaveLoadTrace verifies that trace saved via SaveTrace can be loaded back
+// and compared against trace of another run.
+func TestSaveLoadTrace(t *testing.T) {
+	// run2Threads runs the same system as Test2ThreadsOK does.
+	run2Threads := func(t *tracetest.T) {
+		var wg sync.WaitGroup
+		defer wg.Wait()
+		wg.Add(2)
+
+		go func() { // thread1
+			defer wg.Done()
+			hi("T1·A")
+			hello("T1·B")
+		}()
+
+		go func() { // thread2
+			defer wg.Done()
+			hello("T2·C")
+			hi("T2·D")
+		}()
+
+		t.Expect("t2", eventHello("T2·C"))
+		t.Expect("t2", eventHi("T2·D"))
+		t.Expect("t1", eventHi("T1·A"))
+		t.Expect("t1", eventHello("T1·B"))
+	}
+
+	enc := func(event interface{}) (typ, data string) {
+		return fmt.Sprintf("%T", event), fmt.Sprintf("%v", event)
+	}
+
+	run := func(f func(t *tracetest.T)) {
+		tracetest.Run(t, func(t *tracetest.T) {
+			pg := setupTracing(t)
+			defer pg.Done()
+			t.SetEventRouter(routeEvent)
+			f(t)
+		})
+	}
+
+	// first run - save the trace
+	buf := &bytes.Buffer{}
+	run(func(t *tracetest.T) {
+		run2Threads(t)
+		err := t.SaveTrace(buf, enc)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	expected, err := tracetest.LoadTrace(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expected) != 4 {
+		t.Fatalf("loaded trace: %d events; want 4", len(expected))
+	}
+
+	// second run - compare its trace against saved one
+	run(func(t *tracetest.T) {
+		run2Threads(t)
+		t.AssertTraceMatches(expected, enc)
+	})
+}
+
+// TestAssertTraceMismatch verifies that AssertTraceMatches reports difference
+// in between received and expected traces.
+func TestAssertTraceMismatch(t *testing.T) {
+	enc := func(event interface{}) (typ, data string) {
+		return fmt.Sprintf("%T", event), event.(eventOn).What
+	}
+
+	verifyInSubprocess(t, func(t *testing.T) {
+		tracetest.Run(t, func(t *tracetest.T) {
+			t.SetEventRouter(routeEventOn)
+
+			var wg sync.WaitGroup
+			defer wg.Wait()
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+				t.RxEvent(eventOn{"n1/a", "a1"})
+			}()
+
+			t.Expect("n1/a", eventOn{"n1/a", "a1"})
+			wg.Wait()
+
+			t.AssertTraceMatches([]tracetest.TraceEntry{
+				{Stream: "n1/a", Type: "tracetest_test.eventOn", Data: "a2"},
+			}, enc)
+		})
+	})
+}
@@ -102,7 +102,9 @@ package tracetest
 // http://www.1024cores.net/home/relacy-race-detector/rrd-introduction
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"path/filepath"
 	"reflect"
 	"runtime"
@@ -136,19 +138,36 @@ type T struct {
 
 	mu             sync.Mutex
 	streamTab      map[/*stream*/string]*_chan // where events on stream are delivered; set to nil on test shutdown
+	streamNew      chan struct{}               // closed and replaced when new stream is added to streamTab
 	routeEvent     func(event interface{}) (stream string)
+	strictStreams  map[/*stream*/string]bool // !nil -> events are allowed only on these streams
 	tracev         []eventTrace // record of events as they happen
 	delayInjectTab map[/*stream*/string]*delayInjectState
 
 	nakq []nak    // naks queued to be sent after Fatal
 	logq []string // queued log messages prepared in fatalfInNonMain
+
+	output io.Writer // !nil -> diagnostics are also written here; see SetOutput
+
+	tstart time.Time // when the test was started; see ExpectWithin
 }
 
 // eventTrace keeps information about one event T received via RxEvent.
 type eventTrace struct {
-	t      time.Time   // time of receive; monotonic
+	t      time.Time         // time of receive; monotonic
 	stream string
 	event  interface{}
+	tags   map[string]string
+}
+
+// Event represents one event recorded by T.
+//
+// See T.Trace for details.
+type Event struct {
+	Stream string            // stream the event was routed to
+	Value  interface{}       // the event itself
+	Time   time.Time         // time when the event was received via RxEvent
+	Tags   map[string]string // metadata attached via RxEventTagged; nil for RxEvent
 }
 
 // delayInjectState is used by delay-injector to find out for which event on a
@@ -173,7 +192,9 @@ func run(t testing.TB, f func(t *T), delayInjectTab map[string]*delayInjectState
 	tT := &T{
 		_testing_TB:    t,
 		streamTab:      make(map[string]*_chan),
+		streamNew:      make(chan struct{}),
 		delayInjectTab: delayInjectTab,
+		tstart:         time.Now(),
 	}
 
 	// verify in the end that no events are left unchecked / unconsumed,
@@ -250,7 +271,15 @@ func Verify(t *testing.T, f func(t *T)) {
 			}
 		}
 
-		t.Run(fmt.Sprintf("delay@%d(=%s:%d)", i, stream, istream), func(t *testing.T) {
+		delay := fmt.Sprintf("delay@%d(=%s:%d)", i, stream, istream)
+		test  := t.Name()
+		t.Run(delay, func(t *testing.T) {
+			defer func() {
+				if t.Failed() {
+					raceSummary.add(test, delay)
+				}
+			}()
+
 			tT := run(t, f, map[string]*delayInjectState{
 				stream: &delayInjectState{
 					delayAt: istream,
@@ -273,6 +302,64 @@ func Verify(t *testing.T, f func(t *T)) {
 }
 
 
+// raceSummary collects, if enabled, failures detected by Verify with injected delays.
+var raceSummary raceCollector
+
+type raceCollector struct {
+	mu       sync.Mutex
+	enabled  bool
+	failurev []raceFailure
+}
+
+// raceFailure describes one Verify run that failed with injected delay.
+type raceFailure struct {
+	test  string // name of the test that called Verify
+	delay string // delay injection combination, e.g. "delay@0(=x:0)"
+}
+
+// EnableRaceSummary enables collection of failures that Verify detects with
+// injected delays.
+//
+// It is intended to be called from TestMain together with RaceSummary to
+// print combined report after all tests are run:
+//
+//	func TestMain(m *testing.M) {
+//		tracetest.EnableRaceSummary()
+//		code := m.Run()
+//		fmt.Print(tracetest.RaceSummary())
+//		os.Exit(code)
+//	}
+func EnableRaceSummary() {
+	raceSummary.mu.Lock()
+	defer raceSummary.mu.Unlock()
+	raceSummary.enabled = true
+}
+
+// RaceSummary returns report about failures collected since EnableRaceSummary.
+func RaceSummary() string {
+	raceSummary.mu.Lock()
+	defer raceSummary.mu.Unlock()
+
+	if len(raceSummary.failurev) == 0 {
+		return "tracetest: race summary: no failures\n"
+	}
+	s := fmt.Sprintf("tracetest: race summary: %d failure(s)\n", len(raceSummary.failurev))
+	for _, f := range raceSummary.failurev {
+		s += fmt.Sprintf("\t%s: %s\n", f.test, f.delay)
+	}
+	return s
+}
+
+// add records failure of test with injected delay, if collection is enabled.
+func (rc *raceCollector) add(test, delay string) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.enabled {
+		rc.failurev = append(rc.failurev, raceFailure{test, delay})
+	}
+}
+
+
 // T overrides FailNow/Fatal/Fatalf to also cancel all in-progress sends.
 func (t *T) FailNow() {
 	t.Helper()
@@ -350,7 +437,7 @@ func (t *T) closeStreamTab() (nnak int) {
 
 	pending := fmt.Sprintf("test shutdown: #streams: %d,  #(pending events): %d\n", len(streams), len(sendv))
 	for _, __ := range sendv {
-		pending += fmt.Sprintf("%s\t<- %T %v\n", __.ch.name, __.msg.Event, __.msg.Event)
+		pending += fmt.Sprintf("%s\t<- %T %v%s\n", __.ch.name, __.msg.Event, __.msg.Event, tagsSuffix(__.msg.tags))
 	}
 	for _, ch := range quietv {
 		pending += fmt.Sprintf("# %s\n", ch.name)
@@ -360,6 +447,7 @@ func (t *T) closeStreamTab() (nnak int) {
 	// nak them only after details printout, so that our text comes first,
 	// and their "panics" don't get intermixed with it.
 	t.Log(pending)
+	t.writeOutput(pending)
 	for _, __ := range t.nakq {
 		__.msg.nak(__.why)
 		nnak++
@@ -410,6 +498,44 @@ func (t *T) SetEventRouter(routeEvent func(event interface{}) (stream string)) {
 }
 
 
+// SetStrictStreams tells t that events are allowed to go only to specified streams.
+//
+// If an event is routed to a stream not in allowed, the test fails
+// immediately with details about the event, instead of reporting it only as
+// pending at test shutdown. This helps to catch misrouting early.
+func (t *T) SetStrictStreams(allowed ...string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.strictStreams = make(map[string]bool, len(allowed))
+	for _, stream := range allowed {
+		t.strictStreams[stream] = true
+	}
+}
+
+// Trace returns events received by T so far, ordered by time of receive.
+//
+// It can be used to assert on the whole event sequence programmatically.
+// It can be called also after f, that T was created for, completes.
+//
+// See also TraceEntries which returns the events in encoded form.
+func (t *T) Trace() []Event {
+	t.mu.Lock()
+	tracev := make([]eventTrace, len(t.tracev))
+	copy(tracev, t.tracev)
+	t.mu.Unlock()
+
+	sort.SliceStable(tracev, func(i, j int) bool {
+		return tracev[i].t.Before(tracev[j].t)
+	})
+
+	eventv := make([]Event, len(tracev))
+	for i, e := range tracev {
+		eventv[i] = Event{Stream: e.stream, Value: e.event, Time: e.t, Tags: e.tags}
+	}
+	return eventv
+}
+
 // chanForStream returns channel corresponding to stream.
 // must be called under mu.
 func (t *T) chanForStream(stream string) *_chan {
@@ -421,6 +547,9 @@ func (t *T) chanForStream(stream string) *_chan {
 	if !ok {
 		ch = t.newChan(stream)
 		t.streamTab[stream] = ch
+		// wakeup ExpectAny waiters
+		close(t.streamNew)
+		t.streamNew = make(chan struct{})
 	}
 	return ch
 }
@@ -431,6 +560,46 @@ func (t *T) chanForStream(stream string) *_chan {
 // paused until RxEvent returns. This requirement can be usually met via
 // inserting t.RxEvent() call into the code that produces the event.
 func (t *T) RxEvent(event interface{}) {
+	t.RxEventTagged(event, nil)
+}
+
+// RxEventTagged is like RxEvent but additionally attaches tags to the event.
+//
+// Tags are not compared by Expect. They are only included into diagnostics
+// printed on failures, e.g. to help correlating the event with logs, and are
+// reported by Trace.
+func (t *T) RxEventTagged(event interface{}, tags map[string]string) {
+	ch, rxTime, err := t.rxEventPre(event, tags)
+	if err != nil {
+		t.fatalfInNonMain("%s", err)
+	}
+	ch.Send(event, tags, rxTime)
+}
+
+// RxEventAck is like RxEvent but returns an error instead of terminating
+// calling goroutine if the event was not accepted.
+//
+// The error is returned e.g. if the event was nak'ed by main testing goroutine
+// due to unexpected type or value, or if the event could not be delivered at
+// all. This allows producer to observe the rejection and clean up.
+func (t *T) RxEventAck(event interface{}) error {
+	ch, rxTime, err := t.rxEventPre(event, nil)
+	if err != nil {
+		if !errors.Is(err, errPreSendCanceled) {
+			t.errorfInNonMain("%s", err)
+		}
+		return err
+	}
+	return ch.SendAck(event, nil, rxTime)
+}
+
+var errPreSendCanceled = errors.New("(pre)send: canceled (test failed)")
+
+// rxEventPre routes and records event for RxEvent and RxEventAck.
+//
+// It returns channel to which the event should be sent and time when the
+// event was received.
+func (t *T) rxEventPre(event interface{}, tags map[string]string) (*_chan, time.Time, error) {
 	t0 := time.Now()
 	stream := ""
 	t.mu.Lock()
@@ -440,7 +609,11 @@ func (t *T) RxEvent(event interface{}) {
 	if stream == "" {
 		stream = "default"
 	}
-	t.tracev = append(t.tracev, eventTrace{t0, stream, event})
+	if t.strictStreams != nil && !t.strictStreams[stream] {
+		t.mu.Unlock()
+		return nil, t0, fmt.Errorf("%s: unexpected stream: %T %v", stream, event, event)
+	}
+	t.tracev = append(t.tracev, eventTrace{t0, stream, event, tags})
 	ch := t.chanForStream(stream)
 
 	var delay time.Duration
@@ -455,14 +628,14 @@ func (t *T) RxEvent(event interface{}) {
 	t.mu.Unlock()
 
 	if ch == nil {
-		t.fatalfInNonMain("%s: (pre)send: canceled (test failed)", stream)
+		return nil, t0, fmt.Errorf("%s: %w", stream, errPreSendCanceled)
 	}
 
 	if delay != 0 {
 		time.Sleep(delay)
 	}
 
-	ch.Send(event)
+	return ch, t0, nil
 }
 
 // xget1 gets 1 event in place and checks it has expected type
@@ -492,6 +665,185 @@ func (t *T) Expect(stream string, eventOK interface{}) {
 	msg.Ack()
 }
 
+// ExpectAny receives next event on any stream under prefix and verifies it to be equal to eventOK.
+//
+// Stream names can form hierarchy, e.g. "node1/raft" and "node1/wal". ExpectAny
+// allows to expect an event on any stream whose name starts with prefix
+// without enumerating every such stream, e.g. ExpectAny("node1/", ...).
+//
+// If check is successful ACK is sent back to event producer.
+// If check does not pass - fatal testing error is raised.
+func (t *T) ExpectAny(prefix string, eventOK interface{}) {
+	t.Helper()
+
+	reventExpect := reflect.ValueOf(eventOK)
+	reventp := reflect.New(reventExpect.Type())
+
+	ch, msg := t.xgetAny(prefix, reventp.Interface())
+	t.xcheckValue(ch.name, msg, reventp.Elem(), reventExpect)
+	msg.Ack()
+}
+
+// ExpectMatch receives next event on stream and verifies it with match.
+//
+// It is similar to Expect, but is handy for events with nondeterministic
+// fields, e.g. timestamps or ids, whose values are not known in advance. The
+// event is first checked to have the same type as typ, and then match is
+// called with it. match should return an error describing the mismatch if the
+// event is not what is expected.
+//
+// If check is successful ACK is sent back to event producer.
+// If check does not pass - fatal testing error is raised and the event
+// producer is NAK'ed with the error returned by match.
+func (t *T) ExpectMatch(stream string, typ interface{}, match func(event interface{}) error) {
+	t.Helper()
+
+	reventp := reflect.New(reflect.TypeOf(typ))
+	msg := t.xget1(stream, reventp.Interface())
+
+	event := reventp.Elem().Interface()
+	err := match(event)
+	if err != nil {
+		t.queuenak(msg, err.Error())
+		t.Fatalf("%s: expect: %s: mismatch: %s\nhave: %v%s\n\n",
+			stream, reventp.Elem().Type(), err, event, tagsSuffix(msg.tags))
+	}
+	msg.Ack()
+}
+
+// ExpectSet receives len(events) events on stream and verifies them to be
+// equal to events in any order.
+//
+// It is handy when several independent events must all happen, but order in
+// which they happen is not deterministic.
+//
+// Every received event is ACK'ed as soon as it is found to be equal to one of
+// not yet matched expected events. If a received event does not match any of
+// them - fatal testing error is raised and the event producer is NAK'ed.
+func (t *T) ExpectSet(stream string, events ...interface{}) {
+	t.Helper()
+
+	t.mu.Lock()
+	ch := t.chanForStream(stream)
+	t.mu.Unlock()
+
+	if ch == nil {
+		t.Fatalf("%s: recv: canceled (test failed)", stream)
+	}
+
+	pending := append([]interface{}(nil), events...)
+	for len(pending) > 0 {
+		msg := ch.Recv()
+
+		i := -1
+		for j, event := range pending {
+			if reflect.DeepEqual(msg.Event, event) {
+				i = j
+				break
+			}
+		}
+
+		if i == -1 {
+			t.queuenak(msg, "unexpected event")
+			want := ""
+			for _, event := range pending {
+				want += fmt.Sprintf("\t%T %v\n", event, event)
+			}
+			t.Fatalf("%s: expect set: unexpected event:\nhave: %T %v%s\nwant any of:\n%s\n",
+				stream, msg.Event, msg.Event, tagsSuffix(msg.tags), want)
+		}
+
+		pending = append(pending[:i], pending[i+1:]...)
+		msg.Ack()
+	}
+}
+
+// ExpectWithin receives next event on stream and verifies it to be equal to
+// eventOK and to come not later than maxGap after previous event on the
+// stream.
+//
+// The gap is measured in between times when the events were received via
+// RxEvent. For the first event on the stream the gap is measured from the
+// start of the test.
+//
+// Verify injects delays in between events, so ExpectWithin is meaningful only
+// for tests ran via Run.
+//
+// If check is successful ACK is sent back to event producer.
+// If check does not pass - fatal testing error is raised.
+func (t *T) ExpectWithin(stream string, eventOK interface{}, maxGap time.Duration) {
+	t.Helper()
+
+	t.mu.Lock()
+	ch := t.chanForStream(stream)
+	t.mu.Unlock()
+
+	if ch == nil {
+		t.Fatalf("%s: recv: canceled (test failed)", stream)
+	}
+
+	prev := ch.rxLast
+	if prev.IsZero() {
+		prev = t.tstart
+	}
+	msg := t.expect1(stream, eventOK)
+	gap := msg.rxTime.Sub(prev)
+	if gap > maxGap {
+		t.queuenak(msg, "event came too late")
+		t.Fatalf("%s: expect within %s: %T %v came after %s%s\n",
+			stream, maxGap, msg.Event, msg.Event, gap, tagsSuffix(msg.tags))
+	}
+	msg.Ack()
+}
+
+// xgetAny gets 1 event from any stream under prefix and checks it has expected type.
+//
+// if checks do not pass - fatal testing error is raised
+func (t *T) xgetAny(prefix string, eventp interface{}) (*_chan, *_Msg) {
+	t.Helper()
+
+	deadline := time.After(*deadTime)
+	for {
+		// channels currently under prefix + notification about new streams
+		t.mu.Lock()
+		streamTab := t.streamTab
+		streamNew := t.streamNew
+		var chv []*_chan
+		for stream, ch := range streamTab {
+			if strings.HasPrefix(stream, prefix) {
+				chv = append(chv, ch)
+			}
+		}
+		t.mu.Unlock()
+
+		if streamTab == nil {
+			t.Fatalf("%s*: recv: canceled (test failed)", prefix)
+		}
+
+		casev := []reflect.SelectCase{
+			{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(deadline)},
+			{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(streamNew)},
+		}
+		for _, ch := range chv {
+			casev = append(casev, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ch.msgq)})
+		}
+
+		i, rmsg, _ := reflect.Select(casev)
+		switch i {
+		case 0:
+			t.Fatalf("%s*: recv: deadlock waiting for %T\n", prefix, eventp)
+		case 1:
+			continue // new stream appeared - rescan
+		}
+
+		ch := chv[i-2]
+		msg := rmsg.Interface().(*_Msg)
+		ch.rxLast = msg.rxTime
+		ch.xsetInto(msg, eventp)
+		return ch, msg
+	}
+}
+
 // TODO ExpectNoACK? (then it would be possible to receive events from 2
 // streams; have those 2 processes paused and inspect their state. After
 // inspection unpause both)
@@ -510,17 +862,52 @@ func (t *T) expect1(stream string, eventExpect interface{}) *_Msg {
 
 	reventp := reflect.New(reventExpect.Type())
 	msg := t.xget1(stream, reventp.Interface())
-	revent := reventp.Elem()
+	t.xcheckValue(stream, msg, reventp.Elem(), reventExpect)
+	return msg
+}
+
+// xcheckValue verifies that received event is equal to expected one.
+//
+// if check does not pass - fatal testing error is raised.
+func (t *T) xcheckValue(stream string, msg *_Msg, revent, reventExpect reflect.Value) {
+	t.Helper()
 
 	if !reflect.DeepEqual(revent.Interface(), reventExpect.Interface()) {
 		t.queuenak(msg, "unexpected event data")
-		t.Fatalf("%s: expect: %s:\nwant: %v\nhave: %v\ndiff:\n%s\n\n",
+		tags := ""
+		if len(msg.tags) != 0 {
+			tags = fmt.Sprintf("tags: %s\n", fmtTags(msg.tags))
+		}
+		t.Fatalf("%s: expect: %s:\nwant: %v\nhave: %v\n%sdiff:\n%s\n\n",
 			stream,
-			reventExpect.Type(), reventExpect, revent,
+			reventExpect.Type(), reventExpect, revent, tags,
 			pretty.Compare(reventExpect.Interface(), revent.Interface()))
 	}
+}
+
+// fmtTags formats event tags for diagnostics.
+//
+// Tags are formatted as "[k1=v1 k2=v2 ...]" with keys sorted.
+func fmtTags(tags map[string]string) string {
+	keyv := make([]string, 0, len(tags))
+	for k := range tags {
+		keyv = append(keyv, k)
+	}
+	sort.Strings(keyv)
 
-	return msg
+	tagv := make([]string, len(keyv))
+	for i, k := range keyv {
+		tagv[i] = k + "=" + tags[k]
+	}
+	return "[" + strings.Join(tagv, " ") + "]"
+}
+
+// tagsSuffix returns "  [tags...]" to be appended to event printout, or "" if there are no tags.
+func tagsSuffix(tags map[string]string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	return "  " + fmtTags(tags)
 }
 
 // fatalfInNonMain should be called for fatal cases in non-main goroutines instead of panic.
@@ -529,6 +916,21 @@ func (t *T) expect1(stream string, eventExpect interface{}) *_Msg {
 // goroutine to print detailed reason for e.g. deadlock or other error.
 func (t *T) fatalfInNonMain(format string, argv ...interface{}) {
 	t.Helper()
+	t.failInNonMain(format, argv...)
+	runtime.Goexit()
+}
+
+// errorfInNonMain is like fatalfInNonMain but does not terminate calling goroutine.
+func (t *T) errorfInNonMain(format string, argv ...interface{}) {
+	t.Helper()
+	t.failInNonMain(format, argv...)
+}
+
+// failInNonMain serves fatalfInNonMain and errorfInNonMain.
+//
+// it must be called directly by them.
+func (t *T) failInNonMain(format string, argv ...interface{}) {
+	t.Helper()
 
 	if !strings.HasSuffix(format, "\n") {
 		format += "\n"
@@ -539,7 +941,7 @@ func (t *T) fatalfInNonMain(format string, argv ...interface{}) {
 	// manually include file:line so that message is logged with correct
 	// location when emitted via logq.
 	// XXX t.Helper() not taken into account
-	f := xruntime.Traceback(2)[0] // XXX we need only first caller, not full traceback
+	f := xruntime.Traceback(3)[0] // XXX we need only first caller, not full traceback
 	msg = fmt.Sprintf("%s:%d: %s", filepath.Base(f.File), f.Line, msg)
 
 	// serialize fatal log+traceback printout, so that such printouts from
@@ -547,6 +949,7 @@ func (t *T) fatalfInNonMain(format string, argv ...interface{}) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
+	t.writeOutput(msg)
 	if t.streamTab == nil {
 		// t is over -> log directly.
 		// make sure to prefix log message the same way as would be
@@ -560,7 +963,27 @@ func (t *T) fatalfInNonMain(format string, argv ...interface{}) {
 	}
 
 	t.Fail()
-	runtime.Goexit()
+}
+
+// SetOutput makes T to also write its diagnostics to w.
+//
+// The diagnostics are printout of pending events on test shutdown and
+// failures detected in non-main goroutines, e.g. naks received by event
+// producers. By default they are only logged via t.Log. SetOutput is useful
+// for tooling that runs tracetest outside of go test.
+func (t *T) SetOutput(w io.Writer) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.output = w
+}
+
+// writeOutput writes msg to output set via SetOutput, if any.
+//
+// must be called with t.mu held.
+func (t *T) writeOutput(msg string) {
+	if t.output != nil {
+		io.WriteString(t.output, msg) // ignore err
+	}
 }
 
 // logFromTracetest_go calls t.Log without wrapping it with t.Helper().
@@ -0,0 +1,378 @@
+// Copyright (C) 2026  Nexedi SA and Contributors.
+//                     Kirill Smelkov <kirr@nexedi.com>
+//
+// This program is free software: you can Use, Study, Modify and Redistribute
+// it under the terms of the GNU General Public License version 3, or (at your
+// option) any later version, as published by the Free Software Foundation.
+//
+// You can also Link and Combine this program with other software covered by
+// the terms of any of the Free Software licenses or any of the Open Source
+// Initiative approved licenses and Convey the resulting work. Corresponding
+// source of such a combination shall include the source code for all other
+// software used.
+//
+// This program is distributed WITHOUT ANY WARRANTY; without even the implied
+// warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+//
+// See COPYING file for full licensing terms.
+// See https://www.nexedi.com/licensing for rationale and options.
+
+package tracetest_test
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"lab.nexedi.com/kirr/go123/tracing/tracetest"
+)
+
+// eventOn is event that explicitly tells to which stream it should go.
+type eventOn struct {
+	Stream string
+	What   string
+}
+
+func routeEventOn(event interface{}) (stream string) {
+	return event.(eventOn).Stream
+}
+
+// TestExpectAny verifies ExpectAny on hierarchical stream names.
+func TestExpectAny(t *testing.T) {
+	tracetest.Run(t, func(t *tracetest.T) {
+		t.SetEventRouter(routeEventOn)
+
+		var wg sync.WaitGroup
+		defer wg.Wait()
+		wg.Add(2)
+
+		go func() {
+			defer wg.Done()
+			// streams under n1/ appear only after ExpectAny starts waiting
+			time.Sleep(10*time.Millisecond)
+			t.RxEvent(eventOn{"n1/a", "a1"})
+			t.RxEvent(eventOn{"n1/b", "b1"})
+			t.RxEvent(eventOn{"n1/a", "a2"})
+		}()
+
+		go func() {
+			defer wg.Done()
+			t.RxEvent(eventOn{"n2/x", "x1"})
+		}()
+
+		t.ExpectAny("n1/", eventOn{"n1/a", "a1"})
+		t.ExpectAny("n1/", eventOn{"n1/b", "b1"})
+		t.Expect("n2/x", eventOn{"n2/x", "x1"})
+		t.ExpectAny("n1/", eventOn{"n1/a", "a2"})
+	})
+}
+
+// TestExpectSet verifies that ExpectSet accepts events in any order.
+func TestExpectSet(t *testing.T) {
+	orderv := [][]string{
+		{"a", "b", "c"},
+		{"c", "a", "b"},
+		{"b", "c", "a"},
+	}
+
+	for _, order := range orderv {
+		order := order
+		t.Run(strings.Join(order, ""), func(t *testing.T) {
+			tracetest.Run(t, func(t *tracetest.T) {
+				t.SetEventRouter(routeEventOn)
+
+				var wg sync.WaitGroup
+				defer wg.Wait()
+				wg.Add(2)
+
+				// one producer emits events sequentially in order
+				go func() {
+					defer wg.Done()
+					for _, what := range order {
+						t.RxEvent(eventOn{"s1", what})
+					}
+				}()
+
+				// and here every event is emitted by its own producer
+				go func() {
+					defer wg.Done()
+					var wg2 sync.WaitGroup
+					defer wg2.Wait()
+					for _, what := range order {
+						what := what
+						wg2.Add(1)
+						go func() {
+							defer wg2.Done()
+							t.RxEvent(eventOn{"s2", what})
+						}()
+					}
+				}()
+
+				t.ExpectSet("s1", eventOn{"s1", "a"}, eventOn{"s1", "b"}, eventOn{"s1", "c"})
+				t.ExpectSet("s2", eventOn{"s2", "a"}, eventOn{"s2", "b"}, eventOn{"s2", "c"})
+			})
+		})
+	}
+}
+
+// TestTrace verifies that recorded events are accessible after Run.
+func TestTrace(t *testing.T) {
+	var tT *tracetest.T
+	tracetest.Run(t, func(t *tracetest.T) {
+		tT = t
+		t.SetEventRouter(routeEventOn)
+
+		var wg sync.WaitGroup
+		defer wg.Wait()
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			t.RxEvent(eventOn{"a", "a1"})
+			t.RxEvent(eventOn{"b", "b1"})
+			t.RxEvent(eventOn{"a", "a2"})
+		}()
+
+		t.Expect("a", eventOn{"a", "a1"})
+		t.Expect("b", eventOn{"b", "b1"})
+		t.Expect("a", eventOn{"a", "a2"})
+	})
+
+	tracev := tT.Trace()
+	want := []eventOn{{"a", "a1"}, {"b", "b1"}, {"a", "a2"}}
+	if len(tracev) != len(want) {
+		t.Fatalf("trace: have %d events  ; want %d", len(tracev), len(want))
+	}
+	for i, e := range tracev {
+		if !(e.Stream == want[i].Stream && e.Value == want[i]) {
+			t.Errorf("trace[%d]: have %s: %v  ; want %s: %v", i, e.Stream, e.Value, want[i].Stream, want[i])
+		}
+		if i > 0 && e.Time.Before(tracev[i-1].Time) {
+			t.Errorf("trace[%d]: time goes back", i)
+		}
+	}
+}
+
+// TestStrictStreams verifies that with SetStrictStreams an event routed to
+// unexpected stream is reported immediately.
+func TestStrictStreams(t *testing.T) {
+	verifyInSubprocess(t, func(t *testing.T) {
+		tracetest.Run(t, func(t *tracetest.T) {
+			t.SetEventRouter(routeEventOn)
+			t.SetStrictStreams("n1/a", "n1/b")
+
+			var wg sync.WaitGroup
+			defer wg.Wait()
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+				t.RxEvent(eventOn{"n1/a", "a1"})
+				t.RxEvent(eventOn{"n1/c", "c1"})
+				t.RxEvent(eventOn{"n1/b", "b1"}) // not reached
+			}()
+
+			t.Expect("n1/a", eventOn{"n1/a", "a1"})
+		})
+	})
+}
+
+// TestRxEventAck verifies that producer using RxEventAck gets nak as error
+// and can clean up.
+func TestRxEventAck(t *testing.T) {
+	verifyInSubprocess(t, func(t *testing.T) {
+		tracetest.Run(t, func(t *tracetest.T) {
+			t.SetEventRouter(routeEventOn)
+
+			var wg sync.WaitGroup
+			defer wg.Wait()
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+				err := t.RxEventAck(eventOn{"n1/a", "a1"})
+				if err != nil {
+					t.Logf("producer: cleanup after %s", err)
+					return
+				}
+				t.RxEvent(eventOn{"n1/a", "a2"}) // not reached
+			}()
+
+			t.Expect("n1/a", eventOn{"n1/a", "a2"})
+		})
+	})
+}
+
+// TestRxEventTagged verifies that event tags are included into failure printouts.
+func TestRxEventTagged(t *testing.T) {
+	verifyInSubprocess(t, func(t *testing.T) {
+		tracetest.Run(t, func(t *tracetest.T) {
+			t.SetEventRouter(routeEventOn)
+
+			var wg sync.WaitGroup
+			defer wg.Wait()
+			wg.Add(2)
+
+			go func() {
+				defer wg.Done()
+				t.RxEventTagged(eventOn{"n1/a", "a1"}, map[string]string{"req": "1", "node": "n1"})
+			}()
+			go func() {
+				defer wg.Done()
+				t.RxEventTagged(eventOn{"n1/b", "b1"}, map[string]string{"req": "2"})
+			}()
+
+			time.Sleep(100*time.Millisecond) // let n1/b event become pending
+			t.Expect("n1/a", eventOn{"n1/a", "a2"})
+		})
+	})
+}
+
+// TestExpectWithin verifies ExpectWithin on events that come in time.
+func TestExpectWithin(t *testing.T) {
+	tracetest.Run(t, func(t *tracetest.T) {
+		t.SetEventRouter(routeEventOn)
+
+		var wg sync.WaitGroup
+		defer wg.Wait()
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			t.RxEvent(eventOn{"n1/a", "a1"})
+			t.RxEvent(eventOn{"n1/a", "a2"})
+		}()
+
+		t.ExpectWithin("n1/a", eventOn{"n1/a", "a1"}, 10*time.Second)
+		t.ExpectWithin("n1/a", eventOn{"n1/a", "a2"}, 10*time.Second)
+	})
+}
+
+// TestExpectWithinFail verifies that ExpectWithin fails on event that comes too late.
+func TestExpectWithinFail(t *testing.T) {
+	verifyInSubprocess(t, func(t *testing.T) {
+		tracetest.Run(t, func(t *tracetest.T) {
+			t.SetEventRouter(routeEventOn)
+
+			var wg sync.WaitGroup
+			defer wg.Wait()
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+				t.RxEvent(eventOn{"n1/a", "a1"})
+				time.Sleep(100*time.Millisecond)
+				t.RxEvent(eventOn{"n1/a", "a2"})
+			}()
+
+			t.Expect("n1/a", eventOn{"n1/a", "a1"})
+			t.ExpectWithin("n1/a", eventOn{"n1/a", "a2"}, 10*time.Millisecond)
+		})
+	})
+}
+
+// failTB is testing.TB that records failure instead of failing the test.
+type failTB struct {
+	testing.TB
+	mu     sync.Mutex
+	failed bool
+}
+
+func (f *failTB) Fail() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failed = true
+}
+
+func (f *failTB) Failed() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.failed
+}
+
+func (f *failTB) Log(argv ...interface{})                 {}
+func (f *failTB) Logf(format string, argv ...interface{}) {}
+
+// TestSetOutput verifies that diagnostics are written to output set via SetOutput.
+func TestSetOutput(t *testing.T) {
+	var mu sync.Mutex
+	var out strings.Builder
+	output := writerFunc(func(p []byte) (int, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return out.Write(p)
+	})
+
+	tb := &failTB{TB: t}
+	var wg sync.WaitGroup
+	tracetest.Run(tb, func(t *tracetest.T) {
+		t.SetEventRouter(routeEventOn)
+		t.SetOutput(output)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			t.RxEvent(eventOn{"n1/a", "a1"})
+		}()
+
+		time.Sleep(100*time.Millisecond) // let n1/a event become pending
+		// return without consuming the event
+	})
+	wg.Wait()
+
+	if !tb.Failed() {
+		t.Fatal("test with unconsumed event did not fail")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	pendingOK := "test shutdown: #streams: 1,  #(pending events): 1\n" +
+		"n1/a\t<- tracetest_test.eventOn {n1/a a1}\n"
+	if !strings.HasPrefix(out.String(), pendingOK) {
+		t.Fatalf("output:\n%s\nwant prefix:\n%s", out.String(), pendingOK)
+	}
+	if !strings.Contains(out.String(), "n1/a: send: canceled (test failed)") {
+		t.Fatalf("output:\n%s\nwant nak printout", out.String())
+	}
+}
+
+// writerFunc adapts function to io.Writer.
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
+
+// TestRaceSummary verifies that race summary lists delay injection that
+// triggered failure.
+func TestRaceSummary(t *testing.T) {
+	verifyInSubprocess(t, func(t *testing.T) {
+		tracetest.EnableRaceSummary()
+
+		t.Run("race", func(t *testing.T) {
+			tracetest.Verify(t, func(t *tracetest.T) {
+				t.SetEventRouter(routeEventOn)
+
+				var wg sync.WaitGroup
+				defer wg.Wait()
+				wg.Add(2)
+
+				// A and B are not synchronized (see TestRace)
+				go func() {
+					defer wg.Done()
+					t.RxEvent(eventOn{"x", "A"})
+				}()
+				go func() {
+					defer wg.Done()
+					time.Sleep(100*time.Millisecond)
+					t.RxEvent(eventOn{"x", "B"})
+				}()
+
+				t.Expect("x", eventOn{"x", "A"})
+				t.Expect("x", eventOn{"x", "B"})
+			})
+		})
+
+		fmt.Print(tracetest.RaceSummary())
+	})
+}
@@ -40,14 +40,29 @@ func NewReader(r io.Reader) *Reader {
 		return r
 	}
 
+	cr := countReader(r)
+	return &Reader{bufio.NewReader(xio.BindCtxR(cr, context.Background())), cr}
+}
+
+// Reset discards any buffered data and switches r to read from newr.
+//
+// Logical position in input stream is counted anew starting from 0, even if
+// newr is itself a counted reader. This allows to reuse the same Reader object
+// for several inputs.
+func (r *Reader) Reset(newr io.Reader) {
+	r.cr = xio.CountReader(xio.WithCtxR(newr))
+	r.Reader.Reset(xio.BindCtxR(r.cr, context.Background()))
+}
+
+// countReader returns xio.CountedReader corresponding to r.
+func countReader(r io.Reader) *xio.CountedReader {
 	// idempotent(xio.CountedReader)
 	xr := xio.WithCtxR(r)
 	cr, ok := xr.(*xio.CountedReader)
 	if !ok {
 		cr = xio.CountReader(xr)
 	}
-
-	return &Reader{bufio.NewReader(xio.BindCtxR(cr, context.Background())), cr}
+	return cr
 }
 
 // InputOffset returns current logical position in input stream.
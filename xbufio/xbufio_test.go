@@ -0,0 +1,82 @@
+// Copyright (C) 2026  Nexedi SA and Contributors.
+//                     Kirill Smelkov <kirr@nexedi.com>
+//
+// This program is free software: you can Use, Study, Modify and Redistribute
+// it under the terms of the GNU General Public License version 3, or (at your
+// option) any later version, as published by the Free Software Foundation.
+//
+// You can also Link and Combine this program with other software covered by
+// the terms of any of the Free Software licenses or any of the Open Source
+// Initiative approved licenses and Convey the resulting work. Corresponding
+// source of such a combination shall include the source code for all other
+// software used.
+//
+// This program is distributed WITHOUT ANY WARRANTY; without even the implied
+// warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+//
+// See COPYING file for full licensing terms.
+// See https://www.nexedi.com/licensing for rationale and options.
+
+package xbufio
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"lab.nexedi.com/kirr/go123/xio"
+)
+
+func TestReaderReset(t *testing.T) {
+	r := NewReader(strings.NewReader("hello world"))
+
+	data := make([]byte, 5)
+	_, err := r.Read(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !(string(data) == "hello" && r.InputOffset() == 5) {
+		t.Fatalf("read -> %q @%d  ; want \"hello\" @5", data, r.InputOffset())
+	}
+
+	// after Reset reading continues from new source and offset starts from 0
+	r.Reset(strings.NewReader("abc"))
+	if off := r.InputOffset(); off != 0 {
+		t.Fatalf("after reset: offset = %d  ; want 0", off)
+	}
+
+	all, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !(string(all) == "abc" && r.InputOffset() == 3) {
+		t.Fatalf("after reset: read -> %q @%d  ; want \"abc\" @3", all, r.InputOffset())
+	}
+}
+
+// Reset onto xio.CountedReader that already read something must also start
+// counting from 0.
+func TestReaderResetCounted(t *testing.T) {
+	ctx := context.Background()
+	cr := xio.CountReader(xio.WithCtxR(strings.NewReader("hello world")))
+	data := make([]byte, 6)
+	_, err := cr.Read(ctx, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewReader(strings.NewReader("abc"))
+	r.Reset(xio.BindCtxR(cr, ctx))
+	if off := r.InputOffset(); off != 0 {
+		t.Fatalf("after reset: offset = %d  ; want 0", off)
+	}
+
+	all, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !(string(all) == "world" && r.InputOffset() == 5) {
+		t.Fatalf("after reset: read -> %q @%d  ; want \"world\" @5", all, r.InputOffset())
+	}
+}
@@ -46,10 +46,14 @@
 //
 //		...
 //	}
+//
+// Do captures this pattern: it runs a function under merged context and
+// reports which parent, if any, caused the function to fail.
 package xcontext
 
 import (
 	"context"
+	"fmt"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -263,3 +267,39 @@ func (c chanCtx) Deadline() (time.Time, bool) {
 func (c chanCtx) Value(key interface{}) interface{} {
 	return nil
 }
+
+
+// Do runs f under context merged from ctx1 and ctx2.
+//
+// The merged context is canceled after f returns. If f fails while ctx1 or
+// ctx2 is done, the error is returned wrapped into *ParentDoneError telling
+// which parent caused the failure. Other errors are returned as is.
+func Do(ctx1, ctx2 context.Context, f func(ctx context.Context) error) error {
+	ctx, cancel := Merge(ctx1, ctx2)
+	defer cancel()
+
+	err := f(ctx)
+	if err != nil && ctx.Err() != nil {
+		switch {
+		case ctx1.Err() != nil:
+			err = &ParentDoneError{Parent: 1, Err: err}
+		case ctx2.Err() != nil:
+			err = &ParentDoneError{Parent: 2, Err: err}
+		}
+	}
+	return err
+}
+
+// ParentDoneError is returned by Do when f failed due to one of merged
+// contexts becoming done.
+type ParentDoneError struct {
+	Parent int   // 1 or 2 - which parent context was done
+	Err    error // error returned by f
+}
+
+func (e *ParentDoneError) Error() string {
+	return fmt.Sprintf("ctx%d done: %s", e.Parent, e.Err)
+}
+
+func (e *ParentDoneError) Cause() error  { return e.Err }
+func (e *ParentDoneError) Unwrap() error { return e.Err }
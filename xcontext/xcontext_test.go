@@ -22,6 +22,7 @@ package xcontext
 
 import (
 	"context"
+	"errors"
 	"testing"
 	"time"
 )
@@ -215,3 +216,52 @@ func mergeNoWait(parent1, parent2 context.Context) *mergeCtx {
 		cancelCh: make(chan struct{}),
 	}
 }
+
+func TestDo(t *testing.T) {
+	bg := context.Background()
+	errJob := errors.New("job failed")
+
+	// f fails by itself -> error is returned as is; merged ctx is canceled after Do
+	var fctx context.Context
+	err := Do(bg, bg, func(ctx context.Context) error {
+		fctx = ctx
+		return errJob
+	})
+	if err != errJob {
+		t.Fatalf("Do: err = %v  ; want %v", err, errJob)
+	}
+	if fctx.Err() != context.Canceled {
+		t.Fatalf("Do: after return: ctx.Err = %v  ; want %v", fctx.Err(), context.Canceled)
+	}
+
+	// f fails due to parent cancel -> ParentDoneError with that parent
+	for _, parent := range []int{1, 2} {
+		ctx1, cancel1 := context.WithCancel(bg)
+		ctx2, cancel2 := context.WithCancel(bg)
+		err = Do(ctx1, ctx2, func(ctx context.Context) error {
+			if parent == 1 {
+				cancel1()
+			} else {
+				cancel2()
+			}
+			<-ctx.Done()
+			return ctx.Err()
+		})
+		cancel1()
+		cancel2()
+
+		e, ok := err.(*ParentDoneError)
+		if !(ok && e.Parent == parent && e.Err == context.Canceled) {
+			t.Fatalf("Do: cancel parent%d: err = %#v  ; want ParentDoneError{%d, canceled}", parent, err, parent)
+		}
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("Do: cancel parent%d: err does not unwrap to context.Canceled", parent)
+		}
+	}
+
+	// success
+	err = Do(bg, bg, func(ctx context.Context) error { return nil })
+	if err != nil {
+		t.Fatalf("Do: success: err = %v", err)
+	}
+}
@@ -36,6 +36,9 @@
 // returned error.  Please see package github.com/pkg/errors for details on
 // this topic.
 //
+// Annotatef is similar to Contextf but adds the context only to errors for
+// which provided predicate is true.
+//
 // # Error vector
 //
 // Sometimes there are several operations performed and we want to collect
@@ -71,6 +74,9 @@ package xerr
 
 import (
 	"fmt"
+	"io"
+	"reflect"
+	"strings"
 
 	"github.com/pkg/errors"
 )
@@ -83,6 +89,9 @@ type Errorv []error
 //   - ""			if len(errv)==0
 //   - errv[0].Error()	if len(errv)==1
 //   - "<n> errors:\n" + string representation of every error on separate line, otherwise.
+//
+// Nested error vectors are indented one level deeper so that the whole error
+// tree remains readable.
 func (errv Errorv) Error() string {
 	switch len(errv) {
 	case 0:
@@ -93,11 +102,52 @@ func (errv Errorv) Error() string {
 
 	msg := fmt.Sprintf("%d errors:\n", len(errv))
 	for _, e := range errv {
-		msg += fmt.Sprintf("\t- %s\n", e)
+		emsg := e.Error()
+		if _, nested := e.(Errorv); nested {
+			emsg = strings.TrimSuffix(emsg, "\n")
+			emsg = strings.ReplaceAll(emsg, "\n", "\n\t")
+		}
+		msg += fmt.Sprintf("\t- %s\n", emsg)
 	}
 	return msg
 }
 
+// Format implements fmt.Formatter.
+//
+// %+v prints every error in the vector with %+v, indented similarly to Error,
+// so that e.g. stack traces of errors from github.com/pkg/errors are preserved.
+// %v and %s print the same as Error.
+func (errv Errorv) Format(s fmt.State, verb rune) {
+	switch {
+	case verb == 'v' && s.Flag('#'):
+		// Go-syntax representation, as if there would be no Format
+		fmt.Fprintf(s, "xerr.Errorv%s", strings.TrimPrefix(fmt.Sprintf("%#v", []error(errv)), "[]error"))
+
+	case verb == 'v' && s.Flag('+'):
+		switch len(errv) {
+		case 0:
+			return
+		case 1:
+			fmt.Fprintf(s, "%+v", errv[0])
+			return
+		}
+
+		fmt.Fprintf(s, "%d errors:\n", len(errv))
+		for _, e := range errv {
+			emsg := fmt.Sprintf("%+v", e)
+			emsg = strings.TrimSuffix(emsg, "\n")
+			emsg = strings.ReplaceAll(emsg, "\n", "\n\t")
+			fmt.Fprintf(s, "\t- %s\n", emsg)
+		}
+
+	case verb == 'q':
+		fmt.Fprintf(s, "%q", errv.Error())
+
+	default:
+		io.WriteString(s, errv.Error())
+	}
+}
+
 // Append appends err to error vector.
 func (errv *Errorv) Append(err error) {
 	*errv = append(*errv, err)
@@ -132,6 +182,27 @@ func (errv Errorv) Err() error {
 	}
 }
 
+// CausesAre returns whether every error in the vector matches one of targets.
+//
+// Matching is done via errors.Is, so errors that wrap targets, e.g. with
+// added context, match as well. The order of errors and targets does not
+// matter. It is handy in tests to assert on errors collected into a vector.
+func (errv Errorv) CausesAre(targets ...error) bool {
+	for _, err := range errv {
+		match := false
+		for _, target := range targets {
+			if errors.Is(err, target) {
+				match = true
+				break
+			}
+		}
+		if !match {
+			return false
+		}
+	}
+	return true
+}
+
 // Merge merges non-nil errors into one error.
 //
 // it returns:
@@ -181,8 +252,11 @@ func First(errv ...error) error {
 //	if myerr != nil {
 //		myerr = errors.WithMessage(myerr, "while doing something")
 //	}
+//
+// If *errp is nil, or is non-nil interface holding nil pointer (typed nil),
+// it is left untouched.
 func Context(errp *error, context string) {
-	if *errp == nil {
+	if isNil(*errp) {
 		return
 	}
 	*errp = errors.WithMessage(*errp, context)
@@ -192,9 +266,47 @@ func Context(errp *error, context string) {
 //
 // Contextf is formatted analog of Context. Please see Context for details on how to use.
 func Contextf(errp *error, format string, argv ...interface{}) {
-	if *errp == nil {
+	if isNil(*errp) {
+		return
+	}
+
+	*errp = errors.WithMessage(*errp, fmt.Sprintf(format, argv...))
+}
+
+// Annotatef is like Contextf but adds context only if pred(*errp) is true.
+//
+// It is handy to avoid adding noisy context to errors of particular kind, e.g.
+//
+//	defer xerr.Annotatef(&err, func(err error) bool {
+//		return !errors.Is(err, context.Canceled)
+//	}, "doing something")
+func Annotatef(errp *error, pred func(error) bool, format string, argv ...interface{}) {
+	if isNil(*errp) || !pred(*errp) {
 		return
 	}
 
 	*errp = errors.WithMessage(*errp, fmt.Sprintf(format, argv...))
 }
+
+// isNil returns whether err is nil or is typed nil.
+//
+// Typed nil is non-nil error interface holding nil value of pointer-like
+// type, for example:
+//
+//	var obj *T
+//	var err error = obj	// err != nil, but isNil(err) = true
+//
+// The check for typed nil uses reflect, which is relatively costly. This cost
+// is paid only for non-nil errors, i.e. only on error paths.
+func isNil(err error) bool {
+	if err == nil {
+		return true
+	}
+
+	v := reflect.ValueOf(err)
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan, reflect.Interface:
+		return v.IsNil()
+	}
+	return false
+}
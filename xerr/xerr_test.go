@@ -20,9 +20,12 @@
 package xerr
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"io"
 	"reflect"
+	"strings"
 	"testing"
 
 	pkgerrors "github.com/pkg/errors"
@@ -107,6 +110,82 @@ func TestErrorv(t *testing.T) {
 	}()
 }
 
+func TestErrorvNested(t *testing.T) {
+	err1 := errors.New("err1")
+	err2 := errors.New("err2")
+	err3 := errors.New("err3")
+	err4 := errors.New("err4")
+
+	testv := []struct {
+		errv   Errorv
+		errmsg string
+	}{
+		{Errorv{err1, Errorv{err2, err3}},
+`2 errors:
+	- err1
+	- 2 errors:
+		- err2
+		- err3
+`},
+
+		{Errorv{Errorv{err1, Errorv{err2, err3}}, err4},
+`2 errors:
+	- 2 errors:
+		- err1
+		- 2 errors:
+			- err2
+			- err3
+	- err4
+`},
+
+		// nested Errorv with 1 element is formatted as that element
+		{Errorv{Errorv{err1}, err2},
+`2 errors:
+	- err1
+	- err2
+`},
+	}
+
+	for _, tt := range testv {
+		msg := tt.errv.Error()
+		if msg != tt.errmsg {
+			t.Errorf("%#v: Error() ->\n%s\nwant:\n%s", tt.errv, msg, tt.errmsg)
+		}
+	}
+}
+
+func TestCausesAre(t *testing.T) {
+	errA := errors.New("a")
+	errB := errors.New("b")
+	errC := errors.New("c")
+	wrapped := errA
+	Contextf(&wrapped, "ctx")
+	errv := Errorv{wrapped, errB}
+
+	testv := []struct {
+		targets []error
+		ok      bool
+	}{
+		{[]error{errA, errB}, true},
+		{[]error{errB, errA}, true},
+		{[]error{errA, errB, errC}, true},
+		{[]error{errA}, false},
+		{[]error{errA, errC}, false},
+		{nil, false},
+	}
+
+	for _, tt := range testv {
+		ok := errv.CausesAre(tt.targets...)
+		if ok != tt.ok {
+			t.Errorf("%q: causes are %v: %v  ; want %v", errv, tt.targets, ok, tt.ok)
+		}
+	}
+
+	if !(Errorv{}).CausesAre() {
+		t.Errorf("empty errv: causes are []: false  ; want true")
+	}
+}
+
 func TestMerge(t *testing.T) {
 	e := errors.New("e")
 	e2 := errors.New("e2")
@@ -205,3 +284,90 @@ func TestContext(t *testing.T) {
 		t.Errorf("Contextf(%v) -> %v -> cause %v  ; want %v", err, e, ec, err)
 	}
 }
+
+func TestAnnotatef(t *testing.T) {
+	notCanceled := func(err error) bool {
+		return err != context.Canceled
+	}
+	test := func(e error) (err error) {
+		defer Annotatef(&err, notCanceled, "test ctx %d", 123)
+		return e
+	}
+
+	if test(nil) != nil {
+		t.Error("Annotatef(nil) -> !nil")
+	}
+
+	err := errors.New("an error")
+	e := test(err)
+	want := "test ctx 123: an error"
+	if !(e != nil && e.Error() == want) {
+		t.Errorf("Annotatef(%v) -> %v  ; want %v", err, e, want)
+	}
+	if ec := pkgerrors.Cause(e); ec != err {
+		t.Errorf("Annotatef(%v) -> %v -> cause %v  ; want %v", err, e, ec, err)
+	}
+
+	e = test(context.Canceled)
+	if e != context.Canceled {
+		t.Errorf("Annotatef(%v) -> %v  ; want untouched", context.Canceled, e)
+	}
+}
+
+// myError is error type used to test typed nil handling.
+type myError struct{}
+
+func (e *myError) Error() string { return "my error" }
+
+func TestContextTypedNil(t *testing.T) {
+	var eptr *myError
+	var err error = eptr // typed nil: err != nil
+
+	Context(&err, "test ctx")
+	if err != error(eptr) {
+		t.Errorf("Context(typed nil) -> %#v  ; want untouched", err)
+	}
+
+	Contextf(&err, "testf ctx %d", 123)
+	if err != error(eptr) {
+		t.Errorf("Contextf(typed nil) -> %#v  ; want untouched", err)
+	}
+
+	// nil Errorv is also treated as nil
+	var errv Errorv
+	err = errv
+	Context(&err, "test ctx")
+	if _, ok := err.(Errorv); !ok {
+		t.Errorf("Context(nil Errorv) -> %#v  ; want untouched", err)
+	}
+}
+
+func TestErrorvFormat(t *testing.T) {
+	err1 := errors.New("err1")
+	err2 := pkgerrors.New("err2")
+	errv := Errorv{err1, err2}
+
+	// %v and %s are the same as Error
+	for _, format := range []string{"%v", "%s"} {
+		msg := fmt.Sprintf(format, errv)
+		if msg != errv.Error() {
+			t.Errorf("%s -> %q  ; want %q", format, msg, errv.Error())
+		}
+	}
+
+	// %+v preserves stack of pkg/errors error
+	msg := fmt.Sprintf("%+v", errv)
+	if !strings.HasPrefix(msg, "2 errors:\n\t- err1\n\t- err2\n\t") {
+		t.Errorf("%%+v: unexpected prefix:\n%s", msg)
+	}
+	if !strings.Contains(msg, "xerr.TestErrorvFormat\n\t\t") {
+		t.Errorf("%%+v: no stack frame:\n%s", msg)
+	}
+
+	// %#v is Go-syntax
+	msg = fmt.Sprintf("%#v", Errorv{io.EOF})
+	want := `xerr.Errorv{(*errors.errorString)(`
+	if !strings.HasPrefix(msg, want) {
+		t.Errorf("%%#v -> %q  ; want prefix %q", msg, want)
+	}
+}
@@ -0,0 +1,194 @@
+// Copyright (C) 2026  Nexedi SA and Contributors.
+//                     Kirill Smelkov <kirr@nexedi.com>
+//
+// This program is free software: you can Use, Study, Modify and Redistribute
+// it under the terms of the GNU General Public License version 3, or (at your
+// option) any later version, as published by the Free Software Foundation.
+//
+// You can also Link and Combine this program with other software covered by
+// the terms of any of the Free Software licenses or any of the Open Source
+// Initiative approved licenses and Convey the resulting work. Corresponding
+// source of such a combination shall include the source code for all other
+// software used.
+//
+// This program is distributed WITHOUT ANY WARRANTY; without even the implied
+// warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+//
+// See COPYING file for full licensing terms.
+// See https://www.nexedi.com/licensing for rationale and options.
+
+package xio
+// buffering for Reader and Writer
+
+import (
+	"context"
+	"io"
+)
+
+const defaultBufSize = 4096
+
+// BufReader implements buffering for a Reader.
+//
+// It is similar to bufio.Reader, but, contrary to bufio.NewReader(BindCtxR(r, ctx)),
+// context of every Read is passed to underlying reader when the buffer is refilled.
+// Reads served from the buffer do not look at ctx.
+//
+// BufReader is not safe to use from multiple goroutines simultaneously.
+type BufReader struct {
+	rd   Reader
+	buf  []byte
+	r, w int   // buf[r:w] is data buffered but not yet read
+	err  error // error that came with the last refill; reported after buffered data
+}
+
+// NewBufReader wraps r with BufReader with buffer of default size.
+func NewBufReader(r Reader) *BufReader {
+	return NewBufReaderSize(r, defaultBufSize)
+}
+
+// NewBufReaderSize wraps r with BufReader with buffer of specified size.
+func NewBufReaderSize(r Reader, size int) *BufReader {
+	return &BufReader{rd: r, buf: make([]byte, size)}
+}
+
+// Buffered returns number of bytes that can be read from the buffer.
+func (b *BufReader) Buffered() int {
+	return b.w - b.r
+}
+
+// Read implements Reader.
+//
+// It reads data from the buffer, refilling it with at most one Read from
+// underlying reader if the buffer is empty. If len(p) is not less than buffer
+// size and the buffer is empty, data is read into p directly.
+func (b *BufReader) Read(ctx context.Context, p []byte) (n int, err error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	if b.r == b.w {
+		if b.err != nil {
+			err, b.err = b.err, nil
+			return 0, err
+		}
+
+		// refill. Don't rely on underlying reader to check ctx.
+		err = ctx.Err()
+		if err != nil {
+			return 0, err
+		}
+
+		if len(p) >= len(b.buf) {
+			// large read - avoid copy
+			return b.rd.Read(ctx, p)
+		}
+
+		b.r, b.w = 0, 0
+		n, err = b.rd.Read(ctx, b.buf)
+		if n == 0 {
+			return 0, err
+		}
+		b.w = n
+		b.err = err
+	}
+
+	n = copy(p, b.buf[b.r:b.w])
+	b.r += n
+	return n, nil
+}
+
+// BufWriter implements buffering for a Writer.
+//
+// It is similar to bufio.Writer, but, contrary to bufio.NewWriter(BindCtxW(w, ctx)),
+// context of every Write and Flush is passed to underlying writer when
+// buffered data is flushed. Writes that only add data to the buffer do not
+// look at ctx.
+//
+// After all data has been written, the client should call Flush to make sure
+// it is passed to underlying writer.
+//
+// BufWriter is not safe to use from multiple goroutines simultaneously.
+type BufWriter struct {
+	wr  Writer
+	buf []byte
+	n   int // buf[:n] is data buffered but not yet written
+}
+
+// NewBufWriter wraps w with BufWriter with buffer of default size.
+func NewBufWriter(w Writer) *BufWriter {
+	return NewBufWriterSize(w, defaultBufSize)
+}
+
+// NewBufWriterSize wraps w with BufWriter with buffer of specified size.
+func NewBufWriterSize(w Writer, size int) *BufWriter {
+	return &BufWriter{wr: w, buf: make([]byte, size)}
+}
+
+// Buffered returns number of bytes written into the buffer.
+func (b *BufWriter) Buffered() int {
+	return b.n
+}
+
+// Available returns how many bytes are unused in the buffer.
+func (b *BufWriter) Available() int {
+	return len(b.buf) - b.n
+}
+
+// Flush writes buffered data to underlying writer.
+//
+// On error, data that was not written stays in the buffer and will be
+// written by next Flush.
+func (b *BufWriter) Flush(ctx context.Context) error {
+	if b.n == 0 {
+		return nil
+	}
+
+	// don't rely on underlying writer to check ctx.
+	err := ctx.Err()
+	if err != nil {
+		return err
+	}
+
+	n, err := b.wr.Write(ctx, b.buf[:b.n])
+	if n < b.n && err == nil {
+		err = io.ErrShortWrite
+	}
+	copy(b.buf, b.buf[n:b.n])
+	b.n -= n
+	return err
+}
+
+// Write implements Writer.
+//
+// It writes p into the buffer, flushing the buffer to underlying writer when
+// it becomes full. If the buffer is empty and p does not fit into it, p is
+// written to underlying writer directly.
+func (b *BufWriter) Write(ctx context.Context, p []byte) (nn int, err error) {
+	for len(p) > b.Available() {
+		var n int
+		if b.n == 0 {
+			// large write with empty buffer - avoid copy
+			err = ctx.Err()
+			if err == nil {
+				n, err = b.wr.Write(ctx, p)
+				if n < len(p) && err == nil {
+					err = io.ErrShortWrite
+				}
+			}
+		} else {
+			n = copy(b.buf[b.n:], p)
+			b.n += n
+			err = b.Flush(ctx)
+		}
+		nn += n
+		p = p[n:]
+		if err != nil {
+			return nn, err
+		}
+	}
+
+	n := copy(b.buf[b.n:], p)
+	b.n += n
+	nn += n
+	return nn, nil
+}
@@ -0,0 +1,149 @@
+// Copyright (C) 2026  Nexedi SA and Contributors.
+//                     Kirill Smelkov <kirr@nexedi.com>
+//
+// This program is free software: you can Use, Study, Modify and Redistribute
+// it under the terms of the GNU General Public License version 3, or (at your
+// option) any later version, as published by the Free Software Foundation.
+//
+// You can also Link and Combine this program with other software covered by
+// the terms of any of the Free Software licenses or any of the Open Source
+// Initiative approved licenses and Convey the resulting work. Corresponding
+// source of such a combination shall include the source code for all other
+// software used.
+//
+// This program is distributed WITHOUT ANY WARRANTY; without even the implied
+// warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+//
+// See COPYING file for full licensing terms.
+// See https://www.nexedi.com/licensing for rationale and options.
+
+package xio
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// nioReader is Reader that counts Reads issued to it.
+type nioReader struct {
+	r   io.Reader
+	nio int
+}
+
+func (r *nioReader) Read(ctx context.Context, p []byte) (int, error) {
+	r.nio++
+	return r.r.Read(p)
+}
+
+func TestBufReader(t *testing.T) {
+	bg := context.Background()
+	canceled, cancel := context.WithCancel(bg)
+	cancel()
+
+	ur := &nioReader{r: strings.NewReader("hello world")}
+	r := NewBufReaderSize(ur, 8)
+	buf := make([]byte, 3)
+
+	xread := func(ctx context.Context, ok string, nio int) {
+		t.Helper()
+		n, err := r.Read(ctx, buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf[:n]) != ok || ur.nio != nio {
+			t.Fatalf("read -> %q, #io=%d  ; want %q, #io=%d", buf[:n], ur.nio, ok, nio)
+		}
+	}
+
+	xread(bg, "hel", 1)
+	xread(bg, "lo ", 1)
+	// data is served from buffer even with canceled ctx
+	xread(canceled, "wo", 1)
+
+	// refill with canceled ctx -> ctx.Err() without IO
+	_, err := r.Read(canceled, buf)
+	if !(err == context.Canceled && ur.nio == 1) {
+		t.Fatalf("refill with canceled ctx -> %v, #io=%d  ; want %v, #io=1", err, ur.nio, context.Canceled)
+	}
+
+	xread(bg, "rld", 2)
+	_, err = r.Read(bg, buf)
+	if err != io.EOF {
+		t.Fatalf("read at end -> %v  ; want EOF", err)
+	}
+
+	// ctx is passed to underlying reader on refill
+	pr, pw := Pipe()
+	defer pw.Close()
+	r = NewBufReader(pr)
+	ctx, cancel := context.WithTimeout(bg, 10*time.Millisecond)
+	defer cancel()
+	_, err = r.Read(ctx, buf)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("blocked refill -> %v  ; want %v", err, context.DeadlineExceeded)
+	}
+}
+
+// nioWriter is Writer that counts Writes issued to it.
+type nioWriter struct {
+	bytes.Buffer
+	nio int
+}
+
+func (w *nioWriter) Write(ctx context.Context, p []byte) (int, error) {
+	w.nio++
+	return w.Buffer.Write(p)
+}
+
+func TestBufWriter(t *testing.T) {
+	bg := context.Background()
+	canceled, cancel := context.WithCancel(bg)
+	cancel()
+
+	uw := &nioWriter{}
+	w := NewBufWriterSize(uw, 8)
+
+	xwrite := func(ctx context.Context, data string) {
+		t.Helper()
+		n, err := w.Write(ctx, []byte(data))
+		if !(n == len(data) && err == nil) {
+			t.Fatalf("write %q -> %d, %v", data, n, err)
+		}
+	}
+
+	// writes into buffer do not perform IO nor look at ctx
+	xwrite(bg, "hello")
+	xwrite(canceled, " ")
+	if !(uw.nio == 0 && w.Buffered() == 6 && w.Available() == 2) {
+		t.Fatalf("after buffered writes: #io=%d, buffered=%d, available=%d", uw.nio, w.Buffered(), w.Available())
+	}
+
+	// flush with canceled ctx -> ctx.Err(), data stays buffered
+	err := w.Flush(canceled)
+	if !(err == context.Canceled && w.Buffered() == 6 && uw.nio == 0) {
+		t.Fatalf("flush with canceled ctx -> %v, buffered=%d, #io=%d", err, w.Buffered(), uw.nio)
+	}
+	n, err := w.Write(canceled, []byte("world"))
+	if !(err == context.Canceled && n == 2) {
+		t.Fatalf("write overflowing buffer with canceled ctx -> %d, %v  ; want 2, %v", n, err, context.Canceled)
+	}
+
+	xwrite(bg, "rld")
+	err = w.Flush(bg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !(uw.String() == "hello world" && uw.nio == 2) {
+		t.Fatalf("written: %q, #io=%d  ; want %q, #io=2", uw.String(), uw.nio, "hello world")
+	}
+
+	// large write with empty buffer goes directly
+	xwrite(bg, "0123456789")
+	if !(uw.String() == "hello world0123456789" && uw.nio == 3 && w.Buffered() == 0) {
+		t.Fatalf("large write: %q, #io=%d, buffered=%d", uw.String(), uw.nio, w.Buffered())
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright (C) 2026  Nexedi SA and Contributors.
+//                     Kirill Smelkov <kirr@nexedi.com>
+//
+// This program is free software: you can Use, Study, Modify and Redistribute
+// it under the terms of the GNU General Public License version 3, or (at your
+// option) any later version, as published by the Free Software Foundation.
+//
+// You can also Link and Combine this program with other software covered by
+// the terms of any of the Free Software licenses or any of the Open Source
+// Initiative approved licenses and Convey the resulting work. Corresponding
+// source of such a combination shall include the source code for all other
+// software used.
+//
+// This program is distributed WITHOUT ANY WARRANTY; without even the implied
+// warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+//
+// See COPYING file for full licensing terms.
+// See https://www.nexedi.com/licensing for rationale and options.
+
+package xio
+// framing with checksum
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// ErrCorrupt is returned by CheckedFrameReader when a frame fails integrity check.
+var ErrCorrupt = errors.New("xio: frame corrupt")
+
+// maxFrameSize limits payload size of checked frames.
+//
+// It prevents huge allocations when frame length itself is corrupted.
+const maxFrameSize = 16<<20
+
+// Checked frame on the wire is
+//
+//	len(payload) [4]byte  big-endian
+//	payload
+//	crc32(payload) [4]byte  big-endian, IEEE
+
+// CheckedFrameWriter writes frames, each protected by CRC32 checksum, to underlying Writer.
+//
+// Use CheckedFrameReader to read the frames back.
+type CheckedFrameWriter struct {
+	w Writer
+}
+
+// NewCheckedFrameWriter creates new CheckedFrameWriter writing to w.
+func NewCheckedFrameWriter(w Writer) *CheckedFrameWriter {
+	return &CheckedFrameWriter{w: w}
+}
+
+// WriteFrame writes one frame with payload to underlying writer.
+//
+// The frame is written with one Write call. Payload must not be larger than 16MB.
+func (fw *CheckedFrameWriter) WriteFrame(ctx context.Context, payload []byte) error {
+	if len(payload) > maxFrameSize {
+		return fmt.Errorf("xio: write frame: payload too large (%d > %d)", len(payload), maxFrameSize)
+	}
+
+	frame := make([]byte, 4 + len(payload) + 4)
+	binary.BigEndian.PutUint32(frame, uint32(len(payload)))
+	copy(frame[4:], payload)
+	binary.BigEndian.PutUint32(frame[4+len(payload):], crc32.ChecksumIEEE(payload))
+
+	n, err := fw.w.Write(ctx, frame)
+	if n < len(frame) && err == nil {
+		err = io.ErrShortWrite
+	}
+	return err
+}
+
+// CheckedFrameReader reads frames written by CheckedFrameWriter and verifies their checksum.
+type CheckedFrameReader struct {
+	r Reader
+}
+
+// NewCheckedFrameReader creates new CheckedFrameReader reading from r.
+func NewCheckedFrameReader(r Reader) *CheckedFrameReader {
+	return &CheckedFrameReader{r: r}
+}
+
+// ReadFrame reads next frame from underlying reader and returns its payload.
+//
+// If the frame checksum does not match, or frame length is invalid, ErrCorrupt
+// is returned. io.EOF is returned only if input ends at frame boundary; input
+// ending in the middle of a frame results in io.ErrUnexpectedEOF.
+func (fr *CheckedFrameReader) ReadFrame(ctx context.Context) ([]byte, error) {
+	r := BindCtxR(fr.r, ctx)
+
+	var hdr [4]byte
+	_, err := io.ReadFull(r, hdr[:])
+	if err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(hdr[:])
+	if size > maxFrameSize {
+		return nil, ErrCorrupt
+	}
+
+	buf := make([]byte, size + 4)
+	_, err = io.ReadFull(r, buf)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	payload := buf[:size]
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(buf[size:]) {
+		return nil, ErrCorrupt
+	}
+	return payload, nil
+}
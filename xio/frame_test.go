@@ -0,0 +1,92 @@
+// Copyright (C) 2026  Nexedi SA and Contributors.
+//                     Kirill Smelkov <kirr@nexedi.com>
+//
+// This program is free software: you can Use, Study, Modify and Redistribute
+// it under the terms of the GNU General Public License version 3, or (at your
+// option) any later version, as published by the Free Software Foundation.
+//
+// You can also Link and Combine this program with other software covered by
+// the terms of any of the Free Software licenses or any of the Open Source
+// Initiative approved licenses and Convey the resulting work. Corresponding
+// source of such a combination shall include the source code for all other
+// software used.
+//
+// This program is distributed WITHOUT ANY WARRANTY; without even the implied
+// warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+//
+// See COPYING file for full licensing terms.
+// See https://www.nexedi.com/licensing for rationale and options.
+
+package xio
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestCheckedFrame(t *testing.T) {
+	bg := context.Background()
+
+	// xwriteFrames returns wire data for frames.
+	xwriteFrames := func(framev ...string) []byte {
+		t.Helper()
+		var buf bytes.Buffer
+		fw := NewCheckedFrameWriter(WithCtxW(&buf))
+		for _, frame := range framev {
+			err := fw.WriteFrame(bg, []byte(frame))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		return buf.Bytes()
+	}
+
+	// readFrames reads frames from r until error.
+	readFrames := func(r Reader) ([]string, error) {
+		fr := NewCheckedFrameReader(r)
+		var framev []string
+		for {
+			frame, err := fr.ReadFrame(bg)
+			if err != nil {
+				return framev, err
+			}
+			framev = append(framev, string(frame))
+		}
+	}
+
+	// round-trip
+	framev := []string{"hello", "", "world", string(make([]byte, 1000))}
+	data := xwriteFrames(framev...)
+	rframev, err := readFrames(WithCtxR(bytes.NewReader(data)))
+	if !(err == io.EOF && reflect.DeepEqual(rframev, framev)) {
+		t.Fatalf("round-trip: have %q, %v  ; want %q, EOF", rframev, err, framev)
+	}
+
+	// truncated input
+	rframev, err = readFrames(WithCtxR(bytes.NewReader(data[:len(data)-1])))
+	if !(err == io.ErrUnexpectedEOF && reflect.DeepEqual(rframev, framev[:3])) {
+		t.Fatalf("truncated: have %q, %v  ; want %q, %v", rframev, err, framev[:3], io.ErrUnexpectedEOF)
+	}
+
+	// corrupted payload of the second frame
+	data = xwriteFrames("hello", "world")
+	r := FaultReader(WithCtxR(bytes.NewReader(data)), []Fault{
+		{Offset: 4+5+4 + 4+1, N: 1, Flip: 0x20},
+	})
+	rframev, err = readFrames(r)
+	if !(err == ErrCorrupt && reflect.DeepEqual(rframev, []string{"hello"})) {
+		t.Fatalf("corrupt: have %q, %v  ; want [\"hello\"], %v", rframev, err, ErrCorrupt)
+	}
+
+	// corrupted length
+	r = FaultReader(WithCtxR(bytes.NewReader(data)), []Fault{
+		{Offset: 0, N: 1, Flip: 0x80},
+	})
+	rframev, err = readFrames(r)
+	if !(err == ErrCorrupt && len(rframev) == 0) {
+		t.Fatalf("corrupt length: have %q, %v  ; want [], %v", rframev, err, ErrCorrupt)
+	}
+}
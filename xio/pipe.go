@@ -11,6 +11,7 @@ import (
 	"context"
 	"io"
 	"sync"
+	"sync/atomic"
 )
 
 // onceError is an object that will only store an error once.
@@ -43,6 +44,8 @@ type pipe struct {
 	done chan struct{}
 	rerr onceError
 	werr onceError
+
+	stats *PipeStats // !nil for MeteredPipe
 }
 
 func (p *pipe) Read(ctx context.Context, b []byte) (n int, err error) {
@@ -58,6 +61,10 @@ func (p *pipe) Read(ctx context.Context, b []byte) (n int, err error) {
 	case bw := <-p.wrCh:
 		nr := copy(b, bw)
 		p.rdCh <- nr
+		if p.stats != nil && nr > 0 {
+			atomic.AddInt64(&p.stats.nbytes, int64(nr))
+			atomic.AddInt64(&p.stats.nread, 1)
+		}
 		return nr, nil
 	case <-p.done:
 		return 0, p.readCloseError()
@@ -84,6 +91,14 @@ func (p *pipe) CloseRead(err error) error {
 }
 
 func (p *pipe) Write(ctx context.Context, b []byte) (n int, err error) {
+	if p.stats != nil {
+		defer func() {
+			if n > 0 {
+				atomic.AddInt64(&p.stats.nwrite, 1)
+			}
+		}()
+	}
+
 	select {
 	case <-p.done:
 		return 0, p.writeCloseError()
@@ -215,3 +230,32 @@ func Pipe() (*PipeReader, *PipeWriter) {
 	}
 	return &PipeReader{p}, &PipeWriter{p}
 }
+
+// PipeStats provides statistics about data passed through a pipe created by MeteredPipe.
+//
+// It is safe to query PipeStats while the pipe is in use.
+type PipeStats struct {
+	nbytes int64 // accessed atomically
+	nread  int64
+	nwrite int64
+}
+
+// Bytes returns total number of bytes passed through the pipe.
+func (s *PipeStats) Bytes() int64 { return atomic.LoadInt64(&s.nbytes) }
+
+// Reads returns number of Reads that received data from the pipe.
+func (s *PipeStats) Reads() int64 { return atomic.LoadInt64(&s.nread) }
+
+// Writes returns number of Writes that passed data to the pipe.
+func (s *PipeStats) Writes() int64 { return atomic.LoadInt64(&s.nwrite) }
+
+// MeteredPipe is like Pipe but additionally accounts data passed through the pipe.
+//
+// The accounting is provided by returned PipeStats. Reads and Writes that
+// transferred no data, e.g. due to the pipe being closed, are not counted.
+func MeteredPipe() (*PipeReader, *PipeWriter, *PipeStats) {
+	r, w := Pipe()
+	stats := &PipeStats{}
+	r.p.stats = stats
+	return r, w, stats
+}
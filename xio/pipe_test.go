@@ -453,3 +453,50 @@ func TestPipeCancel(t *testing.T) {
 	}
 
 }
+
+func TestMeteredPipe(t *testing.T) {
+	r, w, stats := MeteredPipe()
+
+	// 2 writes: 6 + 4 bytes
+	c := make(chan error)
+	go func() {
+		for _, data := range []string{"hello ", "worl"} {
+			_, err := w.Write(bg, []byte(data))
+			if err != nil {
+				c <- err
+				return
+			}
+		}
+		c <- w.Close()
+	}()
+
+	// 3 reads: 4 + 2 + 4 bytes
+	buf := make([]byte, 4)
+	var rx []byte
+	for {
+		n, err := r.Read(bg, buf)
+		rx = append(rx, buf[:n]...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+	}
+	if err := <-c; err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	if string(rx) != "hello worl" {
+		t.Errorf("read %q  ; want %q", rx, "hello worl")
+	}
+	if n := stats.Bytes(); n != 10 {
+		t.Errorf("bytes: %d  ; want 10", n)
+	}
+	if n := stats.Writes(); n != 2 {
+		t.Errorf("writes: %d  ; want 2", n)
+	}
+	if n := stats.Reads(); n != 3 {
+		t.Errorf("reads: %d  ; want 3", n)
+	}
+}
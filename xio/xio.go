@@ -28,15 +28,28 @@
 //     particular IO cancellation is not reliably handled for os.File .
 //   - Pipe amends io.Pipe and creates synchronous in-memory pipe that
 //     supports IO cancellation.
+//   - BufReader and BufWriter provide buffering that passes context of
+//     every call to underlying IO.
 //
 // Miscellaneous utilities:
 //
-//   - CountReader provides InputOffset for a Reader.
+//   - CountReader provides InputOffset for a Reader; the offset follows seeks.
+//   - Join combines separate Reader and Writer into one ReadWriteCloser.
+//   - WithDeadline limits duration of every Read and Write of a ReadWriter.
+//   - FaultReader injects short reads, errors and corruption into a Reader for testing.
+//   - CheckedFrameWriter and CheckedFrameReader transfer frames protected by CRC32.
+//   - WriteString and ReadString write and read strings without extra copy.
 package xio
 
 import (
 	"context"
+	"errors"
 	"io"
+	"sort"
+	"time"
+
+	"lab.nexedi.com/kirr/go123/mem"
+	"lab.nexedi.com/kirr/go123/xerr"
 )
 
 // Reader is like io.Reader but additionally takes context for Read.
@@ -291,11 +304,258 @@ func (cr *CountedReader) Read(ctx context.Context, p []byte) (int, error) {
 }
 
 // InputOffset returns the number of bytes read.
+//
+// After Seek or SetOffset it returns position in input stream.
 func (cr *CountedReader) InputOffset() int64 {
 	return cr.nread
 }
 
+// SetOffset sets current input offset to off.
+//
+// It should be used to correct the counter if underlying reader was seeked
+// not through cr.
+func (cr *CountedReader) SetOffset(off int64) {
+	cr.nread = off
+}
+
+// Seek seeks underlying reader and adjusts input offset correspondingly.
+//
+// It works if underlying reader implements io.Seeker, or was created via
+// WithCtx* from an io.Seeker.
+func (cr *CountedReader) Seek(offset int64, whence int) (int64, error) {
+	s := seeker(cr.r)
+	if s == nil {
+		return cr.nread, errNoSeek
+	}
+	pos, err := s.Seek(offset, whence)
+	if err == nil {
+		cr.nread = pos
+	}
+	return pos, err
+}
+
+var errNoSeek = errors.New("xio: underlying reader is not seekable")
+
+// seeker returns io.Seeker corresponding to r, or nil.
+func seeker(r Reader) io.Seeker {
+	var x interface{} = r
+	switch s := r.(type) {
+	case *stubCtxR:   x = s.r
+	case *stubCtxRW:  x = s.rw
+	case *stubCtxRC:  x = s.r
+	case *stubCtxRWC: x = s.rw
+	}
+	sk, _ := x.(io.Seeker)
+	return sk
+}
+
 // CountReader wraps r with CountedReader.
 func CountReader(r Reader) *CountedReader {
 	return &CountedReader{r, 0}
 }
+
+
+// Join combines Reader r and Writer w into one ReadWriteCloser.
+//
+// Read and Write of the result are delegated to r and w correspondingly.
+// Close calls Close on all closers and returns merged error. This is handy
+// e.g. to create full-duplex stream out of two halves of different pipes.
+func Join(r Reader, w Writer, closers ...io.Closer) ReadWriteCloser {
+	return &joinRWC{r, w, closers}
+}
+type joinRWC struct {r Reader; w Writer; closers []io.Closer}
+func (j *joinRWC) Read (ctx context.Context, dst []byte) (int, error)	{ return j.r.Read (ctx, dst) }
+func (j *joinRWC) Write(ctx context.Context, src []byte) (int, error)	{ return j.w.Write(ctx, src) }
+func (j *joinRWC) Close() error {
+	errv := make([]error, len(j.closers))
+	for i, c := range j.closers {
+		errv[i] = c.Close()
+	}
+	return xerr.Merge(errv...)
+}
+
+
+// WithDeadline wraps rw into ReadWriter whose every Read and Write has to
+// complete in d time.
+//
+// The deadline is rolling: it is restarted for each operation. If an operation
+// does not complete in time it is canceled and timeout error is returned. The
+// error is classified as Timeout - i.e. it has Timeout() method returning true.
+//
+// If rw, or the object rw was created from via WithCtx*, supports
+// SetReadDeadline/SetWriteDeadline - e.g. it is a net.Conn - the deadline is
+// set on it before every operation and is cleared after. This works even if
+// the object does not handle ctx cancellation. For other objects the
+// operation is canceled via ctx passed to it.
+//
+// The deadline is independent of ctx passed to Read and Write: cancellation of
+// ctx still works as usual and is reported with the ctx error.
+func WithDeadline(rw ReadWriter, d time.Duration) ReadWriter {
+	return &deadlineRW{rw, d}
+}
+type deadlineRW struct {rw ReadWriter; d time.Duration}
+
+type readDeadliner  interface { SetReadDeadline(t time.Time) error }
+type writeDeadliner interface { SetWriteDeadline(t time.Time) error }
+
+func (t *deadlineRW) Read(ctx context.Context, dst []byte) (int, error) {
+	if rd, ok := unstubCtx(t.rw).(readDeadliner); ok {
+		err := rd.SetReadDeadline(time.Now().Add(t.d))
+		if err != nil {
+			return 0, err
+		}
+		defer rd.SetReadDeadline(time.Time{}) // ignore err
+		return t.rw.Read(ctx, dst)
+	}
+
+	opctx, cancel := context.WithTimeout(ctx, t.d)
+	defer cancel()
+	n, err := t.rw.Read(opctx, dst)
+	return n, errTimeoutOr(ctx, opctx, err)
+}
+
+func (t *deadlineRW) Write(ctx context.Context, src []byte) (int, error) {
+	if wd, ok := unstubCtx(t.rw).(writeDeadliner); ok {
+		err := wd.SetWriteDeadline(time.Now().Add(t.d))
+		if err != nil {
+			return 0, err
+		}
+		defer wd.SetWriteDeadline(time.Time{}) // ignore err
+		return t.rw.Write(ctx, src)
+	}
+
+	opctx, cancel := context.WithTimeout(ctx, t.d)
+	defer cancel()
+	n, err := t.rw.Write(opctx, src)
+	return n, errTimeoutOr(ctx, opctx, err)
+}
+
+// unstubCtx returns object rw was created from via WithCtx*, or rw itself.
+func unstubCtx(rw ReadWriter) interface{} {
+	switch s := rw.(type) {
+	case *stubCtxRW:  return s.rw
+	case *stubCtxRWC: return s.rw
+	}
+	return rw
+}
+
+// errTimeoutOr returns errTimeout if operation failed due to its deadline,
+// not due to cancellation of parent ctx. Otherwise err is returned as is.
+func errTimeoutOr(ctx, opctx context.Context, err error) error {
+	if err != nil && opctx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+		return errTimeout
+	}
+	return err
+}
+
+// errTimeout is returned when an operation did not complete in WithDeadline time.
+var errTimeout error = &timeoutError{}
+
+type timeoutError struct{}
+func (e *timeoutError) Error() string	{ return "i/o timeout" }
+func (e *timeoutError) Timeout() bool	{ return true }
+func (e *timeoutError) Temporary() bool	{ return true }
+
+
+// Fault describes one fault injected by FaultReader.
+type Fault struct {
+	Offset int64 // input offset at which the fault is injected
+	N      int   // max number of bytes returned by the read at Offset
+	Err    error // error returned by the read at Offset, if !nil
+	Flip   byte  // if !0, byte at Offset, if read, is xored with Flip
+}
+
+// FaultReader wraps r into Reader that injects faults into reads.
+//
+// Reads are split so that every fault offset is the start of a read. The read
+// that starts at a fault offset returns at most fault.N bytes, and fault.Err,
+// if it is !nil, instead of error from r. If fault.Flip is !0, the first byte
+// returned by that read is corrupted. Every fault is injected only once;
+// several faults at the same offset are injected by consecutive reads.
+//
+// It is handy to verify how a parser handles short reads, IO errors and
+// corrupted data.
+func FaultReader(r Reader, faults []Fault) Reader {
+	faultv := make([]Fault, len(faults))
+	copy(faultv, faults)
+	sort.SliceStable(faultv, func(i, j int) bool {
+		return faultv[i].Offset < faultv[j].Offset
+	})
+	return &faultReader{r: r, faultv: faultv}
+}
+
+type faultReader struct {
+	r      Reader
+	faultv []Fault // faults not yet injected; sorted by offset
+	off    int64   // current input offset
+}
+
+func (fr *faultReader) Read(ctx context.Context, p []byte) (n int, err error) {
+	if len(fr.faultv) == 0 {
+		n, err = fr.r.Read(ctx, p)
+		fr.off += int64(n)
+		return n, err
+	}
+
+	f := fr.faultv[0]
+	if f.Offset > fr.off {
+		// don't read past next fault
+		if δ := f.Offset - fr.off; int64(len(p)) > δ {
+			p = p[:δ]
+		}
+		n, err = fr.r.Read(ctx, p)
+		fr.off += int64(n)
+		return n, err
+	}
+
+	// read at fault offset
+	fr.faultv = fr.faultv[1:]
+	if len(p) > f.N {
+		p = p[:f.N]
+	}
+	if len(p) > 0 {
+		n, err = fr.r.Read(ctx, p)
+		fr.off += int64(n)
+	}
+	if n > 0 {
+		p[0] ^= f.Flip
+	}
+	if f.Err != nil {
+		err = f.Err
+	}
+	return n, err
+}
+
+
+// ----------------------------------------
+
+// WriteString writes string s to w.
+//
+// Contrary to w.Write(ctx, []byte(s)), s is not copied.
+// w must not modify nor retain the data.
+func WriteString(ctx context.Context, w Writer, s string) (int, error) {
+	return w.Write(ctx, mem.Bytes(s))
+}
+
+// ReadString reads exactly n bytes from r and returns them as string.
+//
+// The error handling is similar to io.ReadFull: EOF is returned only if no
+// bytes were read, and io.ErrUnexpectedEOF if EOF is hit in the middle. On
+// error, the string contains the bytes that were read. The data is read
+// directly into memory of returned string without extra copy.
+func ReadString(ctx context.Context, r Reader, n int) (string, error) {
+	buf := make([]byte, n)
+	nread := 0
+	var err error
+	for nread < n && err == nil {
+		var m int
+		m, err = r.Read(ctx, buf[nread:])
+		nread += m
+	}
+	if nread == n {
+		err = nil
+	} else if nread > 0 && err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return mem.String(buf[:nread]), err
+}
@@ -21,7 +21,13 @@ package xio
 
 import (
 	"context"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 // xIO is test Reader/Writer/Closer/...
@@ -119,3 +125,270 @@ func TestConvert(t *testing.T) {
 	ok1( BindCtxWC (WithCtxRWC(i), bg) == i )
 	ok1( BindCtxRWC(WithCtxRWC(i), bg) == i )
 }
+
+func TestJoin(t *testing.T) {
+	bg := context.Background()
+	r1, w1 := Pipe()
+	r2, w2 := Pipe()
+	rw := Join(r1, w2, r1, w2)
+
+	// rw.Write -> w2 -> r2
+	go func() {
+		_, _ = rw.Write(bg, []byte("hello"))
+	}()
+	buf := make([]byte, 10)
+	n, err := r2.Read(bg, buf)
+	if !(n == 5 && err == nil && string(buf[:n]) == "hello") {
+		t.Fatalf("r2.Read -> %d %q, %v  ; want 5 \"hello\", nil", n, buf[:n], err)
+	}
+
+	// w1 -> r1 -> rw.Read
+	go func() {
+		_, _ = w1.Write(bg, []byte("world"))
+	}()
+	n, err = rw.Read(bg, buf)
+	if !(n == 5 && err == nil && string(buf[:n]) == "world") {
+		t.Fatalf("rw.Read -> %d %q, %v  ; want 5 \"world\", nil", n, buf[:n], err)
+	}
+
+	// Close closes both halves
+	err = rw.Close()
+	if err != nil {
+		t.Fatalf("rw.Close -> %v", err)
+	}
+	_, err = w1.Write(bg, []byte("x"))
+	if err != io.ErrClosedPipe {
+		t.Errorf("w1.Write after Close -> %v  ; want %v", err, io.ErrClosedPipe)
+	}
+	_, err = r2.Read(bg, buf)
+	if err != io.EOF {
+		t.Errorf("r2.Read after Close -> %v  ; want %v", err, io.EOF)
+	}
+}
+
+func TestCountedReaderSeek(t *testing.T) {
+	bg := context.Background()
+	cr := CountReader(WithCtxR(strings.NewReader("hello world")))
+
+	buf := make([]byte, 5)
+	_, err := io.ReadFull(BindCtxR(cr, bg), buf)
+	if !(err == nil && cr.InputOffset() == 5) {
+		t.Fatalf("read -> @%d, %v  ; want @5, nil", cr.InputOffset(), err)
+	}
+
+	pos, err := cr.Seek(6, io.SeekStart)
+	if !(pos == 6 && err == nil && cr.InputOffset() == 6) {
+		t.Fatalf("seek 6 -> %d, %v @%d  ; want 6, nil @6", pos, err, cr.InputOffset())
+	}
+	n, err := cr.Read(bg, buf)
+	if !(n == 5 && string(buf) == "world" && cr.InputOffset() == 11) {
+		t.Fatalf("read after seek -> %d %q, %v @%d  ; want 5 \"world\" @11", n, buf[:n], err, cr.InputOffset())
+	}
+
+	pos, err = cr.Seek(-3, io.SeekCurrent)
+	if !(pos == 8 && err == nil && cr.InputOffset() == 8) {
+		t.Fatalf("seek -3 -> %d, %v @%d  ; want 8, nil @8", pos, err, cr.InputOffset())
+	}
+
+	// non-seekable reader
+	cr = CountReader(&xIO{})
+	_, err = cr.Read(bg, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cr.Seek(0, io.SeekStart)
+	if !(err == errNoSeek && cr.InputOffset() == 5) {
+		t.Fatalf("seek non-seekable -> %v @%d  ; want %v @5", err, cr.InputOffset(), errNoSeek)
+	}
+
+	cr.SetOffset(100)
+	if cr.InputOffset() != 100 {
+		t.Fatalf("SetOffset(100) -> @%d", cr.InputOffset())
+	}
+}
+
+func TestWithDeadline(t *testing.T) {
+	bg := context.Background()
+	pr, pw := Pipe()
+	rw := WithDeadline(Join(pr, pw), 10*time.Millisecond)
+
+	// read that would complete in time
+	go func() {
+		_, _ = pw.Write(bg, []byte("hello"))
+	}()
+	buf := make([]byte, 5)
+	n, err := rw.Read(bg, buf)
+	if !(n == 5 && err == nil && string(buf) == "hello") {
+		t.Fatalf("read -> %d %q, %v  ; want 5 \"hello\", nil", n, buf[:n], err)
+	}
+
+	// slow writer -> read exceeds the deadline
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		time.Sleep(100*time.Millisecond)
+		_, _ = pw.Write(bg, []byte("world"))
+	}()
+	n, err = rw.Read(bg, buf)
+	e, ok := err.(interface{ Timeout() bool })
+	if !(n == 0 && ok && e.Timeout()) {
+		t.Fatalf("slow read -> %d, %v  ; want 0, timeout", n, err)
+	}
+
+	// timeout does not break the stream
+	rw = WithDeadline(Join(pr, pw), time.Second)
+	n, err = rw.Read(bg, buf)
+	if !(n == 5 && err == nil && string(buf) == "world") {
+		t.Fatalf("read after timeout -> %d %q, %v  ; want 5 \"world\", nil", n, buf[:n], err)
+	}
+	<-done
+
+	// ctx cancellation is reported as ctx error, not as timeout
+	ctx, cancel := context.WithCancel(bg)
+	cancel()
+	_, err = rw.Read(ctx, buf)
+	if err != context.Canceled {
+		t.Fatalf("read with canceled ctx -> %v  ; want %v", err, context.Canceled)
+	}
+}
+
+// TestWithDeadlineConn verifies WithDeadline on object that supports deadlines
+// but does not handle ctx.
+func TestWithDeadlineConn(t *testing.T) {
+	bg := context.Background()
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	rw := WithDeadline(WithCtxRW(c1), 10*time.Millisecond)
+
+	// read with no data -> timeout
+	buf := make([]byte, 5)
+	n, err := rw.Read(bg, buf)
+	e, ok := err.(interface{ Timeout() bool })
+	if !(n == 0 && ok && e.Timeout()) {
+		t.Fatalf("read -> %d, %v  ; want 0, timeout", n, err)
+	}
+
+	// write with nobody reading -> timeout
+	n, err = rw.Write(bg, []byte("hello"))
+	e, ok = err.(interface{ Timeout() bool })
+	if !(n == 0 && ok && e.Timeout()) {
+		t.Fatalf("write -> %d, %v  ; want 0, timeout", n, err)
+	}
+
+	// the deadline is cleared after operation
+	time.Sleep(20*time.Millisecond)
+	go func() {
+		_, _ = c2.Write([]byte("world"))
+	}()
+	n, err = c1.Read(buf)
+	if !(n == 5 && err == nil && string(buf) == "world") {
+		t.Fatalf("raw read after timeout -> %d %q, %v  ; want 5 \"world\", nil", n, buf[:n], err)
+	}
+
+	// operation that completes in time
+	go func() {
+		_, _ = c2.Write([]byte("hello"))
+	}()
+	n, err = rw.Read(bg, buf)
+	if !(n == 5 && err == nil && string(buf) == "hello") {
+		t.Fatalf("read -> %d %q, %v  ; want 5 \"hello\", nil", n, buf[:n], err)
+	}
+}
+
+func TestFaultReader(t *testing.T) {
+	bg := context.Background()
+	data := "hello world, this is data"
+	errBoom := errors.New("boom")
+	faults := []Fault{
+		{Offset: 10, N: 0, Err: errBoom},
+		{Offset: 3,  N: 2},
+	}
+
+	// individual reads
+	type read struct {
+		n   int
+		err error
+	}
+	r := FaultReader(WithCtxR(strings.NewReader(data)), faults)
+	buf := make([]byte, 100)
+	var readv []read
+	for {
+		n, err := r.Read(bg, buf)
+		readv = append(readv, read{n, err})
+		if err == io.EOF {
+			break
+		}
+	}
+	readOK := []read{
+		{3, nil},	// up to first fault
+		{2, nil},	// short read at 3
+		{5, nil},	// up to second fault
+		{0, errBoom},	// error at 10
+		{15, nil},	// rest
+		{0, io.EOF},
+	}
+	if !reflect.DeepEqual(readv, readOK) {
+		t.Fatalf("reads:\nhave: %v\nwant: %v", readv, readOK)
+	}
+
+	// parser that reads fixed-size records sees the error at right offset
+	r = FaultReader(WithCtxR(strings.NewReader(data)), faults)
+	rec := make([]byte, 4)
+	var recv []string
+	var err error
+	for {
+		_, err = io.ReadFull(BindCtxR(r, bg), rec)
+		if err != nil {
+			break
+		}
+		recv = append(recv, string(rec))
+	}
+	if !(err == errBoom && reflect.DeepEqual(recv, []string{"hell", "o wo"})) {
+		t.Fatalf("parse: have %q, %v  ; want [\"hell\" \"o wo\"], %v", recv, err, errBoom)
+	}
+}
+
+func TestReadWriteString(t *testing.T) {
+	bg := context.Background()
+	pr, pw := Pipe()
+
+	go func() {
+		_, err := WriteString(bg, pw, "hello ")
+		if err == nil {
+			_, err = WriteString(bg, pw, "world")
+		}
+		pw.CloseWithError(err)
+	}()
+
+	s, err := ReadString(bg, pr, 11)
+	if !(s == "hello world" && err == nil) {
+		t.Fatalf("ReadString: got (%q, %v)  ; want (%q, nil)", s, err, "hello world")
+	}
+	s, err = ReadString(bg, pr, 1)
+	if !(s == "" && err == io.EOF) {
+		t.Fatalf("ReadString: got (%q, %v)  ; want (\"\", EOF)", s, err)
+	}
+
+	r := WithCtxR(strings.NewReader("abc"))
+	s, err = ReadString(bg, r, 5)
+	if !(s == "abc" && err == io.ErrUnexpectedEOF) {
+		t.Fatalf("ReadString: got (%q, %v)  ; want (\"abc\", ErrUnexpectedEOF)", s, err)
+	}
+
+	// no extra allocations: WriteString does not copy; ReadString
+	// allocates only memory for the result.
+	x := &xIO{}
+	allocs := testing.AllocsPerRun(100, func() {
+		WriteString(bg, x, "hello world")
+	})
+	if allocs != 0 {
+		t.Errorf("WriteString: %v allocations  ; want 0", allocs)
+	}
+	allocs = testing.AllocsPerRun(100, func() {
+		ReadString(bg, x, 11)
+	})
+	if allocs != 1 {
+		t.Errorf("ReadString: %v allocations  ; want 1", allocs)
+	}
+}
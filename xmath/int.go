@@ -0,0 +1,88 @@
+// Copyright (C) 2026  Nexedi SA and Contributors.
+//                     Kirill Smelkov <kirr@nexedi.com>
+//
+// This program is free software: you can Use, Study, Modify and Redistribute
+// it under the terms of the GNU General Public License version 3, or (at your
+// option) any later version, as published by the Free Software Foundation.
+//
+// You can also Link and Combine this program with other software covered by
+// the terms of any of the Free Software licenses or any of the Open Source
+// Initiative approved licenses and Convey the resulting work. Corresponding
+// source of such a combination shall include the source code for all other
+// software used.
+//
+// This program is distributed WITHOUT ANY WARRANTY; without even the implied
+// warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+//
+// See COPYING file for full licensing terms.
+// See https://www.nexedi.com/licensing for rationale and options.
+
+package xmath
+// integer arithmetic
+
+// Gcd returns greatest common divisor of a and b.
+//
+// Gcd(0, b) = b, and Gcd(0, 0) = 0.
+func Gcd(a, b uint64) uint64 {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// Lcm returns least common multiple of a and b.
+//
+// ok=false is returned if the result does not fit into uint64.
+// Lcm(0, b) = 0.
+func Lcm(a, b uint64) (_ uint64, ok bool) {
+	if a == 0 || b == 0 {
+		return 0, true
+	}
+	a /= Gcd(a, b)
+	if a > ^uint64(0) / b {
+		return 0, false
+	}
+	return a * b, true
+}
+
+// DivCeil returns ⌈a/b⌉.
+//
+// It does not overflow for any a. b must be != 0.
+func DivCeil(a, b uint64) uint64 {
+	q := a / b
+	if a % b != 0 {
+		q++
+	}
+	return q
+}
+
+// AlignUp returns minimal y >= x, such that y is multiple of align.
+//
+// align must be power of 2. The result wraps around if it does not fit into uint64.
+func AlignUp(x, align uint64) uint64 {
+	checkAlign(align)
+	return (x + align-1) &^ (align-1)
+}
+
+// AlignDown returns maximal y <= x, such that y is multiple of align.
+//
+// align must be power of 2.
+func AlignDown(x, align uint64) uint64 {
+	checkAlign(align)
+	return x &^ (align-1)
+}
+
+// IsAligned returns whether x is multiple of align.
+//
+// align must be power of 2.
+func IsAligned(x, align uint64) bool {
+	checkAlign(align)
+	return x & (align-1) == 0
+}
+
+// checkAlign panics if align is not power of 2.
+func checkAlign(align uint64) {
+	if align == 0 || align & (align-1) != 0 {
+		panic("xmath: alignment is not power of 2")
+	}
+}
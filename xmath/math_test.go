@@ -84,3 +84,101 @@ func TestPow2(t *testing.T) {
 		}
 	}
 }
+
+func TestGcdLcm(t *testing.T) {
+	const max = ^uint64(0)
+	testv := []struct {a, b, gcd, lcm uint64; lcmOk bool} {
+		{0, 0, 0, 0, true},
+		{0, 5, 5, 0, true},
+		{5, 0, 5, 0, true},
+		{1, 1, 1, 1, true},
+		{4, 6, 2, 12, true},
+		{6, 4, 2, 12, true},
+		{7, 13, 1, 91, true},
+		{12, 18, 6, 36, true},
+		{max, 1, 1, max, true},
+		{max, max, max, max, true},
+		{1<<32, 1<<32 + 1, 1, 0, false}, // 2^64 + 2^32 overflows
+		{1<<63, 2, 2, 1<<63, true},
+		{1<<63, 3, 1, 0, false},
+	}
+
+	for _, tt := range testv {
+		gcd := Gcd(tt.a, tt.b)
+		if gcd != tt.gcd {
+			t.Errorf("Gcd(%v, %v) -> %v  ; want %v", tt.a, tt.b, gcd, tt.gcd)
+		}
+		lcm, ok := Lcm(tt.a, tt.b)
+		if !(lcm == tt.lcm && ok == tt.lcmOk) {
+			t.Errorf("Lcm(%v, %v) -> %v, %v  ; want %v, %v", tt.a, tt.b, lcm, ok, tt.lcm, tt.lcmOk)
+		}
+	}
+}
+
+func TestDivCeil(t *testing.T) {
+	const max = ^uint64(0)
+	testv := []struct {a, b, q uint64} {
+		{0, 1, 0},
+		{0, 7, 0},
+		{1, 7, 1},
+		{6, 7, 1},
+		{7, 7, 1},
+		{8, 7, 2},
+		{14, 7, 2},
+		{15, 7, 3},
+		{max, 1, max},
+		{max, 2, 1<<63},
+		{max, max, 1},
+		{max-1, max, 1},
+	}
+
+	for _, tt := range testv {
+		q := DivCeil(tt.a, tt.b)
+		if q != tt.q {
+			t.Errorf("DivCeil(%v, %v) -> %v  ; want %v", tt.a, tt.b, q, tt.q)
+		}
+	}
+}
+
+func TestAlign(t *testing.T) {
+	testv := []struct {x, align, up, down uint64} {
+		{0, 8, 0, 0},
+		{1, 8, 8, 0},
+		{7, 8, 8, 0},
+		{8, 8, 8, 8},
+		{9, 8, 16, 8},
+		{17, 16, 32, 16},
+		{32, 16, 32, 32},
+		{1, 4096, 4096, 0},
+		{4095, 4096, 4096, 0},
+		{4096, 4096, 4096, 4096},
+		{4097, 4096, 8192, 4096},
+		{5, 1, 5, 5},
+	}
+
+	for _, tt := range testv {
+		up := AlignUp(tt.x, tt.align)
+		if up != tt.up {
+			t.Errorf("AlignUp(%v, %v) -> %v  ; want %v", tt.x, tt.align, up, tt.up)
+		}
+		down := AlignDown(tt.x, tt.align)
+		if down != tt.down {
+			t.Errorf("AlignDown(%v, %v) -> %v  ; want %v", tt.x, tt.align, down, tt.down)
+		}
+		aligned := IsAligned(tt.x, tt.align)
+		if aligned != (tt.x == tt.up) {
+			t.Errorf("IsAligned(%v, %v) -> %v  ; want %v", tt.x, tt.align, aligned, !aligned)
+		}
+	}
+
+	for _, align := range []uint64{0, 3, 12, 4097} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("AlignUp(1, %v): no panic", align)
+				}
+			}()
+			AlignUp(1, align)
+		}()
+	}
+}
@@ -0,0 +1,273 @@
+// Copyright (C) 2026  Nexedi SA and Contributors.
+//                     Kirill Smelkov <kirr@nexedi.com>
+//
+// This program is free software: you can Use, Study, Modify and Redistribute
+// it under the terms of the GNU General Public License version 3, or (at your
+// option) any later version, as published by the Free Software Foundation.
+//
+// You can also Link and Combine this program with other software covered by
+// the terms of any of the Free Software licenses or any of the Open Source
+// Initiative approved licenses and Convey the resulting work. Corresponding
+// source of such a combination shall include the source code for all other
+// software used.
+//
+// This program is distributed WITHOUT ANY WARRANTY; without even the implied
+// warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+//
+// See COPYING file for full licensing terms.
+// See https://www.nexedi.com/licensing for rationale and options.
+
+package xnet
+// network traffic capture
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"lab.nexedi.com/kirr/go123/xerr"
+)
+
+// NetCapture wraps underlying networker to capture all traffic through its connections into w.
+//
+// Every Read and Write, that transferred data on connections dialed or
+// accepted via returned networker, is recorded into w as one CaptureRecord.
+// Records are written in binary form and can be loaded back via LoadCapture.
+//
+// The returned function must be called after use to flush captured data. It
+// also closes w if w implements io.Closer. The error, if any, of writing to w
+// is returned by that function.
+func NetCapture(inner Networker, w io.Writer) (Networker, func() error) {
+	c := &netCapture{inner: inner, w: w, bw: bufio.NewWriter(w)}
+	return c, c.close
+}
+
+// CaptureRecord represents one record of traffic captured by NetCapture.
+type CaptureRecord struct {
+	Time  time.Time
+	Rx    bool   // data was read (received); false - written (transmitted)
+	Laddr string // local address of the connection
+	Raddr string // remote address of the connection
+	Data  []byte
+}
+
+// On the wire capture record is
+//
+//	len    [4]byte   big-endian length of the rest of the record
+//	time   [8]byte   big-endian unix nanoseconds
+//	dir    [1]byte   'R' | 'T'
+//	laddr  [2]byte   big-endian length + laddr
+//	raddr  [2]byte   big-endian length + raddr
+//	data
+
+type netCapture struct {
+	inner Networker
+	w     io.Writer
+
+	mu     sync.Mutex
+	bw     *bufio.Writer
+	err    error // first error writing to w
+	closed bool
+}
+
+func (c *netCapture) Network() string {
+	return c.inner.Network()
+}
+
+func (c *netCapture) Name() string {
+	return c.inner.Name()
+}
+
+func (c *netCapture) Close() error {
+	return c.inner.Close()
+}
+
+func (c *netCapture) Closed() bool {
+	return innerClosed(c.inner)
+}
+
+func (c *netCapture) Dial(ctx context.Context, addr string) (net.Conn, error) {
+	conn, err := c.inner.Dial(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	return &captureConn{conn, c}, nil
+}
+
+func (c *netCapture) Listen(ctx context.Context, laddr string) (Listener, error) {
+	l, err := c.inner.Listen(ctx, laddr)
+	if err != nil {
+		return nil, err
+	}
+	return &listenerCapture{l, c}, nil
+}
+
+// close flushes captured data and closes w if it is io.Closer.
+func (c *netCapture) close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return c.err
+	}
+	c.closed = true
+
+	err := c.bw.Flush()
+	if c.err == nil {
+		c.err = err
+	}
+	if closer, ok := c.w.(io.Closer); ok {
+		err = closer.Close()
+		if c.err == nil {
+			c.err = err
+		}
+	}
+	return c.err
+}
+
+// record writes one capture record to w.
+func (c *netCapture) record(rx bool, conn net.Conn, data []byte) {
+	laddr := conn.LocalAddr().String()
+	raddr := conn.RemoteAddr().String()
+	dir := byte('T')
+	if rx {
+		dir = 'R'
+	}
+
+	rec := make([]byte, 4 + 8 + 1 + 2+len(laddr) + 2+len(raddr) + len(data))
+	binary.BigEndian.PutUint32(rec[0:], uint32(len(rec) - 4))
+	binary.BigEndian.PutUint64(rec[4:], uint64(time.Now().UnixNano()))
+	rec[12] = dir
+	i := 13
+	for _, addr := range []string{laddr, raddr} {
+		binary.BigEndian.PutUint16(rec[i:], uint16(len(addr)))
+		i += 2
+		i += copy(rec[i:], addr)
+	}
+	copy(rec[i:], data)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed || c.err != nil {
+		return
+	}
+	_, c.err = c.bw.Write(rec)
+}
+
+// LoadCapture loads records of traffic captured by NetCapture from r.
+func LoadCapture(r io.Reader) (_ []CaptureRecord, err error) {
+	defer xerr.Context(&err, "load capture")
+
+	var recv []CaptureRecord
+	br := bufio.NewReader(r)
+	for {
+		var hdr [4]byte
+		_, err = io.ReadFull(br, hdr[:])
+		if err == io.EOF {
+			return recv, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		buf := make([]byte, binary.BigEndian.Uint32(hdr[:]))
+		_, err = io.ReadFull(br, buf)
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		rec, ok := decodeCaptureRecord(buf)
+		if !ok {
+			return nil, fmt.Errorf("record #%d: invalid", len(recv))
+		}
+		recv = append(recv, rec)
+	}
+}
+
+// decodeCaptureRecord decodes body of capture record (without len prefix).
+func decodeCaptureRecord(buf []byte) (rec CaptureRecord, ok bool) {
+	if len(buf) < 8 + 1 {
+		return rec, false
+	}
+	rec.Time = time.Unix(0, int64(binary.BigEndian.Uint64(buf)))
+	switch buf[8] {
+	case 'R':
+		rec.Rx = true
+	case 'T':
+	default:
+		return rec, false
+	}
+	buf = buf[9:]
+
+	var addrv [2]string
+	for i := range addrv {
+		if len(buf) < 2 {
+			return rec, false
+		}
+		l := int(binary.BigEndian.Uint16(buf))
+		buf = buf[2:]
+		if len(buf) < l {
+			return rec, false
+		}
+		addrv[i] = string(buf[:l])
+		buf = buf[l:]
+	}
+	rec.Laddr, rec.Raddr = addrv[0], addrv[1]
+	rec.Data = buf
+	return rec, true
+}
+
+// listenerCapture wraps Listener to wrap accepted connections with captureConn.
+type listenerCapture struct {
+	Listener
+	c *netCapture
+}
+
+func (l *listenerCapture) Accept(ctx context.Context) (net.Conn, error) {
+	conn, err := l.Listener.Accept(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &captureConn{conn, l.c}, nil
+}
+
+func (l *listenerCapture) Unwrap() Listener {
+	return l.Listener
+}
+
+// captureConn wraps net.Conn to record its traffic.
+type captureConn struct {
+	net.Conn
+	c *netCapture
+}
+
+func (cc *captureConn) Read(p []byte) (int, error) {
+	n, err := cc.Conn.Read(p)
+	if n > 0 {
+		cc.c.record(true, cc.Conn, p[:n])
+	}
+	return n, err
+}
+
+func (cc *captureConn) Write(p []byte) (int, error) {
+	n, err := cc.Conn.Write(p)
+	if n > 0 {
+		cc.c.record(false, cc.Conn, p[:n])
+	}
+	return n, err
+}
+
+func (cc *captureConn) AbortiveClose() error {
+	return AbortiveClose(cc.Conn)
+}
+
+func (cc *captureConn) Unwrap() net.Conn {
+	return cc.Conn
+}
@@ -0,0 +1,112 @@
+// Copyright (C) 2026  Nexedi SA and Contributors.
+//                     Kirill Smelkov <kirr@nexedi.com>
+//
+// This program is free software: you can Use, Study, Modify and Redistribute
+// it under the terms of the GNU General Public License version 3, or (at your
+// option) any later version, as published by the Free Software Foundation.
+//
+// You can also Link and Combine this program with other software covered by
+// the terms of any of the Free Software licenses or any of the Open Source
+// Initiative approved licenses and Convey the resulting work. Corresponding
+// source of such a combination shall include the source code for all other
+// software used.
+//
+// This program is distributed WITHOUT ANY WARRANTY; without even the implied
+// warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+//
+// See COPYING file for full licensing terms.
+// See https://www.nexedi.com/licensing for rationale and options.
+
+package xnet
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+)
+
+func TestNetCapture(t *testing.T) {
+	bg := context.Background()
+	var buf bytes.Buffer
+	n, closeCapture := NetCapture(NetPlain("tcp"), &buf)
+	defer n.Close()
+
+	l, err := n.Listen(bg, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	c, err := n.Dial(bg, l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	cs, err := l.Accept(bg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cs.Close()
+
+	// exchange: c -> "hello world" -> cs -> "pong" -> c
+	xwrite := func(c io.Writer, data string) {
+		t.Helper()
+		_, err := c.Write([]byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	xread := func(c io.Reader, n int) {
+		t.Helper()
+		_, err := io.ReadFull(c, make([]byte, n))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	xwrite(c, "hello ")
+	xwrite(c, "world")
+	xread(cs, 11)
+	xwrite(cs, "pong")
+	xread(c, 4)
+
+	err = closeCapture()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recv, err := LoadCapture(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// reconstruct byte streams per connection end and direction
+	stream := map[string]string{}
+	for i, rec := range recv {
+		dir := "tx"
+		if rec.Rx {
+			dir = "rx"
+		}
+		stream[rec.Laddr+" "+dir+" "+rec.Raddr] += string(rec.Data)
+		if i > 0 && rec.Time.Before(recv[i-1].Time) {
+			t.Errorf("record #%d: time goes back", i)
+		}
+	}
+
+	caddr := c.LocalAddr().String()
+	saddr := cs.LocalAddr().String()
+	streamOK := map[string]string{
+		caddr + " tx " + saddr: "hello world",
+		saddr + " rx " + caddr: "hello world",
+		saddr + " tx " + caddr: "pong",
+		caddr + " rx " + saddr: "pong",
+	}
+	if len(stream) != len(streamOK) {
+		t.Fatalf("streams:\nhave: %q\nwant: %q", stream, streamOK)
+	}
+	for k, v := range streamOK {
+		if stream[k] != v {
+			t.Fatalf("streams:\nhave: %q\nwant: %q", stream, streamOK)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+// Copyright (C) 2026  Nexedi SA and Contributors.
+//                     Kirill Smelkov <kirr@nexedi.com>
+//
+// This program is free software: you can Use, Study, Modify and Redistribute
+// it under the terms of the GNU General Public License version 3, or (at your
+// option) any later version, as published by the Free Software Foundation.
+//
+// You can also Link and Combine this program with other software covered by
+// the terms of any of the Free Software licenses or any of the Open Source
+// Initiative approved licenses and Convey the resulting work. Corresponding
+// source of such a combination shall include the source code for all other
+// software used.
+//
+// This program is distributed WITHOUT ANY WARRANTY; without even the implied
+// warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+//
+// See COPYING file for full licensing terms.
+// See https://www.nexedi.com/licensing for rationale and options.
+
+package xnet
+// introspection of open listeners and connections
+
+import (
+	"context"
+	"net"
+	"sync"
+)
+
+// NetInspect wraps underlying networker to track listeners and connections opened through it.
+//
+// Listeners created via returned networker, and connections dialed or
+// accepted via it, are reported by returned Inspector until they are closed.
+func NetInspect(inner Networker) (Networker, *Inspector) {
+	insp := &Inspector{}
+	return &netInspect{inner, insp}, insp
+}
+
+// Inspector reports listeners and connections opened via networker created by NetInspect.
+//
+// It is safe to use Inspector from multiple goroutines simultaneously.
+type Inspector struct {
+	mu    sync.Mutex
+	lv    []*listenerInspect // in the order of Listen
+	connv []*inspectConn     // in the order of Dial/Accept
+}
+
+// ConnInfo describes one connection reported by Inspector.
+type ConnInfo struct {
+	Local, Remote net.Addr
+}
+
+// Listeners returns addresses of currently open listeners.
+func (insp *Inspector) Listeners() []net.Addr {
+	insp.mu.Lock()
+	defer insp.mu.Unlock()
+	var addrv []net.Addr
+	for _, l := range insp.lv {
+		addrv = append(addrv, l.Addr())
+	}
+	return addrv
+}
+
+// Conns returns information about currently open connections.
+func (insp *Inspector) Conns() []ConnInfo {
+	insp.mu.Lock()
+	defer insp.mu.Unlock()
+	var infov []ConnInfo
+	for _, c := range insp.connv {
+		infov = append(infov, ConnInfo{c.LocalAddr(), c.RemoteAddr()})
+	}
+	return infov
+}
+
+func (insp *Inspector) addConn(conn net.Conn) net.Conn {
+	c := &inspectConn{Conn: conn, insp: insp}
+	insp.mu.Lock()
+	defer insp.mu.Unlock()
+	insp.connv = append(insp.connv, c)
+	return c
+}
+
+func (insp *Inspector) delConn(c *inspectConn) {
+	insp.mu.Lock()
+	defer insp.mu.Unlock()
+	for i, c_ := range insp.connv {
+		if c_ == c {
+			insp.connv = append(insp.connv[:i], insp.connv[i+1:]...)
+			break
+		}
+	}
+}
+
+func (insp *Inspector) delListener(l *listenerInspect) {
+	insp.mu.Lock()
+	defer insp.mu.Unlock()
+	for i, l_ := range insp.lv {
+		if l_ == l {
+			insp.lv = append(insp.lv[:i], insp.lv[i+1:]...)
+			break
+		}
+	}
+}
+
+type netInspect struct {
+	inner Networker
+	insp  *Inspector
+}
+
+func (n *netInspect) Network() string {
+	return n.inner.Network()
+}
+
+func (n *netInspect) Name() string {
+	return n.inner.Name()
+}
+
+func (n *netInspect) Close() error {
+	return n.inner.Close()
+}
+
+func (n *netInspect) Closed() bool {
+	return innerClosed(n.inner)
+}
+
+func (n *netInspect) Dial(ctx context.Context, addr string) (net.Conn, error) {
+	conn, err := n.inner.Dial(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	return n.insp.addConn(conn), nil
+}
+
+func (n *netInspect) Listen(ctx context.Context, laddr string) (Listener, error) {
+	l, err := n.inner.Listen(ctx, laddr)
+	if err != nil {
+		return nil, err
+	}
+	li := &listenerInspect{Listener: l, insp: n.insp}
+	n.insp.mu.Lock()
+	n.insp.lv = append(n.insp.lv, li)
+	n.insp.mu.Unlock()
+	return li, nil
+}
+
+// listenerInspect wraps Listener to deregister it on Close and to track accepted connections.
+type listenerInspect struct {
+	Listener
+	insp *Inspector
+}
+
+func (l *listenerInspect) Accept(ctx context.Context) (net.Conn, error) {
+	conn, err := l.Listener.Accept(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return l.insp.addConn(conn), nil
+}
+
+func (l *listenerInspect) Close() error {
+	l.insp.delListener(l)
+	return l.Listener.Close()
+}
+
+func (l *listenerInspect) Unwrap() Listener {
+	return l.Listener
+}
+
+// inspectConn wraps net.Conn to deregister it on Close.
+type inspectConn struct {
+	net.Conn
+	insp *Inspector
+}
+
+func (c *inspectConn) Close() error {
+	c.insp.delConn(c)
+	return c.Conn.Close()
+}
+
+func (c *inspectConn) AbortiveClose() error {
+	c.insp.delConn(c)
+	return AbortiveClose(c.Conn)
+}
+
+func (c *inspectConn) Unwrap() net.Conn {
+	return c.Conn
+}
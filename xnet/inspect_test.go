@@ -0,0 +1,119 @@
+// Copyright (C) 2026  Nexedi SA and Contributors.
+//                     Kirill Smelkov <kirr@nexedi.com>
+//
+// This program is free software: you can Use, Study, Modify and Redistribute
+// it under the terms of the GNU General Public License version 3, or (at your
+// option) any later version, as published by the Free Software Foundation.
+//
+// You can also Link and Combine this program with other software covered by
+// the terms of any of the Free Software licenses or any of the Open Source
+// Initiative approved licenses and Convey the resulting work. Corresponding
+// source of such a combination shall include the source code for all other
+// software used.
+//
+// This program is distributed WITHOUT ANY WARRANTY; without even the implied
+// warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+//
+// See COPYING file for full licensing terms.
+// See https://www.nexedi.com/licensing for rationale and options.
+
+package xnet
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestNetInspect(t *testing.T) {
+	bg := context.Background()
+	n, insp := NetInspect(NetPlain("tcp"))
+	defer n.Close()
+
+	xlisten := func() Listener {
+		t.Helper()
+		l, err := n.Listen(bg, "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return l
+	}
+	xdial := func(l Listener) net.Conn {
+		t.Helper()
+		c, err := n.Dial(bg, l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	xaccept := func(l Listener) net.Conn {
+		t.Helper()
+		c, err := l.Accept(bg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	assertEq := func(have, want interface{}) {
+		t.Helper()
+		if !reflect.DeepEqual(have, want) {
+			t.Fatalf("have: %v\nwant: %v", have, want)
+		}
+	}
+	info := func(c net.Conn) ConnInfo {
+		return ConnInfo{c.LocalAddr(), c.RemoteAddr()}
+	}
+
+	assertEq(insp.Listeners(), []net.Addr(nil))
+	assertEq(insp.Conns(), []ConnInfo(nil))
+
+	l1 := xlisten()
+	l2 := xlisten()
+	assertEq(insp.Listeners(), []net.Addr{l1.Addr(), l2.Addr()})
+
+	c1 := xdial(l1)
+	c1s := xaccept(l1)
+	c2 := xdial(l2)
+	assertEq(insp.Conns(), []ConnInfo{info(c1), info(c1s), info(c2)})
+
+	// close deregisters
+	err := c1.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEq(insp.Conns(), []ConnInfo{info(c1s), info(c2)})
+
+	err = l1.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEq(insp.Listeners(), []net.Addr{l2.Addr()})
+
+	c2s := xaccept(l2)
+	assertEq(insp.Conns(), []ConnInfo{info(c1s), info(c2), info(c2s)})
+
+	for _, c := range []net.Conn{c1s, c2, c2s} {
+		err = c.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = l2.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEq(insp.Listeners(), []net.Addr(nil))
+	assertEq(insp.Conns(), []ConnInfo(nil))
+}
+
+func TestInspectAbortiveClose(t *testing.T) {
+	n, insp := NetInspect(NetPlain("tcp"))
+	defer n.Close()
+	c, cs := tcpPipe(t, n)
+	defer c.Close()
+	abortiveCloseTCP(t, cs, c)
+	if conns := insp.Conns(); len(conns) != 1 {
+		t.Fatalf("conns after abortive close: %v  ; want only dialed one", conns)
+	}
+}
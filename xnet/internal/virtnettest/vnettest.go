@@ -22,8 +22,10 @@ package virtnettest
 
 import (
 	"context"
+	"errors"
 	"io"
 	"net"
+	"syscall"
 	"testing"
 
 	"golang.org/x/sync/errgroup"
@@ -107,7 +109,7 @@ func TestBasic(t *testing.T, subnet *virtnet.SubNetwork) {
 	assert.Eq(hβ.Name(), "β")
 
 	_, err = hα.Dial(ctx, ":0")
-	assert.Eq(err, &net.OpError{Op: "dial", Net: subnet.Network(), Source: xaddr("α:1"), Addr: xaddr("α:0"), Err: virtnet.ErrConnRefused})
+	assert.Eq(err, &net.OpError{Op: "dial", Net: subnet.Network(), Source: xaddr("α:1"), Addr: xaddr("α:0"), Err: virtnet.ErrInvalidPort})
 
 	l1, err := hα.Listen(ctx, "")
 	X(err)
@@ -115,7 +117,7 @@ func TestBasic(t *testing.T, subnet *virtnet.SubNetwork) {
 
 	// zero port always stays unused even after autobind
 	_, err = hα.Dial(ctx, ":0")
-	assert.Eq(err, &net.OpError{Op: "dial", Net: subnet.Network(), Source: xaddr("α:2"), Addr: xaddr("α:0"), Err: virtnet.ErrConnRefused})
+	assert.Eq(err, &net.OpError{Op: "dial", Net: subnet.Network(), Source: xaddr("α:2"), Addr: xaddr("α:0"), Err: virtnet.ErrInvalidPort})
 
 	wg := &errgroup.Group{}
 	wg.Go(exc.Funcx(func() {
@@ -152,4 +154,81 @@ func TestBasic(t *testing.T, subnet *virtnet.SubNetwork) {
 
 	l2 := xlisten(ctx, hα, ":0") // autobind again
 	assert.Eq(l2.Addr(), xaddr("α:4"))
+
+	// host can dial itself
+	wg = &errgroup.Group{}
+	wg.Go(exc.Funcx(func() {
+		c3s := xaccept(ctx, l1)
+		assert.Eq(c3s.LocalAddr(), xaddr("α:6"))
+		assert.Eq(c3s.RemoteAddr(), xaddr("α:5"))
+
+		assert.Eq(xread(c3s), "self")
+		xwrite(c3s, "loop")
+	}))
+
+	c3c := xdial(hα, "α:1")
+	assert.Eq(c3c.LocalAddr(), xaddr("α:5"))
+	assert.Eq(c3c.RemoteAddr(), xaddr("α:6"))
+
+	xwrite(c3c, "self")
+	assert.Eq(xread(c3c), "loop")
+
+	xwait(wg)
+}
+
+// TestAbortiveClose verifies that peer observes connection reset after
+// xnet.AbortiveClose, and EOF after regular Close.
+func TestAbortiveClose(t *testing.T, subnet *virtnet.SubNetwork) {
+	X := exc.Raiseif
+	ctx := context.Background()
+	assert := xtesting.Assert(t)
+
+	defer func() {
+		err := subnet.Close()
+		X(err)
+	}()
+
+	hα, err := subnet.NewHost(ctx, "α")
+	X(err)
+
+	hβ, err := subnet.NewHost(ctx, "β")
+	X(err)
+
+	l, err := hα.Listen(ctx, "")
+	X(err)
+
+	// dialAccept establishes connection β -> α.
+	dialAccept := func() (cβ, cα net.Conn) {
+		wg := &errgroup.Group{}
+		wg.Go(exc.Funcx(func() {
+			cα = xaccept(ctx, l)
+		}))
+		cβ = xdial(hβ, l.Addr().String())
+		xwait(wg)
+		return cβ, cα
+	}
+
+	buf := make([]byte, 1)
+
+	// regular close -> EOF
+	cβ, cα := dialAccept()
+	X(cβ.Close())
+	_, err = cα.Read(buf)
+	assert.Eq(err, io.EOF)
+	X(cα.Close())
+
+	// abortive close -> connection reset
+	cβ, cα = dialAccept()
+	X(xnet.AbortiveClose(cβ))
+	_, err = cα.Read(buf)
+	if !errors.Is(err, syscall.ECONNRESET) {
+		t.Fatalf("read after peer abortive close: %v  ; want %v", err, syscall.ECONNRESET)
+	}
+	X(cα.Close())
+
+	// aborted connection is closed locally
+	_, err = cβ.Write([]byte("x"))
+	if err == nil {
+		t.Fatal("write after abortive close: no error")
+	}
 }
@@ -83,6 +83,7 @@ ErrSockDown         = neterror(errno.EBADFD,         "socket is down")
 ErrAddrAlreadyUsed  = neterror(errno.EADDRINUSE,     "address already in use")
 ErrAddrNoListen     = neterror(errno.EADDRNOTAVAIL,  "cannot listen on requested address")
 ErrConnRefused      = neterror(errno.ECONNREFUSED,   "connection refused")
+ErrInvalidPort      = neterror(errno.EINVAL,         "cannot dial port 0")
 
 ErrNoHost           = neterror("no such host")
 ErrHostDup          = neterror("host already registered")
@@ -499,6 +500,10 @@ def dial(h, addr):
         dst = h._parseAddr(addr)
         n   = h._subnet
 
+        # port 0 is used only to request autobind on listen
+        if dst.port == 0:
+            raise ErrInvalidPort
+
         # XXX cancel on host shutdown
 
         dstdata = n._registry.query(dst.host)
@@ -79,6 +79,14 @@ package lonet
 // Correspondingly when a host joins the network, it announces itself to the
 // registry so that other hosts could see it.
 //
+// By default OS-level listening address is TCP address on loopback. With
+// Config.Unix it is path of Unix-domain socket under
+//
+//	/<tmp>/lonet/<network>/sock*/
+//
+// and whether to dial β via TCP or Unix socket is decided by form of β
+// address: Unix socket paths start with "/".
+//
 //
 // Handshake protocol
 //
@@ -118,7 +126,9 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 
@@ -142,6 +152,7 @@ type subNetwork struct {
 	// OS-level listener of this subnetwork.
 	// whenever connection to subnet's host is tried to be established it goes here.
 	oslistener xnet.Listener
+	osdir      string // !"" -> directory with Unix socket of oslistener
 
 	// accepted connections are further routed here for virtnet to handle.
 	vnotify virtnet.Notifier
@@ -156,6 +167,7 @@ type vengine struct {
 }
 
 var tcp4 = xnet.NetPlain("tcp4")
+var unix = xnet.NetPlain("unix")
 
 // Join joins or creates new lonet network with given name.
 //
@@ -168,7 +180,29 @@ var tcp4 = xnet.NetPlain("tcp4")
 //
 // See package lab.nexedi.com/kirr/go123/xnet/virtnet for documentation on how
 // to use returned subnetwork.
-func Join(ctx context.Context, network string) (_ *virtnet.SubNetwork, err error) {
+func Join(ctx context.Context, network string) (*virtnet.SubNetwork, error) {
+	return JoinWithConfig(ctx, network, Config{})
+}
+
+// Config represents configuration of joined lonet subnetwork.
+type Config struct {
+	// RegistryBusyTimeout is how long registry operations wait for the
+	// registry database to be unlocked by another process.
+	// Zero means default.
+	RegistryBusyTimeout time.Duration
+
+	// Unix instructs to use Unix-domain socket under the network
+	// directory instead of TCP loopback for OS-level connections to hosts
+	// of the subnetwork. This has lower overhead and does not consume
+	// TCP ports. The handshake protocol is the same.
+	//
+	// Other subnetworks of the network dial such hosts via Unix socket
+	// regardless of their own Config.Unix.
+	Unix bool
+}
+
+// JoinWithConfig is like Join but allows to specify configuration.
+func JoinWithConfig(ctx context.Context, network string, config Config) (_ *virtnet.SubNetwork, err error) {
 	defer xerr.Contextf(&err, "lonet: join %q", network)
 
 	// create/join registry under /tmp/lonet/<network>/registry.db
@@ -191,20 +225,32 @@ func Join(ctx context.Context, network string) (_ *virtnet.SubNetwork, err error
 		return nil, err
 	}
 
-	registry, err := openRegistrySQLite(ctx, netdir + "/registry.db", network)
+	registry, err := openRegistrySQLite(ctx, netdir + "/registry.db", network, config.RegistryBusyTimeout)
 	if err != nil {
 		return nil, err
 	}
 
 	// start OS listener
-	oslistener, err := tcp4.Listen(ctx, "127.0.0.1:")
+	var oslistener xnet.Listener
+	var osdir string
+	if config.Unix {
+		osdir, err = ioutil.TempDir(netdir, "sock")
+		if err == nil {
+			oslistener, err = unix.Listen(ctx, osdir + "/sock")
+			if err != nil {
+				os.RemoveAll(osdir)
+			}
+		}
+	} else {
+		oslistener, err = tcp4.Listen(ctx, "127.0.0.1:")
+	}
 	if err != nil {
 		registry.Close()
 		return nil, err
 	}
 
 	// joined ok
-	losubnet := &subNetwork{oslistener: oslistener}
+	losubnet := &subNetwork{oslistener: oslistener, osdir: osdir}
 	engine := &vengine{losubnet}
 	subnet, vnotify := virtnet.NewSubNetwork(netPrefix + network, engine, registry)
 	losubnet.vnet = subnet
@@ -224,8 +270,15 @@ func (v *vengine) Close() (err error) {
 	n := v.subnet
 	defer xerr.Contextf(&err, "lonet %q: close", n.network())
 
-	n.serveCancel()             // this will cancel loaccepts spawned by serve
-	return n.oslistener.Close() // this will interrupt Accept in serve
+	n.serveCancel()            // this will cancel loaccepts spawned by serve
+	err = n.oslistener.Close() // this will interrupt Accept in serve
+	if n.osdir != "" {
+		err2 := os.RemoveAll(n.osdir)
+		if err == nil {
+			err = err2
+		}
+	}
+	return err
 }
 
 // serve serves incoming OS-level connections to this subnetwork.
@@ -483,7 +536,11 @@ func (v *vengine) VNetDial(ctx context.Context, src, dst *virtnet.Addr, dstoslad
 	n := v.subnet
 
 	// dial to OS addr for host and perform lonet handshake
-	osconn, err := tcp4.Dial(ctx, dstosladdr)
+	osnet := tcp4
+	if strings.HasPrefix(dstosladdr, "/") {
+		osnet = unix
+	}
+	osconn, err := osnet.Dial(ctx, dstosladdr)
 	if err != nil {
 		return nil, nil, err
 	}
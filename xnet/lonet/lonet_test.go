@@ -21,18 +21,28 @@ package lonet
 
 import (
 	"context"
+	"errors"
+	"io"
 	"io/ioutil"
 	"log"
+	"math/rand"
+	"net"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
+	"syscall"
 	"testing"
+	"time"
 
+	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
 	"golang.org/x/sync/errgroup"
 
 	"lab.nexedi.com/kirr/go123/exc"
 	"lab.nexedi.com/kirr/go123/internal/xtesting"
 	"lab.nexedi.com/kirr/go123/xerr"
+	"lab.nexedi.com/kirr/go123/xnet"
 	"lab.nexedi.com/kirr/go123/xnet/internal/virtnettest"
 	"lab.nexedi.com/kirr/go123/xnet/virtnet"
 )
@@ -47,6 +57,76 @@ func TestLonetGoGo(t *testing.T) {
 	virtnettest.TestBasic(t, subnet)
 }
 
+func TestLonetAbortiveClose(t *testing.T) {
+	subnet, err := Join(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	virtnettest.TestAbortiveClose(t, subnet)
+}
+
+func TestLonetGoGoUnix(t *testing.T) {
+	subnet, err := JoinWithConfig(bg, "", Config{Unix: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	virtnettest.TestBasic(t, subnet)
+}
+
+// verify that with Config.Unix hosts are announced with Unix socket paths and
+// that such hosts can be dialed from TCP subnetwork.
+func TestLonetUnix(t *testing.T) {
+	assert := xtesting.Assert(t)
+
+	subnet1, err := JoinWithConfig(bg, "", Config{Unix: true}); X(err)
+	network := strings.TrimPrefix(subnet1.Network(), "lonet")
+	subnet2, err := Join(bg, network); X(err)
+	defer func() {
+		err := subnet2.Close(); X(err)
+	}()
+
+	hα, err := subnet1.NewHost(bg, "α"); X(err)
+	hβ, err := subnet2.NewHost(bg, "β"); X(err)
+
+	netdir := os.TempDir() + "/lonet/" + network
+	r, err := openRegistrySQLite(bg, netdir + "/registry.db", network, 0); X(err)
+	defer r.Close()
+	sockα, err := r.Query(bg, "α"); X(err)
+	if !strings.HasPrefix(sockα, netdir + "/sock") {
+		t.Fatalf("α: registry: %q  ; want socket under %s", sockα, netdir)
+	}
+	st, err := os.Stat(sockα); X(err)
+	assert.Eq(st.Mode() & os.ModeSocket != 0, true)
+	osladdrβ, err := r.Query(bg, "β"); X(err)
+	assert.Eq(strings.HasPrefix(osladdrβ, "127.0.0.1:"), true)
+
+	// Unix subnetwork dials host on TCP subnetwork, and vice versa
+	lα, err := hα.Listen(bg, ":1"); X(err)
+	lβ, err := hβ.Listen(bg, ":1"); X(err)
+	xconnect := func(h *virtnet.Host, l xnet.Listener, addr string) {
+		wg := &errgroup.Group{}
+		wg.Go(func() error {
+			c, err := l.Accept(bg)
+			if err != nil {
+				return err
+			}
+			return c.Close()
+		})
+		c, err := h.Dial(bg, addr); X(err)
+		X(wg.Wait())
+		X(c.Close())
+	}
+	xconnect(hβ, lα, "α:1")
+	xconnect(hα, lβ, "β:1")
+
+	// socket directory is removed on close
+	err = subnet1.Close(); X(err)
+	_, err = os.Stat(filepath.Dir(sockα))
+	assert.Eq(os.IsNotExist(err), true)
+}
+
 func TestLonetPyPy(t *testing.T) {
 	needPy(t)
 	err := pytest("-k", "test_lonet_py_basic", "lonet_test.py")
@@ -111,7 +191,179 @@ func TestLonetGoPy(t *testing.T) {
 	err = wg.Wait(); X(err)
 }
 
+// verify that lonet connections expose raw OS-level connection.
+func TestLonetSyscallConn(t *testing.T) {
+	subnet, err := Join(bg, ""); X(err)
+	defer func() {
+		err := subnet.Close(); X(err)
+	}()
+
+	hα, err := subnet.NewHost(bg, "α"); X(err)
+	hβ, err := subnet.NewHost(bg, "β"); X(err)
+	lβ, err := hβ.Listen(bg, ""); X(err)
+
+	wg := &errgroup.Group{}
+	var cβ net.Conn
+	wg.Go(func() (err error) {
+		cβ, err = lβ.Accept(bg)
+		return err
+	})
+	cα, err := hα.Dial(bg, "β:1"); X(err)
+	X(wg.Wait())
+
+	for _, c := range []net.Conn{cα, cβ} {
+		raw, err := c.(syscall.Conn).SyscallConn(); X(err)
+		var fd uintptr
+		err = raw.Control(func(fd_ uintptr) {
+			fd = fd_
+		}); X(err)
+		if fd == 0 {
+			t.Errorf("%s: raw conn: fd = 0", c.LocalAddr())
+		}
+		X(c.Close())
+	}
+}
+
 
+// verify that after Migrate dials to the host go to its new address, while
+// already established connections continue to work.
+func TestLonetMigrate(t *testing.T) {
+	subnet, err := Join(bg, ""); X(err)
+	defer func() {
+		err := subnet.Close(); X(err)
+	}()
+
+	hα, err := subnet.NewHost(bg, "α"); X(err)
+	hβ, err := subnet.NewHost(bg, "β"); X(err)
+	lα, err := hα.Listen(bg, ""); X(err)
+	defer lα.Close()
+
+	// dial establishes connection β -> α and exchanges some data over it
+	dial := func() net.Conn {
+		wg := &errgroup.Group{}
+		wg.Go(func() error {
+			c, err := lα.Accept(bg)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(c, c)
+			return err
+		})
+		c, err := hβ.Dial(bg, "α:1"); X(err)
+		_, err = c.Write([]byte("ping")); X(err)
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(c, buf); X(err)
+		if string(buf) != "ping" {
+			t.Fatalf("echo: got %q  ; want \"ping\"", buf)
+		}
+		return c
+	}
+
+	c1 := dial()
+
+	// α moves to new OS listener, that forwards connections to its old address.
+	network := strings.TrimPrefix(subnet.Network(), "lonet")
+	r, err := openRegistrySQLite(bg, os.TempDir() + "/lonet/" + network + "/registry.db", network, 0); X(err)
+	defer r.Close()
+	oldaddr, err := r.Query(bg, "α"); X(err)
+	osl, err := net.Listen("tcp4", "127.0.0.1:0"); X(err)
+	defer osl.Close()
+	naccept := make(chan struct{}, 1)
+	go func() {
+		for {
+			c, err := osl.Accept()
+			if err != nil {
+				return
+			}
+			naccept <- struct{}{}
+			go forward(c, oldaddr)
+		}
+	}()
+
+	err = hα.Migrate(bg, osl.Addr().String()); X(err)
+
+	c2 := dial()
+	select {
+	case <-naccept:
+		// ok
+	default:
+		t.Fatal("dial after migrate did not go to new address")
+	}
+
+	// the connection established before migration still works
+	_, err = c1.Write([]byte("ping")); X(err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(c1, buf); X(err)
+
+	X(c1.Close())
+	X(c2.Close())
+}
+
+// verify that NewHost is aborted by ctx deadline even if registry db is locked.
+func TestLonetNewHostTimeout(t *testing.T) {
+	subnet, err := Join(bg, ""); X(err)
+	defer func() {
+		err := subnet.Close(); X(err)
+	}()
+
+	// lock registry db as if it was held by stuck process
+	network := strings.TrimPrefix(subnet.Network(), "lonet")
+	conn, err := sqlite.OpenConn(os.TempDir() + "/lonet/" + network + "/registry.db", 0); X(err)
+	defer func() {
+		err := conn.Close(); X(err)
+	}()
+	err = sqlitex.ExecTransient(conn, "BEGIN EXCLUSIVE", nil); X(err)
+
+	ctx, cancel := context.WithTimeout(bg, time.Millisecond)
+	defer cancel()
+	t0 := time.Now()
+	_, err = subnet.NewHost(ctx, "α")
+	δt := time.Since(t0)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("new host: err = %v  ; want deadline exceeded", err)
+	}
+	if δt > time.Second {
+		t.Fatalf("new host: took %s to abort", δt)
+	}
+
+	err = sqlitex.ExecTransient(conn, "ROLLBACK", nil); X(err)
+}
+
+// verify that NewHostAuto retries with another name if generated name is
+// already taken by another subnetwork of the network.
+func TestLonetNewHostAuto(t *testing.T) {
+	assert := xtesting.Assert(t)
+
+	subnet1, err := Join(bg, ""); X(err)
+	defer func() {
+		err := subnet1.Close(); X(err)
+	}()
+	network := strings.TrimPrefix(subnet1.Network(), "lonet")
+	subnet2, err := Join(bg, network); X(err)
+	defer func() {
+		err := subnet2.Close(); X(err)
+	}()
+
+	// both subnetworks generate the same names
+	subnet1.SetNameSource(rand.New(rand.NewSource(1)))
+	subnet2.SetNameSource(rand.New(rand.NewSource(1)))
+
+	h1, err := subnet1.NewHostAuto(bg); X(err)
+	h2, err := subnet2.NewHostAuto(bg); X(err)
+	assert.Eq(h1.Name() != h2.Name(), true)
+}
+
+// forward forwards data in between c and new connection to addr.
+func forward(c net.Conn, addr string) {
+	defer c.Close()
+	c2, err := net.Dial("tcp4", addr)
+	if err != nil {
+		return
+	}
+	defer c2.Close()
+	go io.Copy(c2, c)
+	io.Copy(c, c2)
+}
 
 var havePy = false
 var workRoot string
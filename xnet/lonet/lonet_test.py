@@ -69,10 +69,10 @@ def _test_virtnet_basic(subnet):
     try:
         ha.dial(":0")
     except Exception as e:
-        assert xerr.cause(e) is lonet.ErrConnRefused
-        assert str(e) == "dial %s α:1->α:0: [Errno %d] connection refused" % (subnet.network(), errno.ECONNREFUSED)
+        assert xerr.cause(e) is lonet.ErrInvalidPort
+        assert str(e) == "dial %s α:1->α:0: [Errno %d] cannot dial port 0" % (subnet.network(), errno.EINVAL)
     else:
-        assert 0, "connection not refused"
+        assert 0, "dial to port 0 succeeded"
 
     l1 = ha.listen("")
     assert l1.addr() == xaddr("α:1")
@@ -80,10 +80,10 @@ def _test_virtnet_basic(subnet):
     try:
         ha.dial(":0")
     except Exception as e:
-        assert xerr.cause(e) is lonet.ErrConnRefused
-        assert str(e) == "dial %s α:2->α:0: [Errno %d] connection refused" % (subnet.network(), errno.ECONNREFUSED)
+        assert xerr.cause(e) is lonet.ErrInvalidPort
+        assert str(e) == "dial %s α:2->α:0: [Errno %d] cannot dial port 0" % (subnet.network(), errno.EINVAL)
     else:
-        assert 0, "connection not refused"
+        assert 0, "dial to port 0 succeeded"
 
 
     def Tsrv():
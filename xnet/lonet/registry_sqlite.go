@@ -24,6 +24,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"crawshaw.io/sqlite"
 	"crawshaw.io/sqlite/sqlitex"
@@ -50,16 +51,30 @@ const schemaVer = "lonet.1"
 type sqliteRegistry struct {
 	dbpool *sqlitex.Pool
 
-	uri    string	// URI db was originally opened with
+	uri         string		// URI db was originally opened with
+	busyTimeout time.Duration	// 0 -> busyTimeoutDefault
 }
 
+// busyTimeoutDefault is how long an operation waits for db lock by default.
+const busyTimeoutDefault = 10*time.Second
+
+// busyPoll is the step in which an operation waits for db lock.
+//
+// sqlite does not interrupt waiting for a lock on ctx cancel, so the waiting
+// is done in small steps with ctx checked in between them.
+const busyPoll = 10*time.Millisecond
+
 // openRegistrySQLite opens SQLite registry located at dburi.
 //
 // the registry is setup/verified to be serving specified lonet network.
-func openRegistrySQLite(ctx context.Context, dburi, network string) (_ *sqliteRegistry, err error) {
-	r := &sqliteRegistry{uri: dburi}
+// busyTimeout, if !0, limits how long an operation waits for db lock held by
+// another connection, e.g. from another process.
+func openRegistrySQLite(ctx context.Context, dburi, network string, busyTimeout time.Duration) (_ *sqliteRegistry, err error) {
+	r := &sqliteRegistry{uri: dburi, busyTimeout: busyTimeout}
 	defer r.regerr(&err, "open")
 
+	// default flags include SQLITE_OPEN_WAL: WAL allows readers to proceed
+	// concurrently with writer.
 	dbpool, err := sqlitex.Open(dburi, 0, /* poolSize= */16)	// XXX pool size ok?
 	if err != nil {
 		return nil, err
@@ -86,6 +101,8 @@ func (r *sqliteRegistry) Close() (err error) {
 // withConn runs f on a dbpool connection.
 //
 // connection is first allocated from dbpool and put back after call to f.
+// f is retried, for up to busyTimeout time, if it fails due to db being locked.
+// If ctx is canceled, the operation is aborted promptly with ctx error.
 func (r *sqliteRegistry) withConn(ctx context.Context, f func(*sqlite.Conn) error) error {
 	conn := r.dbpool.Get(ctx)
 	if conn == nil {
@@ -96,7 +113,44 @@ func (r *sqliteRegistry) withConn(ctx context.Context, f func(*sqlite.Conn) erro
 		return virtnet.ErrRegistryDown // db closed
 	}
 	defer r.dbpool.Put(conn)
-	return f(conn)
+
+	busyTimeout := r.busyTimeout
+	if busyTimeout == 0 {
+		busyTimeout = busyTimeoutDefault
+	}
+	deadline := time.Now().Add(busyTimeout)
+	conn.SetBusyTimeout(busyPoll)
+
+	for {
+		err := f(conn)
+		if (isBusy(err) || isInterrupt(err)) && ctx.Err() != nil {
+			// interrupted, or gave up waiting for lock, due to ctx cancel
+			return ctx.Err()
+		}
+		if !(isBusy(err) && time.Now().Before(deadline)) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(busyPoll):
+		}
+	}
+}
+
+// isBusy returns whether err is due to db being locked.
+func isBusy(err error) bool {
+	code := sqlite.ErrCode(err)
+	return code&0xff == sqlite.SQLITE_BUSY || code&0xff == sqlite.SQLITE_LOCKED
+}
+
+// isInterrupt returns whether err is due to operation being interrupted.
+//
+// dbpool interrupts operations on a connection when ctx, with which the
+// connection was retrieved, is canceled.
+func isInterrupt(err error) bool {
+	return sqlite.ErrCode(err) == sqlite.SQLITE_INTERRUPT
 }
 
 var errNoRows   = errors.New("query: empty result")
@@ -228,7 +282,7 @@ func (r *sqliteRegistry) Announce(ctx context.Context, hostname, osladdr string)
 			hostname, osladdr)
 
 		switch sqlite.ErrCode(err) {
-		case sqlite.SQLITE_CONSTRAINT_UNIQUE:
+		case sqlite.SQLITE_CONSTRAINT_UNIQUE, sqlite.SQLITE_CONSTRAINT_PRIMARYKEY:
 			err = virtnet.ErrHostDup
 		}
 
@@ -236,6 +290,43 @@ func (r *sqliteRegistry) Announce(ctx context.Context, hostname, osladdr string)
 	})
 }
 
+// Reannounce implements virtnet.Reannouncer .
+func (r *sqliteRegistry) Reannounce(ctx context.Context, hostname, osladdr string) (err error) {
+	defer r.regerr(&err, "reannounce", hostname, osladdr)
+
+	return r.withConn(ctx, func(conn *sqlite.Conn) error {
+		err := sqlitex.Exec(conn,
+			"UPDATE hosts SET osladdr = ? WHERE hostname = ?", nil,
+			osladdr, hostname)
+		if err != nil {
+			return err
+		}
+		if conn.Changes() == 0 {
+			return virtnet.ErrNoHost
+		}
+		return nil
+	})
+}
+
+// List implements virtnet.Lister .
+func (r *sqliteRegistry) List(ctx context.Context) (hostTab map[string]string, err error) {
+	defer r.regerr(&err, "list")
+
+	err = r.withConn(ctx, func(conn *sqlite.Conn) error {
+		hostTab = make(map[string]string)
+		return sqlitex.Exec(conn, "SELECT hostname, osladdr FROM hosts",
+			func (stmt *sqlite.Stmt) error {
+				hostTab[stmt.ColumnText(0)] = stmt.ColumnText(1)
+				return nil
+			})
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return hostTab, nil
+}
+
 var errRegDup = errors.New("registry broken: duplicate host entries")
 
 // Query implements registry.
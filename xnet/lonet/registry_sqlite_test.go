@@ -21,10 +21,17 @@ package lonet
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"testing"
+	"time"
+
+	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
+	"golang.org/x/sync/errgroup"
 
 	"lab.nexedi.com/kirr/go123/exc"
+	"lab.nexedi.com/kirr/go123/internal/xtesting"
 	"lab.nexedi.com/kirr/go123/xnet/virtnet"
 )
 
@@ -82,7 +89,7 @@ func (t *registryTester) Announce(hostname, osladdr string, errv ...error) {
 		// error expected
 		// XXX construct full registry error around ewant + reflect.DeepCompare?
 		e, ok := err.(*virtnet.RegistryError)
-		if (!ok && e.Err == ewant) {
+		if !(ok && e.Err == ewant) {
 			t.Fatalf("%s: announce %q %q:\nwant %v\nhave: %v",
 				r.uri, hostname, osladdr, ewant, err)
 		}
@@ -94,6 +101,37 @@ func (t *registryTester) Announce(hostname, osladdr string, errv ...error) {
 	}
 }
 
+// Reannounce checks that result of Reannounce(hostname, osladdr) is as expected.
+//
+// if len(errv) == 1 - it checks that Reannounce returns error with cause == errv[0].
+// otherwise it will check that Reannounce succeeds and returns nil error.
+func (t *registryTester) Reannounce(hostname, osladdr string, errv ...error) {
+	t.Helper()
+	r := t.r
+
+	err := r.Reannounce(context.Background(), hostname, osladdr)
+	var ewant error
+	if len(errv) > 0 {
+		ewant = errv[0]
+		if len(errv) > 1 {
+			panic("only 1 error allowed in reannounce check")
+		}
+	}
+	if ewant != nil {
+		// error expected
+		e, ok := err.(*virtnet.RegistryError)
+		if !(ok && e.Err == ewant) {
+			t.Fatalf("%s: reannounce %q %q:\nwant %v\nhave: %v",
+				r.uri, hostname, osladdr, ewant, err)
+		}
+	} else {
+		// !error expected
+		if err != nil {
+			t.Fatalf("%s: reannounce %q %q: %s", r.uri, hostname, osladdr, err)
+		}
+	}
+}
+
 // handy shortcuts for registry errors, ...
 var ø     = virtnet.ErrNoHost
 var DUP   = virtnet.ErrHostDup
@@ -104,10 +142,11 @@ var bg = context.Background()
 
 
 func TestRegistrySQLite(t *testing.T) {
+	assert := xtesting.Assert(t)
 	work := xworkdir(t)
 	dbpath := work + "/1.db"
 
-	r1, err := openRegistrySQLite(bg, dbpath, "aaa")
+	r1, err := openRegistrySQLite(bg, dbpath, "aaa", 0)
 	X(err)
 
 	t1 := &registryTester{t, r1}
@@ -118,7 +157,7 @@ func TestRegistrySQLite(t *testing.T) {
 	t1.Query("α", "alpha:1234")
 	t1.Query("β", ø)
 
-	r2, err := openRegistrySQLite(bg, dbpath, "aaa")
+	r2, err := openRegistrySQLite(bg, dbpath, "aaa", 0)
 	X(err)
 
 	t2 := &registryTester{t, r2}
@@ -129,12 +168,25 @@ func TestRegistrySQLite(t *testing.T) {
 
 	t1.Query("β", "beta:zzz")
 
+	hostTab, err := r1.List(bg)
+	X(err)
+	assert.Eq(hostTab, map[string]string{"α": "alpha:1234", "β": "beta:zzz"})
+
+	t1.Reannounce("β", "beta:yyy")
+	t2.Query("β", "beta:yyy")
+	t1.Query("β", "beta:yyy")
+	t2.Reannounce("γ", "gamma:qqq", ø)
+	t2.Query("γ", ø)
+
 	X(r1.Close())
 
 	t1.Query("α", RDOWN)
 	t1.Query("β", RDOWN)
 	t1.Announce("γ", "gamma:qqq", RDOWN)
+	t1.Reannounce("α", "alpha:1235", RDOWN)
 	t1.Query("γ", RDOWN)
+	_, err = r1.List(bg)
+	assert.Eq(errors.Is(err, RDOWN), true)
 
 	t2.Query("α", "alpha:1234")
 
@@ -144,7 +196,7 @@ func TestRegistrySQLite(t *testing.T) {
 
 
 	// verify network mismatch detection works
-	r3, err := openRegistrySQLite(bg, dbpath, "bbb")
+	r3, err := openRegistrySQLite(bg, dbpath, "bbb", 0)
 	if !(r3 == nil && err != nil) {
 		t.Fatalf("network mismatch: not detected")
 	}
@@ -155,6 +207,85 @@ func TestRegistrySQLite(t *testing.T) {
 }
 
 
+// verify that concurrent announces from several registry instances, as
+// happens with several processes joining the same network, do not fail due
+// to db being locked.
+func TestRegistrySQLiteConcurrent(t *testing.T) {
+	work := xworkdir(t)
+	dbpath := work + "/1.db"
+
+	const nreg   = 4
+	const nhosts = 100
+
+	regv := []*sqliteRegistry{}
+	for i := 0; i < nreg; i++ {
+		r, err := openRegistrySQLite(bg, dbpath, "ddd", 5*time.Second)
+		X(err)
+		defer r.Close()
+		regv = append(regv, r)
+	}
+
+	wg := &errgroup.Group{}
+	for i := 0; i < nhosts; i++ {
+		r := regv[i % nreg]
+		host := fmt.Sprintf("h%d", i)
+		wg.Go(func() error {
+			return r.Announce(bg, host, host+":1")
+		})
+	}
+	X(wg.Wait())
+
+	t1 := &registryTester{t, regv[0]}
+	for i := 0; i < nhosts; i++ {
+		host := fmt.Sprintf("h%d", i)
+		t1.Query(host, host+":1")
+	}
+
+	// the db is in WAL mode, so that readers do not block writer
+	err := regv[0].withConn(bg, func(conn *sqlite.Conn) error {
+		mode, err := sqlitex.ResultText(conn.Prep("PRAGMA journal_mode;"))
+		if err != nil {
+			return err
+		}
+		if mode != "wal" {
+			return fmt.Errorf("journal_mode: have %q; want \"wal\"", mode)
+		}
+		return nil
+	})
+	X(err)
+}
+
+
+// verify that registry operation error, not due to db being locked or
+// operation interrupted, is reported as is even if ctx is canceled.
+func TestRegistrySQLiteCancelError(t *testing.T) {
+	assert := xtesting.Assert(t)
+	work := xworkdir(t)
+	dbpath := work + "/1.db"
+
+	r, err := openRegistrySQLite(bg, dbpath, "eee", 0)
+	X(err)
+	defer r.Close()
+
+	eboom := errors.New("boom")
+	ctx, cancel := context.WithCancel(bg)
+	defer cancel()
+	err = r.withConn(ctx, func(conn *sqlite.Conn) error {
+		cancel()
+		return eboom
+	})
+	assert.Eq(err, eboom)
+
+	// interrupted by ctx cancel -> ctx error
+	ctx, cancel = context.WithCancel(bg)
+	err = r.withConn(ctx, func(conn *sqlite.Conn) error {
+		cancel()
+		return sqlitex.Exec(conn, "SELECT * FROM hosts;", nil)
+	})
+	assert.Eq(err, context.Canceled)
+}
+
+
 // verify that go and python implementations of sqlite registry understand each other.
 func TestRegistrySQLitePyGo(t *testing.T) {
 	needPy(t)
@@ -162,7 +293,7 @@ func TestRegistrySQLitePyGo(t *testing.T) {
 	work := xworkdir(t)
 	dbpath := work + "/1.db"
 
-	r1, err := openRegistrySQLite(bg, dbpath, "ccc")
+	r1, err := openRegistrySQLite(bg, dbpath, "ccc", 0)
 	X(err)
 
 	t1 := &registryTester{t, r1}
@@ -24,12 +24,19 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"math/rand"
 	"net"
 	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"crypto/tls"
+	"crypto/x509"
 
 	"lab.nexedi.com/kirr/go123/xcontext"
+	"lab.nexedi.com/kirr/go123/xerr"
 	"lab.nexedi.com/kirr/go123/xsync"
 )
 
@@ -70,6 +77,38 @@ type Listener interface {
 	Addr() net.Addr
 }
 
+// ClosedChecker is optional interface that Networker can implement to tell
+// whether it was already closed.
+//
+// Networkers created by NetPlain, NetTLS, NetResolve, NetTrace, NetBlackhole,
+// NetRefuse, NetFaulty, NetCapture and NetInspect implement it. Wrapping
+// networkers report closed state of their inner networker.
+type ClosedChecker interface {
+	Closed() bool
+}
+
+// innerClosed returns whether inner networker, that is being wrapped, is closed.
+//
+// It is used by wrapping networkers to implement ClosedChecker.
+func innerClosed(inner Networker) bool {
+	c, ok := inner.(ClosedChecker)
+	return ok && c.Closed()
+}
+
+// DrainCloser is optional interface that Listener can implement to support
+// graceful shutdown.
+//
+// CloseDrain stops accepting new connections, but, contrary to Close, still
+// delivers connections that were already accepted, but not yet returned, to
+// Accept callers. It returns after all such connections are delivered, or,
+// with ctx error, after ctx is done. In the latter case not yet delivered
+// connections are closed.
+//
+// Listeners created by WithCtxL and NetPlain implement it.
+type DrainCloser interface {
+	CloseDrain(ctx context.Context) error
+}
+
 
 var hostname string
 func init() {
@@ -81,19 +120,48 @@ func init() {
 }
 
 var errNetClosed = errors.New("network access-point is closed")
+var errListenerClosed = errors.New("listener is closed")
 
 
 // NetPlain creates Networker corresponding to regular network accessors from std package net.
 //
 // network is "tcp", "tcp4", "tcp6", "unix", etc...
 func NetPlain(network string) Networker {
-	n := &netPlain{network: network, hostname: hostname}
+	return NetPlainWithConfig(network, NetPlainConfig{})
+}
+
+// NetPlainConfig provides optional configuration for NetPlainWithConfig.
+type NetPlainConfig struct {
+	// TCPConnHook, if !nil, is called on every dialed and accepted
+	// connection that is *net.TCPConn. It is not called for non-TCP networks.
+	//
+	// It can be used to adjust socket options, e.g. to disable Nagle via
+	// SetNoDelay, or to set linger and buffer sizes.
+	//
+	// If the hook returns an error, the connection is closed and the
+	// error is returned from corresponding Dial or Accept.
+	TCPConnHook func(*net.TCPConn) error
+
+	// ReadTimeout and WriteTimeout, if !0, set default timeouts for every
+	// Read and Write on dialed and accepted connections.
+	//
+	// The timeouts are enforced by setting connection deadline before
+	// every IO operation correspondingly.
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+}
+
+// NetPlainWithConfig is similar to NetPlain but allows to additionally
+// configure the networker.
+func NetPlainWithConfig(network string, config NetPlainConfig) Networker {
+	n := &netPlain{network: network, hostname: hostname, config: config}
 	n.ctx, n.cancel = context.WithCancel(context.Background())
 	return n
 }
 
 type netPlain struct {
 	network, hostname string
+	config            NetPlainConfig
 
 	// ctx.cancel is merged into context of network operations.
 	// ctx is cancelled on Close.
@@ -114,6 +182,10 @@ func (n *netPlain) Close() error {
 	return nil
 }
 
+func (n *netPlain) Closed() bool {
+	return n.ctx.Err() != nil
+}
+
 func (n *netPlain) Dial(ctx context.Context, addr string) (net.Conn, error) {
 	ctx, cancel := xcontext.Merge(ctx, n.ctx)
 	defer cancel()
@@ -128,6 +200,12 @@ func (n *netPlain) Dial(ctx context.Context, addr string) (net.Conn, error) {
 	if err == nil {
 		d := net.Dialer{}
 		conn, err = d.DialContext(ctx, n.network, addr)
+		if err == nil {
+			conn, err = n.configure(conn)
+			if err != nil {
+				err = dialErr(err)
+			}
+		}
 	} else {
 		err = dialErr(err)
 	}
@@ -179,9 +257,144 @@ func (n *netPlain) Listen(ctx context.Context, laddr string) (Listener, error) {
 		return nil, err
 	}
 
+	if n.config.TCPConnHook != nil || n.config.ReadTimeout != 0 || n.config.WriteTimeout != 0 {
+		rawl = &listenerConfigure{rawl, n}
+	}
+
 	return WithCtxL(rawl), nil
 }
 
+// configure applies config to established connection.
+//
+// It returns connection that should be used instead of conn.
+// On error conn is closed.
+func (n *netPlain) configure(conn net.Conn) (net.Conn, error) {
+	err := n.tcpHook(conn)
+	if err != nil {
+		conn.Close() // ignore err
+		return nil, err
+	}
+
+	if n.config.ReadTimeout != 0 || n.config.WriteTimeout != 0 {
+		conn = &timeoutConn{Conn: conn, readTimeout: n.config.ReadTimeout, writeTimeout: n.config.WriteTimeout}
+	}
+	return conn, nil
+}
+
+// tcpHook invokes config.TCPConnHook on conn if conn is TCP connection.
+func (n *netPlain) tcpHook(conn net.Conn) error {
+	hook := n.config.TCPConnHook
+	if hook == nil {
+		return nil
+	}
+	tcpconn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+	return hook(tcpconn)
+}
+
+// listenerConfigure wraps net.Listener to apply netPlain config to accepted connections.
+type listenerConfigure struct {
+	net.Listener
+	net *netPlain
+}
+
+func (l *listenerConfigure) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	conn, err = l.net.configure(conn)
+	if err != nil {
+		laddr := l.Listener.Addr()
+		return nil, &net.OpError{Op: "accept", Net: laddr.Network(), Addr: laddr, Err: err}
+	}
+	return conn, nil
+}
+
+// timeoutConn wraps net.Conn to enforce default timeouts on every Read and Write.
+//
+// Deadlines set by user via SetDeadline, SetReadDeadline and SetWriteDeadline
+// are remembered and take precedence over the default if they are earlier.
+type timeoutConn struct {
+	net.Conn
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+
+	mu            sync.Mutex
+	readDeadline  time.Time // deadline set by user; zero if not set
+	writeDeadline time.Time // ----//----
+}
+
+func (c *timeoutConn) Read(p []byte) (int, error) {
+	if c.readTimeout != 0 {
+		c.mu.Lock()
+		deadline := ioDeadline(c.readTimeout, c.readDeadline)
+		c.mu.Unlock()
+		err := c.Conn.SetReadDeadline(deadline)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return c.Conn.Read(p)
+}
+
+func (c *timeoutConn) Write(p []byte) (int, error) {
+	if c.writeTimeout != 0 {
+		c.mu.Lock()
+		deadline := ioDeadline(c.writeTimeout, c.writeDeadline)
+		c.mu.Unlock()
+		err := c.Conn.SetWriteDeadline(deadline)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return c.Conn.Write(p)
+}
+
+func (c *timeoutConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.readDeadline = t
+	c.writeDeadline = t
+	c.mu.Unlock()
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *timeoutConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.readDeadline = t
+	c.mu.Unlock()
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *timeoutConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.writeDeadline = t
+	c.mu.Unlock()
+	return c.Conn.SetWriteDeadline(t)
+}
+
+// ioDeadline returns deadline for IO operation started now with default
+// timeout and deadline set by user.
+//
+// It is the earliest of the two. Zero user deadline means no deadline.
+func ioDeadline(timeout time.Duration, userDeadline time.Time) time.Time {
+	deadline := time.Now().Add(timeout)
+	if !userDeadline.IsZero() && userDeadline.Before(deadline) {
+		deadline = userDeadline
+	}
+	return deadline
+}
+
+func (c *timeoutConn) AbortiveClose() error {
+	return AbortiveClose(c.Conn)
+}
+
+func (c *timeoutConn) Unwrap() net.Conn {
+	return c.Conn
+}
+
 // NetTLS wraps underlying networker with TLS layer according to config.
 //
 // The config must be valid:
@@ -189,12 +402,72 @@ func (n *netPlain) Listen(ctx context.Context, laddr string) (Listener, error) {
 //	- for tls.Client -- for Dial to work,
 //	- for tls.Server -- for Listen to work.
 func NetTLS(inner Networker, config *tls.Config) Networker {
-	return &netTLS{inner, config}
+	return NetTLSWithConfig(inner, config, NetTLSConfig{})
+}
+
+// TLSConfigFromPEM builds TLS configuration suitable for NetTLS out of PEM-encoded data.
+//
+// certPEM and keyPEM, if !nil, provide certificate, that is presented to
+// peers, and its private key. caPEM, if !nil, provides certificates of trusted
+// CAs. Depending on server, they are used to verify either server
+// certificates (RootCAs) or client certificates (ClientCAs). In the latter
+// case the server requires clients to present valid certificates.
+//
+// For client configuration ServerName usually needs to be set additionally.
+func TLSConfigFromPEM(certPEM, keyPEM, caPEM []byte, server bool) (_ *tls.Config, err error) {
+	defer xerr.Context(&err, "tls config from PEM")
+
+	config := &tls.Config{}
+	if certPEM != nil || keyPEM != nil {
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if server && len(config.Certificates) == 0 {
+		return nil, errors.New("server requires certificate")
+	}
+
+	if caPEM != nil {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, errors.New("no CA certificates found")
+		}
+		if server {
+			config.ClientCAs = pool
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		} else {
+			config.RootCAs = pool
+		}
+	}
+
+	return config, nil
+}
+
+// NetTLSConfig provides optional configuration for NetTLSWithConfig.
+type NetTLSConfig struct {
+	// VerifyPeer, if !nil, is called on every accepted connection after
+	// TLS handshake completes. The listener performs the handshake for this
+	// in background for every connection, so that Accept returns only
+	// connections that were handshaked and verified.
+	//
+	// It can be used to assert on certificates presented by clients in
+	// mutual-TLS setups. If VerifyPeer returns an error, the connection is
+	// closed and the error is returned from Accept.
+	VerifyPeer func(state tls.ConnectionState) error
+}
+
+// NetTLSWithConfig is similar to NetTLS but allows to additionally
+// configure the networker.
+func NetTLSWithConfig(inner Networker, config *tls.Config, tconfig NetTLSConfig) Networker {
+	return &netTLS{inner, config, tconfig}
 }
 
 type netTLS struct {
-	inner  Networker
-	config *tls.Config
+	inner   Networker
+	config  *tls.Config
+	tconfig NetTLSConfig
 }
 
 func (n *netTLS) Network() string {
@@ -209,6 +482,10 @@ func (n *netTLS) Close() error {
 	return n.inner.Close()
 }
 
+func (n *netTLS) Closed() bool {
+	return innerClosed(n.inner)
+}
+
 func (n *netTLS) Dial(ctx context.Context, addr string) (net.Conn, error) {
 	c, err := n.inner.Dial(ctx, addr)
 	if err != nil {
@@ -222,17 +499,48 @@ func (n *netTLS) Listen(ctx context.Context, laddr string) (Listener, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &listenerTLS{l, n}, nil
+	tl := &listenerTLS{innerl: l, net: n}
+	if n.tconfig.VerifyPeer != nil {
+		tl.acceptq = make(chan accepted)
+		ctx, cancel := context.WithCancel(context.Background())
+		tl.serveCtx = ctx
+		tl.serveCancel = cancel
+		tl.serveWG = xsync.NewWorkGroup(ctx)
+		tl.serveWG.Go(tl.serve)
+	}
+	return tl, nil
 }
 
 // listenerTLS implements Listener for netTLS.
+//
+// With VerifyPeer, connections are accepted from inner listener in background,
+// and TLS handshake and verification is performed for each connection in its
+// own goroutine. This way a slow or malicious client does not block accepting
+// other clients. Verified connections and errors are delivered to Accept via
+// acceptq.
 type listenerTLS struct {
 	innerl Listener
 	net    *netTLS
+
+	// with VerifyPeer
+	serveCtx    context.Context  // canceled on Close
+	serveCancel func()
+	serveWG     *xsync.WorkGroup // accept loop and handshakes are run under serveWG
+	acceptq     chan accepted
 }
 
 func (l *listenerTLS) Close() error {
-	return l.innerl.Close()
+	if l.serveCancel == nil {
+		return l.innerl.Close()
+	}
+	l.serveCancel()
+	err := l.innerl.Close()
+	_ = l.serveWG.Wait() // ignore err - it is always "canceled"
+	return err
+}
+
+func (l *listenerTLS) Unwrap() Listener {
+	return l.innerl
 }
 
 func (l *listenerTLS) Addr() net.Addr {
@@ -240,11 +548,820 @@ func (l *listenerTLS) Addr() net.Addr {
 }
 
 func (l *listenerTLS) Accept(ctx context.Context) (net.Conn, error) {
+	if l.acceptq == nil {
+		conn, err := l.innerl.Accept(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return tls.Server(conn, l.net.config), nil
+	}
+
+	var err error
+	select {
+	case a := <-l.acceptq:
+		return a.conn, a.err
+	case <-l.serveCtx.Done():
+		err = errListenerClosed
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	laddr := l.Addr()
+	return nil, &net.OpError{Op: "accept", Net: laddr.Network(), Addr: laddr, Err: err}
+}
+
+// serve accepts connections from inner listener and spawns handshake for each of them.
+func (l *listenerTLS) serve(ctx context.Context) error {
+	for {
+		conn, err := l.innerl.Accept(ctx)
+		if ctx.Err() != nil {
+			if conn != nil {
+				conn.Close() // ignore err
+			}
+			return ctx.Err()
+		}
+		if err != nil {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case l.acceptq <- accepted{nil, err}:
+			}
+			continue
+		}
+
+		tconn := tls.Server(conn, l.net.config)
+		l.serveWG.Go(func(ctx context.Context) error {
+			l.verify(ctx, tconn)
+			return nil
+		})
+	}
+}
+
+// verify performs TLS handshake on accepted connection, verifies the peer,
+// and delivers the result to Accept.
+func (l *listenerTLS) verify(ctx context.Context, tconn *tls.Conn) {
+	a := accepted{conn: tconn}
+	err := handshake(ctx, tconn)
+	if err == nil {
+		err = l.net.tconfig.VerifyPeer(tconn.ConnectionState())
+	}
+	if err != nil {
+		tconn.Close() // ignore err
+		laddr := l.Addr()
+		a = accepted{nil, &net.OpError{Op: "accept", Net: laddr.Network(), Addr: laddr, Err: err}}
+	}
+
+	select {
+	case l.acceptq <- a:
+		// ok
+	case <-ctx.Done():
+		// closed
+		if a.conn != nil {
+			a.conn.Close() // ignore err
+		}
+	}
+}
+
+// handshake performs TLS handshake on c with ctx cancellation support.
+func handshake(ctx context.Context, c *tls.Conn) error {
+	// interrupt handshake IO on ctx cancel
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			c.SetDeadline(time.Unix(1, 0)) // ignore err
+		}
+	}()
+
+	err := c.Handshake()
+	if err != nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	return err
+}
+
+
+// NetResolve wraps underlying networker with name resolution.
+//
+// Dial resolves host part of the address with resolve and dials the resulting
+// host with the same port via inner networker. This allows tests to use
+// symbolic names, e.g. "db:5432", instead of concrete addresses.
+// Listen is delegated to inner networker as is.
+func NetResolve(inner Networker, resolve func(name string) (addr string, err error)) Networker {
+	return &netResolve{inner, resolve}
+}
+
+type netResolve struct {
+	inner   Networker
+	resolve func(name string) (string, error)
+}
+
+func (n *netResolve) Network() string {
+	return n.inner.Network()
+}
+
+func (n *netResolve) Name() string {
+	return n.inner.Name()
+}
+
+func (n *netResolve) Close() error {
+	return n.inner.Close()
+}
+
+func (n *netResolve) Closed() bool {
+	return innerClosed(n.inner)
+}
+
+func (n *netResolve) Dial(ctx context.Context, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err == nil {
+		host, err = n.resolve(host)
+	}
+	if err != nil {
+		return nil, &net.OpError{Op: "dial", Net: n.Network(), Addr: &strAddr{n.Network(), addr}, Err: err}
+	}
+	return n.inner.Dial(ctx, net.JoinHostPort(host, port))
+}
+
+func (n *netResolve) Listen(ctx context.Context, laddr string) (Listener, error) {
+	return n.inner.Listen(ctx, laddr)
+}
+
+
+// NetBlackhole creates Networker whose Dial never connects.
+//
+// Dial blocks until its context is canceled or the networker is closed, and
+// returns corresponding error. If the context had deadline, returned error
+// has Timeout() == true. Listen succeeds, but returned listener never accepts.
+//
+// It is useful as fixture to test connection-timeout handling.
+func NetBlackhole() Networker {
+	return newNetFixture("blackhole", false)
+}
+
+// NetRefuse creates Networker whose Dial always refuses to connect.
+//
+// Dial returns immediately with error having syscall.ECONNREFUSED cause.
+// Listen succeeds, but returned listener never accepts.
+//
+// It is useful as fixture to test connection-failure handling.
+func NetRefuse() Networker {
+	return newNetFixture("refuse", true)
+}
+
+// netFixture implements NetBlackhole and NetRefuse.
+type netFixture struct {
+	network string
+	refuse  bool // refuse Dial immediately instead of blocking
+
+	ctx    context.Context // cancelled on Close
+	cancel func()
+}
+
+func newNetFixture(network string, refuse bool) *netFixture {
+	n := &netFixture{network: network, refuse: refuse}
+	n.ctx, n.cancel = context.WithCancel(context.Background())
+	return n
+}
+
+func (n *netFixture) Network() string {
+	return n.network
+}
+
+func (n *netFixture) Name() string {
+	return hostname
+}
+
+func (n *netFixture) Close() error {
+	n.cancel()
+	return nil
+}
+
+func (n *netFixture) Closed() bool {
+	return n.ctx.Err() != nil
+}
+
+func (n *netFixture) Dial(ctx context.Context, addr string) (net.Conn, error) {
+	dialErr := func(err error) error {
+		return &net.OpError{Op: "dial", Net: n.network, Addr: &strAddr{n.network, addr}, Err: err}
+	}
+
+	var err error
+	switch {
+	case n.ctx.Err() != nil:
+		err = errNetClosed
+
+	case n.refuse:
+		err = os.NewSyscallError("connect", syscall.ECONNREFUSED)
+
+	default:
+		select {
+		case <-n.ctx.Done():
+			err = errNetClosed
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+
+	return nil, dialErr(err)
+}
+
+func (n *netFixture) Listen(ctx context.Context, laddr string) (Listener, error) {
+	err := ctx.Err()
+	if n.ctx.Err() != nil {
+		err = errNetClosed
+	}
+	if err != nil {
+		return nil, &net.OpError{Op: "listen", Net: n.network, Addr: &strAddr{n.network, laddr}, Err: err}
+	}
+
+	l := &listenerFixture{net: n, laddr: &strAddr{n.network, laddr}}
+	l.ctx, l.cancel = context.WithCancel(n.ctx)
+	return l, nil
+}
+
+// listenerFixture implements Listener for netFixture.
+//
+// It never accepts any connection.
+type listenerFixture struct {
+	net   *netFixture
+	laddr net.Addr
+
+	ctx    context.Context // cancelled on Close
+	cancel func()
+}
+
+func (l *listenerFixture) Close() error {
+	l.cancel()
+	return nil
+}
+
+func (l *listenerFixture) Addr() net.Addr {
+	return l.laddr
+}
+
+func (l *listenerFixture) Accept(ctx context.Context) (net.Conn, error) {
+	var err error
+	select {
+	case <-l.ctx.Done():
+		err = errNetClosed
+		if l.net.ctx.Err() == nil {
+			err = errListenerClosed
+		}
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	return nil, &net.OpError{Op: "accept", Net: l.net.network, Addr: l.laddr, Err: err}
+}
+
+
+// NetFaulty wraps inner networker to inject connect latency and failures.
+//
+// It is probabilistic counterpart of NetBlackhole and NetRefuse: Dial is
+// delayed by opts.Latency and then, with opts.DialFailProb probability,
+// refused with error having syscall.ECONNREFUSED cause. Read and Write on
+// dialed and accepted connections fail, with opts.IOFailProb probability,
+// with error having syscall.ECONNRESET cause.
+//
+// Decisions are made with opts.Rand, which can be seeded for pattern of
+// injected faults to be reproducible.
+func NetFaulty(inner Networker, opts FaultOpts) Networker {
+	if opts.Rand == nil {
+		opts.Rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	return &netFaulty{inner: inner, opts: opts}
+}
+
+// FaultOpts specifies faults injected by NetFaulty.
+type FaultOpts struct {
+	Latency      time.Duration // delay added to every Dial
+	DialFailProb float64       // probability for Dial to be refused
+	IOFailProb   float64       // probability for Read or Write to fail
+
+	Rand *rand.Rand // source of randomness; nil -> seeded from current time
+}
+
+type netFaulty struct {
+	inner Networker
+	opts  FaultOpts
+
+	randMu sync.Mutex // rand.Rand is not safe for concurrent use
+}
+
+// fail returns whether an operation with failure probability prob should fail.
+func (n *netFaulty) fail(prob float64) bool {
+	if prob <= 0 {
+		return false
+	}
+	n.randMu.Lock()
+	defer n.randMu.Unlock()
+	return n.opts.Rand.Float64() < prob
+}
+
+func (n *netFaulty) Network() string {
+	return n.inner.Network()
+}
+
+func (n *netFaulty) Name() string {
+	return n.inner.Name()
+}
+
+func (n *netFaulty) Close() error {
+	return n.inner.Close()
+}
+
+func (n *netFaulty) Closed() bool {
+	return innerClosed(n.inner)
+}
+
+func (n *netFaulty) Dial(ctx context.Context, addr string) (net.Conn, error) {
+	dialErr := func(err error) error {
+		return &net.OpError{Op: "dial", Net: n.Network(), Addr: &strAddr{n.Network(), addr}, Err: err}
+	}
+
+	if n.opts.Latency > 0 {
+		t := time.NewTimer(n.opts.Latency)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, dialErr(ctx.Err())
+		case <-t.C:
+		}
+	}
+
+	if n.fail(n.opts.DialFailProb) {
+		return nil, dialErr(os.NewSyscallError("connect", syscall.ECONNREFUSED))
+	}
+
+	conn, err := n.inner.Dial(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	return &faultyConn{conn, n}, nil
+}
+
+func (n *netFaulty) Listen(ctx context.Context, laddr string) (Listener, error) {
+	l, err := n.inner.Listen(ctx, laddr)
+	if err != nil {
+		return nil, err
+	}
+	return &listenerFaulty{l, n}, nil
+}
+
+// listenerFaulty wraps Listener for NetFaulty to inject faults into accepted connections.
+type listenerFaulty struct {
+	Listener
+	net *netFaulty
+}
+
+func (l *listenerFaulty) Accept(ctx context.Context) (net.Conn, error) {
+	conn, err := l.Listener.Accept(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &faultyConn{conn, l.net}, nil
+}
+
+func (l *listenerFaulty) Unwrap() Listener {
+	return l.Listener
+}
+
+// faultyConn wraps net.Conn for NetFaulty to inject Read and Write errors.
+type faultyConn struct {
+	net.Conn
+	net *netFaulty
+}
+
+func (c *faultyConn) ioErr(op string) error {
+	return &net.OpError{Op: op, Net: c.net.Network(), Source: c.LocalAddr(), Addr: c.RemoteAddr(),
+		Err: os.NewSyscallError(op, syscall.ECONNRESET)}
+}
+
+func (c *faultyConn) Read(p []byte) (int, error) {
+	if c.net.fail(c.net.opts.IOFailProb) {
+		return 0, c.ioErr("read")
+	}
+	return c.Conn.Read(p)
+}
+
+func (c *faultyConn) Write(p []byte) (int, error) {
+	if c.net.fail(c.net.opts.IOFailProb) {
+		return 0, c.ioErr("write")
+	}
+	return c.Conn.Write(p)
+}
+
+func (c *faultyConn) AbortiveClose() error {
+	return AbortiveClose(c.Conn)
+}
+
+func (c *faultyConn) Unwrap() net.Conn {
+	return c.Conn
+}
+
+
+// FilterListener wraps listener l with filter that inspects accepted connections.
+//
+// Accept of returned listener calls filter on every connection accepted by
+// l. If filter returns an error, the connection is closed and Accept
+// continues to wait for the next connection. Only connections for which
+// filter returned nil are returned to the caller.
+func FilterListener(l Listener, filter func(net.Conn) error) Listener {
+	return &listenerFilter{l, filter}
+}
+
+type listenerFilter struct {
+	innerl Listener
+	filter func(net.Conn) error
+}
+
+func (l *listenerFilter) Close() error {
+	return l.innerl.Close()
+}
+
+func (l *listenerFilter) Unwrap() Listener {
+	return l.innerl
+}
+
+func (l *listenerFilter) Addr() net.Addr {
+	return l.innerl.Addr()
+}
+
+func (l *listenerFilter) Accept(ctx context.Context) (net.Conn, error) {
+	for {
+		conn, err := l.innerl.Accept(ctx)
+		if err != nil {
+			return nil, err
+		}
+		err = l.filter(conn)
+		if err != nil {
+			conn.Close() // ignore err
+			continue
+		}
+		return conn, nil
+	}
+}
+
+
+// MaxConnListener wraps listener l to limit number of concurrently accepted connections.
+//
+// Accept of returned listener blocks while there are max connections that
+// were accepted and not yet closed. Closing such connection releases its slot.
+// Blocked Accept is interrupted by ctx cancel and by listener Close.
+//
+// max must be > 0, or else MaxConnListener panics.
+func MaxConnListener(l Listener, max int) Listener {
+	if max <= 0 {
+		panic(fmt.Sprintf("xnet: MaxConnListener: invalid max (%d); must be > 0", max))
+	}
+	return &listenerMaxConn{
+		innerl: l,
+		slots:  make(chan struct{}, max),
+		down:   make(chan struct{}),
+	}
+}
+
+type listenerMaxConn struct {
+	innerl Listener
+	slots  chan struct{} // one entry per accepted and not yet closed connection
+
+	down      chan struct{} // closed on Close
+	closeOnce sync.Once
+}
+
+func (l *listenerMaxConn) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.down)
+	})
+	return l.innerl.Close()
+}
+
+func (l *listenerMaxConn) Unwrap() Listener {
+	return l.innerl
+}
+
+func (l *listenerMaxConn) Addr() net.Addr {
+	return l.innerl.Addr()
+}
+
+func (l *listenerMaxConn) Accept(ctx context.Context) (net.Conn, error) {
+	// wait for free slot
+	var err error
+	select {
+	case l.slots <- struct{}{}:
+		// ok
+	case <-l.down:
+		err = errListenerClosed
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if err != nil {
+		addr := l.Addr()
+		return nil, &net.OpError{Op: "accept", Net: addr.Network(), Addr: addr, Err: err}
+	}
+
 	conn, err := l.innerl.Accept(ctx)
 	if err != nil {
+		<-l.slots
 		return nil, err
 	}
-	return tls.Server(conn, l.net.config), nil
+	return &connMaxConn{Conn: conn, l: l}, nil
+}
+
+// connMaxConn wraps connection accepted via listenerMaxConn to release its slot on Close.
+type connMaxConn struct {
+	net.Conn
+	l           *listenerMaxConn
+	releaseOnce sync.Once
+}
+
+func (c *connMaxConn) Close() error {
+	err := c.Conn.Close()
+	c.release()
+	return err
+}
+
+func (c *connMaxConn) AbortiveClose() error {
+	err := AbortiveClose(c.Conn)
+	c.release()
+	return err
+}
+
+func (c *connMaxConn) Unwrap() net.Conn {
+	return c.Conn
+}
+
+// release releases listener slot occupied by c.
+func (c *connMaxConn) release() {
+	c.releaseOnce.Do(func() {
+		<-c.l.slots
+	})
+}
+
+
+// ErrLifetimeExpired is the error reported by IO on connection created via
+// WithLifetime after its lifetime elapsed.
+var ErrLifetimeExpired = errors.New("connection lifetime expired")
+
+// WithLifetime wraps c into connection that is automatically closed once d
+// elapses from the call.
+//
+// Read and Write on the connection, that fail due to such close, return
+// *net.OpError with Err=ErrLifetimeExpired. It is useful for fault tests.
+func WithLifetime(c net.Conn, d time.Duration) net.Conn {
+	lc := &connLifetime{Conn: c}
+	lc.timer = time.AfterFunc(d, lc.expire)
+	return lc
+}
+
+type connLifetime struct {
+	net.Conn
+	timer   *time.Timer
+	expired int32 // 1 after lifetime elapsed
+}
+
+// expire is called when lifetime of the connection elapses.
+func (c *connLifetime) expire() {
+	atomic.StoreInt32(&c.expired, 1)
+	c.Conn.Close()
+}
+
+func (c *connLifetime) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if err != nil {
+		err = c.errOrExpired("read", err)
+	}
+	return n, err
+}
+
+func (c *connLifetime) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if err != nil {
+		err = c.errOrExpired("write", err)
+	}
+	return n, err
+}
+
+func (c *connLifetime) Close() error {
+	c.timer.Stop()
+	return c.Conn.Close()
+}
+
+func (c *connLifetime) AbortiveClose() error {
+	c.timer.Stop()
+	return AbortiveClose(c.Conn)
+}
+
+func (c *connLifetime) Unwrap() net.Conn {
+	return c.Conn
+}
+
+// errOrExpired returns ErrLifetimeExpired wrapped into net.OpError if the
+// connection was closed due to lifetime expiration, or err as is otherwise.
+func (c *connLifetime) errOrExpired(op string, err error) error {
+	if atomic.LoadInt32(&c.expired) == 0 {
+		return err
+	}
+	return &net.OpError{
+		Op:     op,
+		Net:    c.LocalAddr().Network(),
+		Source: c.LocalAddr(),
+		Addr:   c.RemoteAddr(),
+		Err:    ErrLifetimeExpired,
+	}
+}
+
+
+// WaitListening waits until addr on network n accepts connections.
+//
+// It repeatedly dials addr, with poll interval in between attempts, until dial
+// succeeds. The established connection is immediately closed. It is useful in
+// tests to wait for a server to come up without sleeping for a fixed time.
+//
+// If ctx is canceled before addr is dialed successfully, the returned error
+// has ctx.Err() as cause and the error of the last dial in its text.
+func WaitListening(ctx context.Context, n Networker, addr string, poll time.Duration) error {
+	for {
+		c, err := n.Dial(ctx, addr)
+		if err == nil {
+			return c.Close()
+		}
+
+		select {
+		case <-ctx.Done():
+			return &net.OpError{
+				Op:   "wait-listening",
+				Net:  n.Network(),
+				Addr: &strAddr{n.Network(), addr},
+				Err:  fmt.Errorf("%w (last dial: %s)", ctx.Err(), err),
+			}
+		case <-time.After(poll):
+		}
+	}
+}
+
+
+// DialCancelable runs dial, which does not support cancellation by itself, in
+// a way that can be canceled via ctx.
+//
+// dial is run in its own goroutine. If ctx is canceled before dial completes,
+// DialCancelable returns ctx.Err() right away without waiting for dial. If
+// dial establishes the connection after that, the connection is closed.
+func DialCancelable(ctx context.Context, dial func() (net.Conn, error)) (net.Conn, error) {
+	err := ctx.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	type ret struct { conn net.Conn; err error }
+	doneq := make(chan ret)
+	go func() {
+		conn, err := dial()
+		select {
+		case doneq <- ret{conn, err}:
+			// ok
+		case <-ctx.Done():
+			// canceled - nobody will use the connection
+			if conn != nil {
+				conn.Close() // ignore err
+			}
+		}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+
+	case ret := <-doneq:
+		return ret.conn, ret.err
+	}
+}
+
+// AbortCloser is optional interface that net.Conn can implement to support AbortiveClose.
+//
+// Connections of virtnet-based networks, e.g. pipenet and lonet, implement it.
+type AbortCloser interface {
+	AbortiveClose() error
+}
+
+// ErrNoAbortiveClose is the error returned by AbortiveClose for connections
+// that do not support abortive close.
+var ErrNoAbortiveClose = errors.New("abortive close not supported")
+
+// AbortiveClose closes connection c abortively.
+//
+// Contrary to regular Close, which makes the peer observe EOF, abortive close
+// makes the peer observe connection reset, i.e. syscall.ECONNRESET. For TCP
+// connections this is done via setting SO_LINGER to 0 before close, which
+// results in RST being sent instead of FIN. Connections implementing
+// AbortCloser are closed via their AbortiveClose.
+//
+// Other connections are closed regularly and error with ErrNoAbortiveClose
+// cause is returned.
+func AbortiveClose(c net.Conn) error {
+	switch c := c.(type) {
+	case AbortCloser:
+		return c.AbortiveClose()
+
+	case *net.TCPConn:
+		err := c.SetLinger(0)
+		return xerr.First(err, c.Close())
+	}
+
+	c.Close() // ignore err
+	return &net.OpError{
+		Op:     "close",
+		Net:    c.LocalAddr().Network(),
+		Source: c.LocalAddr(),
+		Addr:   c.RemoteAddr(),
+		Err:    ErrNoAbortiveClose,
+	}
+}
+
+
+// BoundAddr returns address listener l is actually bound to.
+//
+// Listeners that wrap other listeners, e.g. listeners of NetTLS or NetTrace,
+// are unwrapped down to the innermost listener, whose address is returned.
+// For listener created with ":0" on a TCP networker, this is the address
+// with port assigned by OS.
+func BoundAddr(l Listener) net.Addr {
+	return innermostListener(l).Addr()
+}
+
+// innermostListener unwraps l down to the listener that does not wrap any other.
+//
+// Unwrapping goes through both Listener and net.Listener levels.
+func innermostListener(l Listener) interface{ Addr() net.Addr } {
+	var x interface{ Addr() net.Addr } = l
+	for {
+		var inner interface{ Addr() net.Addr }
+		switch x := x.(type) {
+		case listenerWrapper:
+			inner = x.Unwrap()
+		case *listenerCtx:
+			inner = x.rawl
+		case *listenerConfigure:
+			inner = x.Listener
+		}
+		if inner == nil {
+			return x
+		}
+		x = inner
+	}
+}
+
+// listenerWrapper is implemented by listeners of this package that wrap another listener.
+type listenerWrapper interface {
+	Unwrap() Listener
+}
+
+// ConnKey returns key that identifies connection c from its local side.
+//
+// The key has the form "net:laddr->raddr" and can be used e.g. as map key
+// to track connections. For the same connection it is the same on every
+// call, and the key of the peer endpoint is its mirror: "net:raddr->laddr".
+//
+// Connections that wrap other connections, e.g. connections of NetTLS,
+// NetTrace and other wrappers of this package, are unwrapped down to the
+// innermost connection - e.g. TCP connection, or connection of virtnet-based
+// network - whose addresses form the key.
+func ConnKey(c net.Conn) string {
+	c = innermostConn(c)
+	laddr := c.LocalAddr()
+	network := ""
+	if laddr != nil {
+		network = laddr.Network()
+	}
+	return fmt.Sprintf("%s:%v->%v", network, laddr, c.RemoteAddr())
+}
+
+// connWrapper is implemented by connections of this package that wrap another connection.
+type connWrapper interface {
+	Unwrap() net.Conn
+}
+
+// unwrapConn returns connection that c wraps, or nil if c is not a wrapper.
+func unwrapConn(c net.Conn) net.Conn {
+	switch c := c.(type) {
+	case connWrapper:
+		return c.Unwrap()
+	case *tls.Conn:
+		return tlsNetConn(c)
+	}
+	return nil
+}
+
+// innermostConn unwraps c down to the connection that does not wrap any other.
+func innermostConn(c net.Conn) net.Conn {
+	for {
+		inner := unwrapConn(c)
+		if inner == nil {
+			return c
+		}
+		c = inner
+	}
 }
 
 
@@ -296,6 +1413,10 @@ type listenerCtx struct {
 	serveWG     *xsync.WorkGroup // Accept loop is run under serveWG
 	serveCancel func()           // Close calls serveCancel to request Accept loop shutdown
 	acceptq     chan accepted    // Accept results go -> acceptq
+
+	drainOnce sync.Once
+	drainc    chan struct{} // closed by CloseDrain
+	served    chan struct{} // closed when Accept loop exits
 }
 
 // accepted represents Accept result.
@@ -305,7 +1426,12 @@ type accepted struct {
 }
 
 func newListenerCtx(rawl net.Listener) *listenerCtx {
-	l := &listenerCtx{rawl: rawl, acceptq: make(chan accepted)}
+	l := &listenerCtx{
+		rawl:    rawl,
+		acceptq: make(chan accepted),
+		drainc:  make(chan struct{}),
+		served:  make(chan struct{}),
+	}
 	ctx, cancel := context.WithCancel(context.Background())
 	l.serveWG = xsync.NewWorkGroup(ctx)
 	l.serveCancel = cancel
@@ -314,10 +1440,20 @@ func newListenerCtx(rawl net.Listener) *listenerCtx {
 }
 
 func (l *listenerCtx) serve(ctx context.Context) error {
+	defer close(l.served)
 	for {
 		// raw Accept. This should not stuck overliving ctx as Close closes rawl
 		conn, err := l.rawl.Accept()
 
+		// don't report accept error, e.g. due to rawl being closed, when draining
+		if err != nil {
+			select {
+			case <-l.drainc:
+				return nil
+			default:
+			}
+		}
+
 		// send result to Accept, but don't try to send if we are closed
 		ctxErr := ctx.Err()
 		if ctxErr == nil {
@@ -347,6 +1483,26 @@ func (l *listenerCtx) Close() error {
 	return err
 }
 
+func (l *listenerCtx) CloseDrain(ctx context.Context) error {
+	l.drainOnce.Do(func() {
+		close(l.drainc)
+	})
+	err := l.rawl.Close()
+
+	// wait for Accept loop to deliver what it has already accepted
+	select {
+	case <-ctx.Done():
+		if err == nil {
+			err = ctx.Err()
+		}
+	case <-l.served:
+	}
+
+	l.serveCancel()
+	_ = l.serveWG.Wait() // ignore err - it is always "canceled" or nil
+	return err
+}
+
 func (l *listenerCtx) Accept(ctx context.Context) (_ net.Conn, err error) {
 	err = ctx.Err()
 
@@ -0,0 +1,1237 @@
+// Copyright (C) 2026  Nexedi SA and Contributors.
+//                     Kirill Smelkov <kirr@nexedi.com>
+//
+// This program is free software: you can Use, Study, Modify and Redistribute
+// it under the terms of the GNU General Public License version 3, or (at your
+// option) any later version, as published by the Free Software Foundation.
+//
+// You can also Link and Combine this program with other software covered by
+// the terms of any of the Free Software licenses or any of the Open Source
+// Initiative approved licenses and Convey the resulting work. Corresponding
+// source of such a combination shall include the source code for all other
+// software used.
+//
+// This program is distributed WITHOUT ANY WARRANTY; without even the implied
+// warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+//
+// See COPYING file for full licensing terms.
+// See https://www.nexedi.com/licensing for rationale and options.
+
+package xnet
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/big"
+	mrand "math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// dialAccept dials laddr via n and accepts the connection on l.
+//
+// both dialed and accepted connections are closed before return.
+func dialAccept(t *testing.T, n Networker, l Listener) {
+	t.Helper()
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	var errAccept error
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		c, err := l.Accept(ctx)
+		if err != nil {
+			errAccept = err
+			return
+		}
+		c.Close()
+	}()
+
+	c, err := n.Dial(ctx, l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+
+	wg.Wait()
+	if errAccept != nil {
+		t.Fatal(errAccept)
+	}
+}
+
+func TestNetPlainTCPConnHook(t *testing.T) {
+	var mu sync.Mutex
+	ncalls := 0
+	hook := func(c *net.TCPConn) error {
+		mu.Lock()
+		defer mu.Unlock()
+		ncalls++
+		return c.SetNoDelay(true)
+	}
+
+	// tcp: hook is called on both dialed and accepted connections
+	n := NetPlainWithConfig("tcp", NetPlainConfig{TCPConnHook: hook})
+	defer n.Close()
+	l, err := n.Listen(context.Background(), "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dialAccept(t, n, l)
+	l.Close()
+
+	if ncalls != 2 {
+		t.Fatalf("tcp: hook called %d times; want 2", ncalls)
+	}
+
+	// unix: hook is not called
+	ncalls = 0
+	tmpd, err := ioutil.TempDir("", "xnet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpd)
+
+	n = NetPlainWithConfig("unix", NetPlainConfig{TCPConnHook: hook})
+	defer n.Close()
+	l, err = n.Listen(context.Background(), filepath.Join(tmpd, "sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dialAccept(t, n, l)
+	l.Close()
+
+	if ncalls != 0 {
+		t.Fatalf("unix: hook called %d times; want 0", ncalls)
+	}
+}
+
+func TestNetBlackhole(t *testing.T) {
+	n := NetBlackhole()
+	defer n.Close()
+
+	timeout := 50*time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	t0 := time.Now()
+	c, err := n.Dial(ctx, "α:1")
+	δt := time.Since(t0)
+	if c != nil {
+		t.Fatalf("dial: connected")
+	}
+	e, ok := err.(*net.OpError)
+	if !(ok && e.Op == "dial" && e.Timeout()) {
+		t.Fatalf("dial: err = %#v  ; want *net.OpError with Timeout", err)
+	}
+	if δt < timeout {
+		t.Fatalf("dial: returned after %s  ; want ≥ %s", δt, timeout)
+	}
+
+	// Dial after Close does not block
+	n.Close()
+	_, err = n.Dial(context.Background(), "α:1")
+	e, ok = err.(*net.OpError)
+	if !(ok && e.Err == errNetClosed) {
+		t.Fatalf("dial after close: err = %v  ; want %v", err, errNetClosed)
+	}
+}
+
+func TestNetRefuse(t *testing.T) {
+	n := NetRefuse()
+	defer n.Close()
+
+	// Dial refuses synchronously even if ctx has no deadline
+	c, err := n.Dial(context.Background(), "α:1")
+	if c != nil {
+		t.Fatalf("dial: connected")
+	}
+	e, ok := err.(*net.OpError)
+	if !(ok && e.Op == "dial" && errors.Is(err, syscall.ECONNREFUSED)) {
+		t.Fatalf("dial: err = %#v  ; want *net.OpError with ECONNREFUSED", err)
+	}
+
+	// listener never accepts
+	l, err := n.Listen(context.Background(), "α:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = l.Accept(ctx)
+	e, ok = err.(*net.OpError)
+	if !(ok && e.Op == "accept" && e.Err == context.DeadlineExceeded) {
+		t.Fatalf("accept: err = %v  ; want %v", err, context.DeadlineExceeded)
+	}
+	l.Close()
+	_, err = l.Accept(context.Background())
+	e, ok = err.(*net.OpError)
+	if !(ok && e.Err == errListenerClosed) {
+		t.Fatalf("accept after close: err = %v  ; want %v", err, errListenerClosed)
+	}
+}
+
+func TestFilterListener(t *testing.T) {
+	bg := context.Background()
+	n := NetPlain("tcp")
+	defer n.Close()
+
+	nfilter := 0
+	rejected := errors.New("rejected")
+	l0, err := n.Listen(bg, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := FilterListener(l0, func(c net.Conn) error {
+		nfilter++
+		if nfilter == 1 {
+			return rejected
+		}
+		return nil
+	})
+	defer l.Close()
+
+	// first connection is rejected by filter and closed
+	c1, err := n.Dial(bg, l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c1.Close()
+	c2, err := n.Dial(bg, l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c2.Close()
+
+	c, err := l.Accept(bg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if nfilter != 2 {
+		t.Fatalf("filter called %d times; want 2", nfilter)
+	}
+	if c.RemoteAddr().String() != c2.LocalAddr().String() {
+		t.Fatalf("accepted %s  ; want %s", c.RemoteAddr(), c2.LocalAddr())
+	}
+
+	// c1 was closed on server side
+	_, err = c1.Read(make([]byte, 1))
+	if err == nil {
+		t.Fatalf("read from rejected conn: ok  ; want error")
+	}
+}
+
+func TestNetPlainTimeout(t *testing.T) {
+	bg := context.Background()
+	timeout := 50*time.Millisecond
+	n := NetPlainWithConfig("tcp", NetPlainConfig{ReadTimeout: timeout, WriteTimeout: timeout})
+	defer n.Close()
+
+	l, err := n.Listen(bg, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	cc, err := n.Dial(bg, l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Close()
+	cs, err := l.Accept(bg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cs.Close()
+
+	// Read with no data on both dialed and accepted sides times out after default timeout
+	for _, c := range []net.Conn{cc, cs} {
+		t0 := time.Now()
+		_, err = c.Read(make([]byte, 1))
+		δt := time.Since(t0)
+		e, ok := err.(net.Error)
+		if !(ok && e.Timeout()) {
+			t.Fatalf("read: err = %v  ; want timeout", err)
+		}
+		if δt < timeout {
+			t.Fatalf("read: returned after %s  ; want ≥ %s", δt, timeout)
+		}
+	}
+
+	// the timeout is per-operation: IO works after a timed-out read
+	_, err = cc.Write([]byte("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1)
+	_, err = cs.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "a" {
+		t.Fatalf("read: %q  ; want \"a\"", buf)
+	}
+}
+
+// TestNetPlainTimeoutDeadline verifies that deadlines set by user are not
+// overwritten by default timeouts: the earliest of the two is used.
+func TestNetPlainTimeoutDeadline(t *testing.T) {
+	// xread reads from c with no data and verifies it times out in [tmin, tmax).
+	xread := func(c net.Conn, tmin, tmax time.Duration) {
+		t.Helper()
+		t0 := time.Now()
+		_, err := c.Read(make([]byte, 1))
+		δt := time.Since(t0)
+		e, ok := err.(net.Error)
+		if !(ok && e.Timeout()) {
+			t.Fatalf("read: err = %v  ; want timeout", err)
+		}
+		if !(tmin <= δt && δt < tmax) {
+			t.Fatalf("read: returned after %s  ; want in [%s, %s)", δt, tmin, tmax)
+		}
+	}
+
+	for _, timeout := range []time.Duration{50*time.Millisecond, time.Hour} {
+		n := NetPlainWithConfig("tcp", NetPlainConfig{ReadTimeout: timeout})
+		c, cs := tcpPipe(t, n)
+
+		if timeout == time.Hour {
+			// user deadline earlier than default timeout
+			c.SetReadDeadline(time.Now().Add(50*time.Millisecond))
+			xread(c, 50*time.Millisecond, 10*time.Second)
+			c.SetDeadline(time.Now().Add(50*time.Millisecond))
+			xread(c, 50*time.Millisecond, 10*time.Second)
+		} else {
+			// default timeout earlier than user deadline
+			c.SetReadDeadline(time.Now().Add(time.Hour))
+			xread(c, timeout, 10*time.Second)
+
+			// cleared user deadline -> default timeout only
+			c.SetReadDeadline(time.Time{})
+			xread(c, timeout, 10*time.Second)
+		}
+
+		c.Close()
+		cs.Close()
+		n.Close()
+	}
+}
+
+func TestClosed(t *testing.T) {
+	closed := func(n Networker) bool {
+		return n.(ClosedChecker).Closed()
+	}
+
+	plain := NetPlain("tcp")
+	tlsn := NetTLS(plain, &tls.Config{})
+	trace := NetTrace(tlsn, &traceCollect{})
+	for _, n := range []Networker{plain, tlsn, trace} {
+		if closed(n) {
+			t.Fatalf("%s: closed before Close", n.Network())
+		}
+	}
+
+	// closing outermost layer closes everything underneath
+	err := trace.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []Networker{plain, tlsn, trace} {
+		if !closed(n) {
+			t.Errorf("%s: !closed after Close", n.Network())
+		}
+	}
+
+	for _, n := range []Networker{NetBlackhole(), NetRefuse()} {
+		if closed(n) {
+			t.Fatalf("%s: closed before Close", n.Network())
+		}
+		n.Close()
+		if !closed(n) {
+			t.Errorf("%s: !closed after Close", n.Network())
+		}
+	}
+}
+
+func TestMaxConnListener(t *testing.T) {
+	bg := context.Background()
+	n := NetPlain("tcp")
+	defer n.Close()
+
+	l0, err := n.Listen(bg, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := MaxConnListener(l0, 2)
+	defer l.Close()
+
+	// dial 3 connections; the OS queues them in listen backlog
+	for i := 0; i < 3; i++ {
+		c, err := n.Dial(bg, l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+	}
+
+	// up to max is accepted
+	var cv []net.Conn
+	for i := 0; i < 2; i++ {
+		c, err := l.Accept(bg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cv = append(cv, c)
+	}
+
+	// next Accept blocks; it is interrupted by ctx cancel
+	ctx, cancel := context.WithTimeout(bg, 50*time.Millisecond)
+	defer cancel()
+	_, err = l.Accept(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("accept over max: err = %v  ; want deadline exceeded", err)
+	}
+
+	// closing accepted connection lets blocked Accept proceed
+	acceptq := make(chan error)
+	go func() {
+		c, err := l.Accept(bg)
+		if err == nil {
+			c.Close()
+		}
+		acceptq <- err
+	}()
+	select {
+	case err := <-acceptq:
+		t.Fatalf("accept over max: not blocked: err = %v", err)
+	case <-time.After(50*time.Millisecond):
+	}
+
+	cv[0].Close()
+	cv[0].Close() // second Close does not release one more slot
+	err = <-acceptq
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// listener Close interrupts Accept, whether it is already blocked or not
+	c, err := n.Dial(bg, l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c3, err := l.Accept(bg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c3.Close()
+	go func() {
+		_, err := l.Accept(bg)
+		acceptq <- err
+	}()
+	l.Close()
+	err = <-acceptq
+	if !errors.Is(err, errListenerClosed) {
+		t.Fatalf("accept after Close: err = %v  ; want %v", err, errListenerClosed)
+	}
+
+	// max <= 0 is rejected
+	for _, max := range []int{0, -1} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("MaxConnListener(%d): no panic", max)
+				}
+			}()
+			MaxConnListener(l0, max)
+		}()
+	}
+}
+
+func TestWithLifetime(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	c := WithLifetime(c1, 100*time.Millisecond)
+	defer c.Close()
+
+	// IO works before lifetime elapses
+	go func() {
+		buf := make([]byte, 5)
+		c2.Read(buf)
+		c2.Write(buf)
+	}()
+	_, err := c.Write([]byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	_, err = c.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// blocked Read is interrupted when lifetime elapses
+	_, err = c.Read(buf)
+	e, ok := err.(*net.OpError)
+	if !(ok && e.Op == "read" && e.Err == ErrLifetimeExpired) {
+		t.Fatalf("read after lifetime: err = %v  ; want %v", err, ErrLifetimeExpired)
+	}
+
+	// subsequent IO fails with the lifetime error too
+	_, err = c.Write([]byte("world"))
+	if !errors.Is(err, ErrLifetimeExpired) {
+		t.Fatalf("write after lifetime: err = %v  ; want %v", err, ErrLifetimeExpired)
+	}
+}
+
+func TestWaitListening(t *testing.T) {
+	bg := context.Background()
+	n := NetPlain("tcp")
+	defer n.Close()
+
+	// find free address
+	l, err := n.Listen(bg, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	// nothing listens -> timeout
+	ctx, cancel := context.WithTimeout(bg, 50*time.Millisecond)
+	defer cancel()
+	err = WaitListening(ctx, n, addr, 10*time.Millisecond)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("wait not listening: err = %v  ; want deadline exceeded", err)
+	}
+
+	// listener starts after a delay
+	lq := make(chan Listener, 1)
+	go func() {
+		time.Sleep(50*time.Millisecond)
+		l, err := n.Listen(bg, addr)
+		if err != nil {
+			t.Error(err)
+		}
+		lq <- l
+	}()
+	ctx, cancel = context.WithTimeout(bg, 10*time.Second)
+	defer cancel()
+	err = WaitListening(ctx, n, addr, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l = <-lq
+	if l != nil {
+		l.Close()
+	}
+}
+
+func TestNetResolve(t *testing.T) {
+	bg := context.Background()
+	errNoName := errors.New("no such name")
+	n := NetResolve(NetPlain("tcp"), func(name string) (string, error) {
+		if name == "db" {
+			return "127.0.0.1", nil
+		}
+		return "", errNoName
+	})
+	defer n.Close()
+
+	l, err := n.Listen(bg, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	_, port, err := net.SplitHostPort(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// symbolic name resolves to listening address
+	dialAccept(t, n, &listenerAddr{l, &strAddr{"tcp", "db:" + port}})
+
+	// unknown name
+	_, err = n.Dial(bg, "www:80")
+	if !errors.Is(err, errNoName) {
+		t.Fatalf("dial unknown name: err = %v  ; want %v", err, errNoName)
+	}
+	if want := "dial tcp www:80: no such name"; err.Error() != want {
+		t.Fatalf("dial unknown name: err = %q  ; want %q", err, want)
+	}
+}
+
+// listenerAddr is Listener that reports addr as its address.
+type listenerAddr struct {
+	Listener
+	addr net.Addr
+}
+
+func (l *listenerAddr) Addr() net.Addr { return l.addr }
+
+// chanListener is net.Listener that accepts connections sent to connq.
+type chanListener struct {
+	connq  chan net.Conn
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newChanListener() *chanListener {
+	return &chanListener{connq: make(chan net.Conn), closed: make(chan struct{})}
+}
+
+func (l *chanListener) Accept() (net.Conn, error) {
+	select {
+	case <-l.closed:
+		return nil, errListenerClosed
+	case c := <-l.connq:
+		return c, nil
+	}
+}
+
+func (l *chanListener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *chanListener) Addr() net.Addr { return &strAddr{"chan", "chan"} }
+
+func TestCloseDrain(t *testing.T) {
+	bg := context.Background()
+
+	// connection accepted by serve, but not yet by Accept, is delivered
+	rawl := newChanListener()
+	l := WithCtxL(rawl)
+	c1, c1peer := net.Pipe()
+	defer c1peer.Close()
+	rawl.connq <- c1 // serve now holds c1 for Accept
+
+	ctx, cancel := context.WithTimeout(bg, time.Second)
+	defer cancel()
+	drainq := make(chan error)
+	go func() {
+		drainq <- l.(DrainCloser).CloseDrain(ctx)
+	}()
+
+	c, err := l.Accept(bg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c != c1 {
+		t.Fatalf("accept while draining: got %v  ; want %v", c, c1)
+	}
+	c.Close()
+	err = <-drainq
+	if err != nil {
+		t.Fatalf("drain: %v", err)
+	}
+
+	// no new connections are accepted after drain
+	ctx2, cancel2 := context.WithTimeout(bg, 50*time.Millisecond)
+	defer cancel2()
+	_, err = l.Accept(ctx2)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("accept after drain: err = %v  ; want deadline exceeded", err)
+	}
+
+	// connection not picked up by Accept until ctx expires is closed
+	rawl = newChanListener()
+	l = WithCtxL(rawl)
+	c2, c2peer := net.Pipe()
+	defer c2peer.Close()
+	rawl.connq <- c2
+
+	ctx3, cancel3 := context.WithTimeout(bg, 50*time.Millisecond)
+	defer cancel3()
+	err = l.(DrainCloser).CloseDrain(ctx3)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("drain without Accept: err = %v  ; want deadline exceeded", err)
+	}
+	_, err = c2peer.Read(make([]byte, 1))
+	if err != io.EOF {
+		t.Fatalf("drain without Accept: pending conn: read -> %v  ; want EOF", err)
+	}
+
+	// NetPlain listeners support draining too
+	n := NetPlain("tcp")
+	defer n.Close()
+	ln, err := n.Listen(bg, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ln.(DrainCloser).CloseDrain(bg)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAbortiveClose(t *testing.T) {
+	bg := context.Background()
+	n := NetPlain("tcp")
+	defer n.Close()
+	l, err := n.Listen(bg, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// TCP: peer observes connection reset
+	c, err := n.Dial(bg, l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs, err := l.Accept(bg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cs.Close()
+	err = AbortiveClose(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cs.Read(make([]byte, 1))
+	if !errors.Is(err, syscall.ECONNRESET) {
+		t.Fatalf("read after peer abortive close: %v  ; want %v", err, syscall.ECONNRESET)
+	}
+
+	// unsupported connection is closed regularly
+	p1, p2 := net.Pipe()
+	err = AbortiveClose(p1)
+	if !errors.Is(err, ErrNoAbortiveClose) {
+		t.Fatalf("abortive close of net.Pipe: %v  ; want %v", err, ErrNoAbortiveClose)
+	}
+	_, err = p2.Read(make([]byte, 1))
+	if err != io.EOF {
+		t.Fatalf("read after peer close: %v  ; want EOF", err)
+	}
+}
+
+// abortiveCloseTCP verifies that AbortiveClose of wrapper c makes its TCP peer observe connection reset.
+func abortiveCloseTCP(t *testing.T, c, peer net.Conn) {
+	t.Helper()
+	err := AbortiveClose(c)
+	if err != nil {
+		t.Fatalf("abortive close: %v", err)
+	}
+	_, err = peer.Read(make([]byte, 1))
+	if !errors.Is(err, syscall.ECONNRESET) {
+		t.Fatalf("read after peer abortive close: %v  ; want %v", err, syscall.ECONNRESET)
+	}
+}
+
+// tcpPipe returns both ends of a new TCP connection dialed via n.
+func tcpPipe(t *testing.T, n Networker) (c, cs net.Conn) {
+	t.Helper()
+	bg := context.Background()
+	l, err := n.Listen(bg, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	c, err = n.Dial(bg, l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs, err = l.Accept(bg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, cs
+}
+
+func TestMaxConnAbortiveClose(t *testing.T) {
+	bg := context.Background()
+	n := NetPlain("tcp")
+	defer n.Close()
+	l0, err := n.Listen(bg, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := MaxConnListener(l0, 1)
+	defer l.Close()
+
+	dialAccept := func() (c, cs net.Conn) {
+		t.Helper()
+		c, err := n.Dial(bg, l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(bg, time.Second)
+		defer cancel()
+		cs, err = l.Accept(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c, cs
+	}
+
+	c1, cs1 := dialAccept()
+	defer c1.Close()
+	abortiveCloseTCP(t, cs1, c1)
+
+	// the slot is released
+	c2, cs2 := dialAccept()
+	c2.Close()
+	cs2.Close()
+}
+
+func TestLifetimeAbortiveClose(t *testing.T) {
+	n := NetPlain("tcp")
+	defer n.Close()
+	c, cs := tcpPipe(t, n)
+	defer cs.Close()
+	abortiveCloseTCP(t, WithLifetime(c, time.Hour), cs)
+}
+
+func TestDialCancelable(t *testing.T) {
+	bg := context.Background()
+
+	// dial that completes in time
+	c1, c1peer := net.Pipe()
+	defer c1peer.Close()
+	c, err := DialCancelable(bg, func() (net.Conn, error) {
+		return c1, nil
+	})
+	if !(c == c1 && err == nil) {
+		t.Fatalf("dial: %v, %v  ; want %v, nil", c, err, c1)
+	}
+	c.Close()
+
+	// slow dial: cancellation returns promptly; late connection is closed
+	c2, c2peer := net.Pipe()
+	defer c2peer.Close()
+	dialing := make(chan struct{})
+	ctx, cancel := context.WithTimeout(bg, 50*time.Millisecond)
+	defer cancel()
+	t0 := time.Now()
+	c, err = DialCancelable(ctx, func() (net.Conn, error) {
+		<-dialing
+		return c2, nil
+	})
+	if δt := time.Since(t0); δt > time.Second {
+		t.Fatalf("canceled dial took %s", δt)
+	}
+	if !(c == nil && err == context.DeadlineExceeded) {
+		t.Fatalf("canceled dial: %v, %v  ; want nil, %v", c, err, context.DeadlineExceeded)
+	}
+
+	close(dialing)
+	_, err = c2peer.Read(make([]byte, 1))
+	if err != io.EOF {
+		t.Fatalf("late connection: peer read -> %v  ; want EOF", err)
+	}
+
+	// already canceled ctx -> dial is not called
+	_, err = DialCancelable(ctx, func() (net.Conn, error) {
+		t.Fatal("dial called with canceled ctx")
+		return nil, nil
+	})
+	if err != context.DeadlineExceeded {
+		t.Fatalf("dial with canceled ctx: %v  ; want %v", err, context.DeadlineExceeded)
+	}
+}
+
+// selfSignedCert generates self-signed certificate with common name cn.
+func selfSignedCert(t *testing.T, cn string) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestNetTLSVerifyPeer(t *testing.T) {
+	bg := context.Background()
+	srvCert := selfSignedCert(t, "server")
+	cliCert := selfSignedCert(t, "client")
+
+	errBadPeer := errors.New("bad peer")
+	var peerCN []string
+	plain := NetPlain("tcp")
+	defer plain.Close()
+	srv := NetTLSWithConfig(plain, &tls.Config{
+		Certificates: []tls.Certificate{srvCert},
+		ClientAuth:   tls.RequireAnyClientCert,
+	}, NetTLSConfig{
+		VerifyPeer: func(state tls.ConnectionState) error {
+			for _, cert := range state.PeerCertificates {
+				peerCN = append(peerCN, cert.Subject.CommonName)
+			}
+			if len(peerCN) == 1 && peerCN[0] == "client" {
+				return nil
+			}
+			return errBadPeer
+		},
+	})
+	cli := NetTLS(plain, &tls.Config{
+		Certificates:       []tls.Certificate{cliCert},
+		InsecureSkipVerify: true,
+	})
+
+	l, err := srv.Listen(bg, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// dialHandshake dials l via n and performs TLS handshake in background.
+	dialHandshake := func(n Networker) (net.Conn, chan error) {
+		c, err := n.Dial(bg, l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		errq := make(chan error, 1)
+		go func() {
+			errq <- c.(*tls.Conn).Handshake()
+		}()
+		return c, errq
+	}
+
+	// client presents expected certificate -> accepted
+	c, errq := dialHandshake(cli)
+	cs, err := l.Accept(bg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errq; err != nil {
+		t.Fatal(err)
+	}
+	if len(peerCN) != 1 || peerCN[0] != "client" {
+		t.Fatalf("VerifyPeer: peer chain: %q  ; want [client]", peerCN)
+	}
+	cs.Close()
+	c.Close()
+
+	// VerifyPeer rejects -> Accept fails and connection is closed
+	peerCN = nil
+	bad := NetTLS(plain, &tls.Config{
+		Certificates:       []tls.Certificate{selfSignedCert(t, "mallory")},
+		InsecureSkipVerify: true,
+	})
+	c, errq = dialHandshake(bad)
+	defer c.Close()
+	_, err = l.Accept(bg)
+	if !errors.Is(err, errBadPeer) {
+		t.Fatalf("accept rejected peer: %v  ; want %v", err, errBadPeer)
+	}
+	<-errq // handshake might succeed or fail depending on TLS version
+	_, err = c.Read(make([]byte, 1))
+	if err == nil {
+		t.Fatal("read from rejected connection: no error")
+	}
+}
+
+// TestNetTLSVerifyPeerSlowClient verifies that with VerifyPeer a client that
+// does not perform TLS handshake does not block accepting other clients.
+func TestNetTLSVerifyPeerSlowClient(t *testing.T) {
+	bg := context.Background()
+	plain := NetPlain("tcp")
+	defer plain.Close()
+	srv := NetTLSWithConfig(plain, &tls.Config{
+		Certificates: []tls.Certificate{selfSignedCert(t, "server")},
+	}, NetTLSConfig{
+		VerifyPeer: func(tls.ConnectionState) error { return nil },
+	})
+	cli := NetTLS(plain, &tls.Config{InsecureSkipVerify: true})
+
+	l, err := srv.Listen(bg, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// slow client connects but never starts the handshake
+	slow, err := plain.Dial(bg, l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slow.Close()
+
+	// good client is accepted regardless of the slow one
+	c, err := cli.Dial(bg, l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	errq := make(chan error, 1)
+	go func() {
+		errq <- c.(*tls.Conn).Handshake()
+	}()
+
+	ctx, cancel := context.WithTimeout(bg, 5*time.Second)
+	defer cancel()
+	cs, err := l.Accept(ctx)
+	if err != nil {
+		t.Fatalf("accept: %v", err)
+	}
+	defer cs.Close()
+	if err := <-errq; err != nil {
+		t.Fatal(err)
+	}
+	if cs.RemoteAddr().String() != c.LocalAddr().String() {
+		t.Fatalf("accepted %s  ; want %s", cs.RemoteAddr(), c.LocalAddr())
+	}
+
+	// Close interrupts pending handshake of the slow client and Accept
+	err = l.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = l.Accept(bg)
+	if !errors.Is(err, errListenerClosed) {
+		t.Fatalf("accept after close: %v  ; want %v", err, errListenerClosed)
+	}
+}
+
+func TestNetFaulty(t *testing.T) {
+	bg := context.Background()
+	inner := NetPlain("tcp")
+	defer inner.Close()
+	l, err := inner.Listen(bg, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	addr := l.Addr().String()
+
+	// dialPattern dials addr several times via NetFaulty with seeded rand
+	// and returns pattern of dial successes (+) and failures (-).
+	dialPattern := func(seed int64) string {
+		n := NetFaulty(inner, FaultOpts{DialFailProb: 0.5, Rand: mrand.New(mrand.NewSource(seed))})
+		pattern := ""
+		for i := 0; i < 16; i++ {
+			c, err := n.Dial(bg, addr)
+			switch {
+			case err == nil:
+				pattern += "+"
+				c.Close()
+			case errors.Is(err, syscall.ECONNREFUSED):
+				pattern += "-"
+			default:
+				t.Fatal(err)
+			}
+		}
+		return pattern
+	}
+
+	// expected pattern follows the random sequence
+	rnd := mrand.New(mrand.NewSource(1))
+	ok := ""
+	for i := 0; i < 16; i++ {
+		if rnd.Float64() < 0.5 {
+			ok += "-"
+		} else {
+			ok += "+"
+		}
+	}
+	for i := 0; i < 2; i++ {
+		pattern := dialPattern(1)
+		if pattern != ok {
+			t.Fatalf("dial pattern #%d: %s  ; want %s", i, pattern, ok)
+		}
+	}
+
+	// latency; canceled while waiting
+	latency := 50*time.Millisecond
+	n := NetFaulty(inner, FaultOpts{Latency: latency, Rand: mrand.New(mrand.NewSource(1))})
+	t0 := time.Now()
+	c, err := n.Dial(bg, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	if δt := time.Since(t0); δt < latency {
+		t.Fatalf("dial: returned after %s  ; want ≥ %s", δt, latency)
+	}
+	ctx, cancel := context.WithTimeout(bg, 10*time.Millisecond)
+	defer cancel()
+	_, err = n.Dial(ctx, addr)
+	e, isOpErr := err.(*net.OpError)
+	if !(isOpErr && e.Op == "dial" && e.Err == context.DeadlineExceeded) {
+		t.Fatalf("dial with deadline: err = %#v  ; want *net.OpError with %v", err, context.DeadlineExceeded)
+	}
+
+	// IO errors on dialed and accepted connections
+	n = NetFaulty(inner, FaultOpts{IOFailProb: 1, Rand: mrand.New(mrand.NewSource(1))})
+	lf, err := n.Listen(bg, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lf.Close()
+	c, err = n.Dial(bg, lf.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	cs, err := lf.Accept(bg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cs.Close()
+	_, err = c.Write([]byte("hello"))
+	if !errors.Is(err, syscall.ECONNRESET) {
+		t.Fatalf("write: %v  ; want %v", err, syscall.ECONNRESET)
+	}
+	_, err = cs.Read(make([]byte, 1))
+	if !errors.Is(err, syscall.ECONNRESET) {
+		t.Fatalf("read: %v  ; want %v", err, syscall.ECONNRESET)
+	}
+}
+
+func TestConnKey(t *testing.T) {
+	bg := context.Background()
+	n := NetPlain("tcp")
+	defer n.Close()
+	l, err := n.Listen(bg, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// dial connects via n and returns both ends of the connection.
+	dial := func(n Networker) (c, cs net.Conn) {
+		t.Helper()
+		c, err := n.Dial(bg, l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		cs, err = l.Accept(bg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c, cs
+	}
+
+	c1, cs1 := dial(n)
+	defer c1.Close()
+	defer cs1.Close()
+
+	key1 := ConnKey(c1)
+	ok := "tcp:" + c1.LocalAddr().String() + "->" + l.Addr().String()
+	if key1 != ok {
+		t.Fatalf("key: %q  ; want %q", key1, ok)
+	}
+	if k := ConnKey(c1); k != key1 {
+		t.Fatalf("key not stable: %q  ; was %q", k, key1)
+	}
+	okPeer := "tcp:" + l.Addr().String() + "->" + c1.LocalAddr().String()
+	if k := ConnKey(cs1); k != okPeer {
+		t.Fatalf("peer key: %q  ; want %q", k, okPeer)
+	}
+
+	// wrapped connections report the same key
+	c2, cs2 := dial(NetTrace(n, &traceCollect{}))
+	defer c2.Close()
+	defer cs2.Close()
+	key2 := ConnKey(c2)
+	if k := ConnKey(c2.(*traceConn).Conn); k != key2 {
+		t.Fatalf("wrapped key: %q  ; underlying key: %q", key2, k)
+	}
+
+	// keys of different connections are different
+	if key1 == key2 {
+		t.Fatalf("key of different connections is the same: %q", key1)
+	}
+}
+
+func TestBoundAddr(t *testing.T) {
+	bg := context.Background()
+	cert := selfSignedCert(t, "server")
+	n := NetTLS(NetTrace(NetPlain("tcp"), &traceCollect{}), &tls.Config{Certificates: []tls.Certificate{cert}})
+	defer n.Close()
+
+	l, err := n.Listen(bg, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	addr := BoundAddr(l)
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !(ok && tcpAddr.Port != 0) {
+		t.Fatalf("bound addr: %#v  ; want *net.TCPAddr with assigned port", addr)
+	}
+	if laddr := l.Addr(); laddr.String() != addr.String() {
+		t.Fatalf("addr: %s  ; bound addr: %s", laddr, addr)
+	}
+}
+
+// TestBoundAddrWrappers verifies that BoundAddr unwraps every listener wrapper
+// of this package down to the OS listener.
+func TestBoundAddrWrappers(t *testing.T) {
+	bg := context.Background()
+	cert := selfSignedCert(t, "server")
+	plain := NetPlain("tcp")
+	defer plain.Close()
+
+	xlisten := func(n Networker) Listener {
+		t.Helper()
+		l, err := n.Listen(bg, "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return l
+	}
+
+	configured := NetPlainWithConfig("tcp", NetPlainConfig{ReadTimeout: time.Hour})
+	defer configured.Close()
+	inspect, _ := NetInspect(plain)
+	capture, _ := NetCapture(plain, ioutil.Discard)
+	testv := []struct {
+		name   string
+		listen func() Listener
+	}{
+		{"plain",     func() Listener { return xlisten(plain) }},
+		{"configure", func() Listener { return xlisten(configured) }},
+		{"tls",       func() Listener {
+			return xlisten(NetTLS(plain, &tls.Config{Certificates: []tls.Certificate{cert}}))
+		}},
+		{"trace",     func() Listener { return xlisten(NetTrace(plain, &traceCollect{})) }},
+		{"faulty",    func() Listener { return xlisten(NetFaulty(plain, FaultOpts{})) }},
+		{"capture",   func() Listener { return xlisten(capture) }},
+		{"inspect",   func() Listener { return xlisten(inspect) }},
+		{"filter",    func() Listener {
+			return FilterListener(xlisten(plain), func(net.Conn) error { return nil })
+		}},
+		{"maxconn",   func() Listener { return MaxConnListener(xlisten(plain), 1) }},
+	}
+
+	for _, tt := range testv {
+		l := tt.listen()
+		raw, ok := innermostListener(l).(*net.TCPListener)
+		if !ok {
+			t.Errorf("%s: innermost: %T  ; want *net.TCPListener", tt.name, innermostListener(l))
+		} else if addr := BoundAddr(l); addr != raw.Addr() {
+			t.Errorf("%s: bound addr: %s  ; want %s", tt.name, addr, raw.Addr())
+		}
+		l.Close()
+	}
+}
@@ -44,8 +44,11 @@ package pipenet
 import (
 	"context"
 	"fmt"
+	"io"
 	"net"
 	"sync"
+	"sync/atomic"
+	"syscall"
 
 	"github.com/pkg/errors"
 
@@ -165,7 +168,7 @@ func (v *vengine) VNetNewHost(ctx context.Context, hostname string, registry vir
 //
 // Simply create pipe pair and send one end directly to virtnet acceptor.
 func (v *vengine) VNetDial(ctx context.Context, src, dst *virtnet.Addr, _ string) (_ net.Conn, addrAccept *virtnet.Addr, _ error) {
-	pc, ps := net.Pipe()
+	pc, ps := pipe()
 	accept, err := v.network.vnotify.VNetAccept(ctx, src, dst, ps)
 	if err != nil {
 		pc.Close()
@@ -177,6 +180,48 @@ func (v *vengine) VNetDial(ctx context.Context, src, dst *virtnet.Addr, _ string
 	return pc, accept.Addr, nil
 }
 
+// pipeConn is one end of net.Pipe that supports abortive close.
+type pipeConn struct {
+	net.Conn
+	peer    *pipeConn
+	aborted uint32 // 1 after AbortiveClose
+}
+
+// pipe creates pipe pair with ends supporting abortive close.
+func pipe() (*pipeConn, *pipeConn) {
+	c1, c2 := net.Pipe()
+	p1 := &pipeConn{Conn: c1}
+	p2 := &pipeConn{Conn: c2}
+	p1.peer, p2.peer = p2, p1
+	return p1, p2
+}
+
+// AbortiveClose implements xnet.AbortCloser .
+//
+// The peer observes syscall.ECONNRESET on its reads and writes after that.
+func (c *pipeConn) AbortiveClose() error {
+	atomic.StoreUint32(&c.aborted, 1)
+	return c.Conn.Close()
+}
+
+func (c *pipeConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	return n, c.errOrReset(err)
+}
+
+func (c *pipeConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	return n, c.errOrReset(err)
+}
+
+// errOrReset returns ECONNRESET instead of err if IO failed due to abortive close of the peer.
+func (c *pipeConn) errOrReset(err error) error {
+	if (err == io.EOF || err == io.ErrClosedPipe) && atomic.LoadUint32(&c.peer.aborted) != 0 {
+		err = syscall.ECONNRESET
+	}
+	return err
+}
+
 // Close implements virtnet.Engine .
 func (v *vengine) Close() error {
 	return nil // nop: there is no underlying resources to release.
@@ -203,6 +248,25 @@ func (r *ramRegistry) Announce(ctx context.Context, hostname, hostdata string) (
 	return nil
 }
 
+// Reannounce implements virtnet.Reannouncer .
+func (r *ramRegistry) Reannounce(ctx context.Context, hostname, hostdata string) (err error) {
+	defer r.regerr(&err, "reannounce", hostname, hostdata)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return virtnet.ErrRegistryDown
+	}
+
+	if _, ok := r.hostTab[hostname]; !ok {
+		return virtnet.ErrNoHost
+	}
+
+	r.hostTab[hostname] = hostdata
+	return nil
+}
+
 // Query implements virtnet.Registry .
 func (r *ramRegistry) Query(ctx context.Context, hostname string) (hostdata string, err error) {
 	defer r.regerr(&err, "query", hostname)
@@ -222,6 +286,24 @@ func (r *ramRegistry) Query(ctx context.Context, hostname string) (hostdata stri
 	return hostdata, nil
 }
 
+// List implements virtnet.Lister .
+func (r *ramRegistry) List(ctx context.Context) (_ map[string]string, err error) {
+	defer r.regerr(&err, "list")
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return nil, virtnet.ErrRegistryDown
+	}
+
+	hostTab := make(map[string]string, len(r.hostTab))
+	for hostname, hostdata := range r.hostTab {
+		hostTab[hostname] = hostdata
+	}
+	return hostTab, nil
+}
+
 // Close implements virtnet.Registry .
 func (r *ramRegistry) Close() error {
 	r.mu.Lock()
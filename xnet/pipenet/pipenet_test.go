@@ -30,6 +30,10 @@ func TestPipeNet(t *testing.T) {
 	virtnettest.TestBasic(t, New("t").vnet)
 }
 
+func TestPipeNetAbortiveClose(t *testing.T) {
+	virtnettest.TestAbortiveClose(t, New("t").vnet)
+}
+
 // pipenet has a bit different API than virtnet: Host has no error and returns
 // same instance if called twice, not dup, etc. Test it.
 func TestPipeNet2(t *testing.T) {
@@ -0,0 +1,36 @@
+// Copyright (C) 2026  Nexedi SA and Contributors.
+//                     Kirill Smelkov <kirr@nexedi.com>
+//
+// This program is free software: you can Use, Study, Modify and Redistribute
+// it under the terms of the GNU General Public License version 3, or (at your
+// option) any later version, as published by the Free Software Foundation.
+//
+// You can also Link and Combine this program with other software covered by
+// the terms of any of the Free Software licenses or any of the Open Source
+// Initiative approved licenses and Convey the resulting work. Corresponding
+// source of such a combination shall include the source code for all other
+// software used.
+//
+// This program is distributed WITHOUT ANY WARRANTY; without even the implied
+// warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+//
+// See COPYING file for full licensing terms.
+// See https://www.nexedi.com/licensing for rationale and options.
+
+//go:build !go1.18
+// +build !go1.18
+
+package xnet
+
+import (
+	"crypto/tls"
+	"net"
+)
+
+// tlsNetConn returns connection that TLS connection c wraps.
+//
+// Before Go1.18 tls.Conn does not provide access to the connection it wraps.
+// Its addresses are anyway those of the wrapped connection.
+func tlsNetConn(c *tls.Conn) net.Conn {
+	return nil
+}
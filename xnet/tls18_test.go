@@ -0,0 +1,77 @@
+// Copyright (C) 2026  Nexedi SA and Contributors.
+//                     Kirill Smelkov <kirr@nexedi.com>
+//
+// This program is free software: you can Use, Study, Modify and Redistribute
+// it under the terms of the GNU General Public License version 3, or (at your
+// option) any later version, as published by the Free Software Foundation.
+//
+// You can also Link and Combine this program with other software covered by
+// the terms of any of the Free Software licenses or any of the Open Source
+// Initiative approved licenses and Convey the resulting work. Corresponding
+// source of such a combination shall include the source code for all other
+// software used.
+//
+// This program is distributed WITHOUT ANY WARRANTY; without even the implied
+// warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+//
+// See COPYING file for full licensing terms.
+// See https://www.nexedi.com/licensing for rationale and options.
+
+//go:build go1.18
+// +build go1.18
+
+package xnet
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"testing"
+)
+
+// TestConnKeyTLS verifies that ConnKey unwraps TLS and trace connections down
+// to the underlying TCP connection.
+func TestConnKeyTLS(t *testing.T) {
+	bg := context.Background()
+	cert := selfSignedCert(t, "server")
+	plain := NetPlain("tcp")
+	defer plain.Close()
+	trace := NetTrace(plain, &traceCollect{})
+	srv := NetTLS(trace, &tls.Config{Certificates: []tls.Certificate{cert}})
+	cli := NetTLS(trace, &tls.Config{InsecureSkipVerify: true})
+
+	l, err := srv.Listen(bg, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	c, err := cli.Dial(bg, l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	cs, err := l.Accept(bg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cs.Close()
+
+	// key is formed from addresses of innermost TCP connection
+	for _, c := range []net.Conn{c, cs} {
+		tc, ok := innermostConn(c).(*net.TCPConn)
+		if !ok {
+			t.Fatalf("%T: innermost: %T  ; want *net.TCPConn", c, innermostConn(c))
+		}
+		want := "tcp:" + tc.LocalAddr().String() + "->" + tc.RemoteAddr().String()
+		if key := ConnKey(c); key != want {
+			t.Fatalf("key: %q  ; want %q", key, want)
+		}
+	}
+
+	// keys of both ends mirror each other
+	key := ConnKey(c)
+	want := "tcp:" + l.Addr().String() + "->" + c.LocalAddr().String()
+	if k := ConnKey(cs); k != want {
+		t.Fatalf("peer key: %q  ; want %q  (key: %q)", k, want, key)
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright (C) 2026  Nexedi SA and Contributors.
+//                     Kirill Smelkov <kirr@nexedi.com>
+//
+// This program is free software: you can Use, Study, Modify and Redistribute
+// it under the terms of the GNU General Public License version 3, or (at your
+// option) any later version, as published by the Free Software Foundation.
+//
+// You can also Link and Combine this program with other software covered by
+// the terms of any of the Free Software licenses or any of the Open Source
+// Initiative approved licenses and Convey the resulting work. Corresponding
+// source of such a combination shall include the source code for all other
+// software used.
+//
+// This program is distributed WITHOUT ANY WARRANTY; without even the implied
+// warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+//
+// See COPYING file for full licensing terms.
+// See https://www.nexedi.com/licensing for rationale and options.
+
+package xnet_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"testing"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+
+	"lab.nexedi.com/kirr/go123/xnet"
+	"lab.nexedi.com/kirr/go123/xnet/pipenet"
+)
+
+// selfSignedPEM generates self-signed certificate for name and returns it
+// together with its private key in PEM form.
+func selfSignedPEM(t *testing.T, name string) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		DNSNames:              []string{name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM
+}
+
+func TestTLSConfigFromPEM(t *testing.T) {
+	bg := context.Background()
+	srvCert, srvKey := selfSignedPEM(t, "server")
+	cliCert, cliKey := selfSignedPEM(t, "client")
+
+	// mutual TLS: every side trusts certificate of the other
+	srvConfig, err := xnet.TLSConfigFromPEM(srvCert, srvKey, cliCert, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cliConfig, err := xnet.TLSConfigFromPEM(cliCert, cliKey, srvCert, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cliConfig.ServerName = "server"
+
+	net := pipenet.New("t")
+	hα := net.Host("α")
+	hβ := net.Host("β")
+	defer hα.Close()
+	defer hβ.Close()
+
+	l, err := xnet.NetTLS(hβ, srvConfig).Listen(bg, ":1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	wg := &errgroup.Group{}
+	wg.Go(func() error {
+		c, err := l.Accept(bg)
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+		tc := c.(*tls.Conn)
+		err = tc.Handshake()
+		if err != nil {
+			return err
+		}
+		peer := tc.ConnectionState().PeerCertificates[0].Subject.CommonName
+		if peer != "client" {
+			t.Errorf("server: peer %q  ; want \"client\"", peer)
+		}
+		_, err = c.Write([]byte("hello"))
+		if err != nil {
+			return err
+		}
+		// wait for client to close; pipenet is synchronous and closing
+		// TLS connection writes to it
+		_, err = io.Copy(ioutil.Discard, c)
+		return err
+	})
+
+	c, err := xnet.NetTLS(hα, cliConfig).Dial(bg, "β:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(c, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "hello" {
+		t.Fatalf("client: read %q  ; want \"hello\"", buf)
+	}
+	peer := c.(*tls.Conn).ConnectionState().PeerCertificates[0].Subject.CommonName
+	if peer != "server" {
+		t.Fatalf("client: peer %q  ; want \"server\"", peer)
+	}
+	err = c.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = wg.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// invalid input
+	_, err = xnet.TLSConfigFromPEM(srvCert, cliKey, nil, true)
+	if err == nil {
+		t.Fatal("certificate with mismatched key: no error")
+	}
+	_, err = xnet.TLSConfigFromPEM(nil, nil, nil, true)
+	if err == nil {
+		t.Fatal("server without certificate: no error")
+	}
+	_, err = xnet.TLSConfigFromPEM(nil, nil, []byte("garbage"), false)
+	if err == nil {
+		t.Fatal("invalid CA: no error")
+	}
+}
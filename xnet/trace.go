@@ -41,9 +41,20 @@ import (
 // 2. Dial only (no Accept)
 //   - for similar reasons.
 //
+// See NetTraceRx for tracer that also traces Rx.
+//
 // WARNING NetTrace functionality is currently very draft.
 func NetTrace(inner Networker, tracerx TraceReceiver) *Tracer {
-	return &Tracer{inner, tracerx, 1}
+	return &Tracer{inner, tracerx, nil, 1}
+}
+
+// NetTraceRx is like NetTrace but additionally traces Rx.
+//
+// TraceNetRx is delivered right after every Read that returned data.
+// Note that with both Tx and Rx traced, every transmission on e.g. pipenet is
+// reported twice - once by sender and once by receiver.
+func NetTraceRx(inner Networker, tracerx TraceRxReceiver) *Tracer {
+	return &Tracer{inner, tracerx, tracerx, 1}
 }
 
 // TraceReceiver is the interface that needs to be implemented by network trace receivers.
@@ -55,6 +66,13 @@ type TraceReceiver interface {
 	// XXX +TraceNetClose?
 }
 
+// TraceRxReceiver is the interface that needs to be implemented by network
+// trace receivers that also want to receive Rx events.
+type TraceRxReceiver interface {
+	TraceReceiver
+	TraceNetRx(*TraceRx)
+}
+
 // TraceDial is event corresponding to network dial start.
 type TraceDial struct {
 	// XXX also put networker?
@@ -81,13 +99,20 @@ type TraceTx struct {
 	Pkt      []byte
 }
 
+// TraceRx is event corresponding to network reception.
+type TraceRx struct {
+	Src, Dst net.Addr // Src is the peer we received data from
+	Pkt      []byte
+}
+
 // Tracer wraps underlying Networker to emit events on networking operations.
 //
 // Create it via NetTrace.
 type Tracer struct {
 	inner Networker
 	rx    TraceReceiver
-	on    int32 // atomic (tracing can be enabled/disabled at runtime)
+	rxrx  TraceRxReceiver // !nil if Rx is also traced
+	on    int32           // atomic (tracing can be enabled/disabled at runtime)
 }
 
 // TraceOn tells the tracer to (re)enable delivery of trace events.
@@ -120,6 +145,11 @@ func (t *Tracer) Close() error {
 	return t.inner.Close()
 }
 
+// Closed implements ClosedChecker.
+func (t *Tracer) Closed() bool {
+	return innerClosed(t.inner)
+}
+
 // Dial implements Networker.
 func (t *Tracer) Dial(ctx context.Context, addr string) (net.Conn, error) {
 	if t.enabled() {
@@ -162,7 +192,11 @@ func (ntl *netTraceListener) Accept(ctx context.Context) (net.Conn, error) {
 	return &traceConn{ntl.t, c}, nil
 }
 
-// traceConn wraps net.Conn and notifies tracer on Writes.
+func (ntl *netTraceListener) Unwrap() Listener {
+	return ntl.Listener
+}
+
+// traceConn wraps net.Conn and notifies tracer on Writes and, if requested, Reads.
 type traceConn struct {
 	t        *Tracer
 	net.Conn
@@ -178,3 +212,21 @@ func (tc *traceConn) Write(b []byte) (int, error) {
 	}
 	return n, err
 }
+
+func (tc *traceConn) AbortiveClose() error {
+	return AbortiveClose(tc.Conn)
+}
+
+func (tc *traceConn) Unwrap() net.Conn {
+	return tc.Conn
+}
+
+func (tc *traceConn) Read(b []byte) (int, error) {
+	n, err := tc.Conn.Read(b)
+	if n > 0 && tc.t.rxrx != nil {
+		if tc.t.enabled() {
+			tc.t.rxrx.TraceNetRx(&TraceRx{Src: tc.RemoteAddr(), Dst: tc.LocalAddr(), Pkt: b[:n]})
+		}
+	}
+	return n, err
+}
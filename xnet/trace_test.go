@@ -0,0 +1,125 @@
+// Copyright (C) 2026  Nexedi SA and Contributors.
+//                     Kirill Smelkov <kirr@nexedi.com>
+//
+// This program is free software: you can Use, Study, Modify and Redistribute
+// it under the terms of the GNU General Public License version 3, or (at your
+// option) any later version, as published by the Free Software Foundation.
+//
+// You can also Link and Combine this program with other software covered by
+// the terms of any of the Free Software licenses or any of the Open Source
+// Initiative approved licenses and Convey the resulting work. Corresponding
+// source of such a combination shall include the source code for all other
+// software used.
+//
+// This program is distributed WITHOUT ANY WARRANTY; without even the implied
+// warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+//
+// See COPYING file for full licensing terms.
+// See https://www.nexedi.com/licensing for rationale and options.
+
+package xnet
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+)
+
+// traceCollect is TraceRxReceiver that collects Tx and Rx data.
+type traceCollect struct {
+	mu     sync.Mutex
+	tx, rx []byte
+}
+
+func (tc *traceCollect) TraceNetDial(*TraceDial)       {}
+func (tc *traceCollect) TraceNetConnect(*TraceConnect) {}
+func (tc *traceCollect) TraceNetListen(*TraceListen)   {}
+
+func (tc *traceCollect) TraceNetTx(ev *TraceTx) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.tx = append(tc.tx, ev.Pkt...)
+}
+
+func (tc *traceCollect) TraceNetRx(ev *TraceRx) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.rx = append(tc.rx, ev.Pkt...)
+}
+
+// exchange writes data over c1 and reads it back from c2.
+func exchange(t *testing.T, c1, c2 io.ReadWriter, data string) {
+	t.Helper()
+	errc := make(chan error, 1)
+	go func() {
+		_, err := c1.Write([]byte(data))
+		errc <- err
+	}()
+	buf := make([]byte, len(data))
+	_, err := io.ReadFull(c2, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != data {
+		t.Fatalf("exchange: got %q  ; want %q", buf, data)
+	}
+}
+
+func TestNetTraceRx(t *testing.T) {
+	ctx := context.Background()
+
+	for _, withRx := range []bool{false, true} {
+		tc := &traceCollect{}
+		var tr *Tracer
+		if withRx {
+			tr = NetTraceRx(NetPlain("tcp"), tc)
+		} else {
+			tr = NetTrace(NetPlain("tcp"), tc)
+		}
+
+		l, err := tr.Listen(ctx, "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		cc, err := tr.Dial(ctx, l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		cs, err := l.Accept(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		exchange(t, cc, cs, "hello")
+		exchange(t, cs, cc, "world")
+
+		// Rx is traced only if requested
+		tc.mu.Lock()
+		tx, rx := string(tc.tx), string(tc.rx)
+		tc.mu.Unlock()
+		rxOK := ""
+		if withRx {
+			rxOK = "helloworld"
+		}
+		if !(tx == "helloworld" && rx == rxOK) {
+			t.Errorf("withRx=%v: tx=%q rx=%q  ; want tx=%q rx=%q", withRx, tx, rx, "helloworld", rxOK)
+		}
+
+		// no events when tracing is off
+		tr.TraceOff()
+		exchange(t, cc, cs, "abc")
+		tc.mu.Lock()
+		if len(tc.tx) != 10 || len(tc.rx) != len(rxOK) {
+			t.Errorf("withRx=%v: events delivered with tracing off: tx=%q rx=%q", withRx, tc.tx, tc.rx)
+		}
+		tc.mu.Unlock()
+
+		cc.Close()
+		cs.Close()
+		l.Close()
+	}
+}
@@ -19,6 +19,30 @@
 
 package virtnet
 
+import (
+	"lab.nexedi.com/kirr/go123/xnet"
+)
+
 func SubnetShutdown(n *SubNetwork, err error) {
 	n.shutdown(err)
 }
+
+// ListenerBacklogLen returns number of pending dials in backlog of listener l.
+func ListenerBacklogLen(l xnet.Listener) int {
+	return len(l.(*listener).backlog)
+}
+
+// SetMaxPort sets maximum port autobind of host h picks with DelayedReuse
+// before starting to reuse freed ports.
+func SetMaxPort(h *Host, port int) {
+	h.sockMu.Lock()
+	defer h.sockMu.Unlock()
+	h.maxPort = port
+}
+
+// StepPending returns number of writes waiting for Step on subnetwork n.
+func StepPending(n *SubNetwork) int {
+	n.stepMu.Lock()
+	defer n.stepMu.Unlock()
+	return len(n.stepq)
+}
@@ -43,6 +43,9 @@ type Engine interface {
 	// hostdata a way for VNetDial - potentially run on another subnetwork
 	// - to find out where to connect to when dialing to this host.
 	//
+	// VNetNewHost should return promptly with ctx error when ctx is
+	// canceled, in particular while waiting on registry.
+	//
 	// On error the returned error will be wrapped by virtnet with "new
 	// host" operation and hostname.
 	VNetNewHost(ctx context.Context, hostname string, registry Registry) error
@@ -173,10 +176,39 @@ type Registry interface {
 	Close() error
 }
 
+// Reannouncer is optional interface that Registry can implement to allow
+// changing data of already announced host.
+type Reannouncer interface {
+	// Reannounce replaces data of already announced host with hostdata.
+	//
+	// Returned error, if !nil, is *RegistryError with .Err describing the
+	// error cause:
+	//
+	//	- ErrRegistryDown  if registry cannot be accessed,
+	//	- ErrNoHost        if hostname was not announced to registry,
+	//	- some other error indicating e.g. IO problem.
+	Reannounce(ctx context.Context, hostname, hostdata string) error
+}
+
+// Lister is optional interface that Registry can implement to allow listing
+// all announced hosts.
+type Lister interface {
+	// List returns all announced hosts and their data.
+	//
+	// Returned error, if !nil, is *RegistryError with .Err describing the
+	// error cause:
+	//
+	//	- ErrRegistryDown  if registry cannot be accessed,
+	//	- some other error indicating e.g. IO problem.
+	List(ctx context.Context) (map[string]string, error)
+}
+
 var (
 	ErrRegistryDown = errors.New("registry is down")
 	ErrNoHost       = errors.New("no such host")
 	ErrHostDup      = errors.New("host already registered")
+	ErrNoReannounce = errors.New("registry does not support reannounce")
+	ErrNoList       = errors.New("registry does not support list")
 )
 
 // RegistryError represents an error of a registry operation.
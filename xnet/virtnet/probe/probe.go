@@ -0,0 +1,200 @@
+// Copyright (C) 2026  Nexedi SA and Contributors.
+//                     Kirill Smelkov <kirr@nexedi.com>
+//
+// This program is free software: you can Use, Study, Modify and Redistribute
+// it under the terms of the GNU General Public License version 3, or (at your
+// option) any later version, as published by the Free Software Foundation.
+//
+// You can also Link and Combine this program with other software covered by
+// the terms of any of the Free Software licenses or any of the Open Source
+// Initiative approved licenses and Convey the resulting work. Corresponding
+// source of such a combination shall include the source code for all other
+// software used.
+//
+// This program is distributed WITHOUT ANY WARRANTY; without even the implied
+// warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+//
+// See COPYING file for full licensing terms.
+// See https://www.nexedi.com/licensing for rationale and options.
+
+// Package probe provides in-band liveness checking for connections.
+//
+// It is intended for test scaffolding only: virtnet connections have no
+// framing, and so the probe is recognized by magic bytes in the data stream.
+//
+// A server opts into answering probes by wrapping its listener with
+// PingableListener. A client then can check liveness of connection to such
+// server with Ping. Probes are recognized and answered by server-side
+// connection only when they start a chunk of data received by one read
+// from underlying connection, possibly continuing into subsequent reads, and
+// only while the server reads from the connection. Ping must not be used on a
+// connection concurrently with application reads.
+package probe
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"lab.nexedi.com/kirr/go123/xnet"
+)
+
+// magic is the probe sent by Ping; pingable connections answer it with pong.
+//
+// pong differs from magic so that a peer, which merely echoes data back, is
+// not taken as pingable.
+var magic = []byte("\xffvirtnet-ping\xff")
+var pong  = []byte("\xffvirtnet-pong\xff")
+
+// ErrBadReply is the error returned by Ping when peer replied with something
+// other than the probe answer.
+var ErrBadReply = errors.New("bad ping reply")
+
+// Ping checks liveness of connection c.
+//
+// It sends a probe and waits for its answer for at most timeout time. The peer
+// of c must be a connection accepted via PingableListener.
+//
+// On failure returned error is *net.OpError with Op "ping". It is classified
+// as Timeout if the answer did not arrive in time.
+func Ping(ctx context.Context, c net.Conn, timeout time.Duration) (err error) {
+	defer func() {
+		if err != nil {
+			err = &net.OpError{
+				Op:     "ping",
+				Net:    c.LocalAddr().Network(),
+				Source: c.LocalAddr(),
+				Addr:   c.RemoteAddr(),
+				Err:    err,
+			}
+		}
+	}()
+
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	err = c.SetDeadline(deadline)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err2 := c.SetDeadline(time.Time{})
+		if err == nil {
+			err = err2
+		}
+	}()
+
+	// interrupt IO on ctx cancel
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			c.SetDeadline(time.Unix(1, 0))
+		}
+	}()
+
+	err = ping(c)
+	if err != nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	return err
+}
+
+// ping sends probe over c and verifies its answer.
+func ping(c net.Conn) error {
+	_, err := c.Write(magic)
+	if err != nil {
+		return err
+	}
+	reply := make([]byte, len(pong))
+	_, err = io.ReadFull(c, reply)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(reply, pong) {
+		return ErrBadReply
+	}
+	return nil
+}
+
+
+// PingableListener wraps listener l so that connections it accepts answer
+// probes sent by Ping.
+//
+// Probes are filtered out from data read by application.
+func PingableListener(l xnet.Listener) xnet.Listener {
+	return &pingableListener{l}
+}
+
+type pingableListener struct {
+	xnet.Listener
+}
+
+func (l *pingableListener) Accept(ctx context.Context) (net.Conn, error) {
+	conn, err := l.Listener.Accept(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &pingableConn{Conn: conn}, nil
+}
+
+// pingableConn is connection accepted via PingableListener.
+type pingableConn struct {
+	net.Conn
+
+	rbuf   [4096]byte
+	rdata  []byte // data received but not yet read by application
+	rprobe bool   // rdata starts a chunk and might be a probe
+
+	wmu sync.Mutex // serializes application writes and answers to probes
+}
+
+func (c *pingableConn) Read(p []byte) (int, error) {
+	for len(c.rdata) == 0 || c.rprobe {
+		var err error
+		switch {
+		case len(c.rdata) == 0:
+			var n int
+			n, err = c.Conn.Read(c.rbuf[:])
+			c.rdata = c.rbuf[:n]
+			c.rprobe = true
+
+		case bytes.HasPrefix(c.rdata, magic):
+			c.rdata = c.rdata[len(magic):]
+			_, err = c.Write(pong)
+
+		case bytes.HasPrefix(magic, c.rdata):
+			// probe start - read its rest
+			l := copy(c.rbuf[:], c.rdata)
+			var n int
+			n, err = c.Conn.Read(c.rbuf[l:])
+			c.rdata = c.rbuf[:l+n]
+			if err != nil {
+				c.rprobe = false // incomplete probe is data
+			}
+
+		default:
+			c.rprobe = false
+		}
+		if err != nil && len(c.rdata) == 0 {
+			return 0, err
+		}
+	}
+
+	n := copy(p, c.rdata)
+	c.rdata = c.rdata[n:]
+	return n, nil
+}
+
+func (c *pingableConn) Write(p []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return c.Conn.Write(p)
+}
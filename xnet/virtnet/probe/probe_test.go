@@ -0,0 +1,168 @@
+// Copyright (C) 2026  Nexedi SA and Contributors.
+//                     Kirill Smelkov <kirr@nexedi.com>
+//
+// This program is free software: you can Use, Study, Modify and Redistribute
+// it under the terms of the GNU General Public License version 3, or (at your
+// option) any later version, as published by the Free Software Foundation.
+//
+// You can also Link and Combine this program with other software covered by
+// the terms of any of the Free Software licenses or any of the Open Source
+// Initiative approved licenses and Convey the resulting work. Corresponding
+// source of such a combination shall include the source code for all other
+// software used.
+//
+// This program is distributed WITHOUT ANY WARRANTY; without even the implied
+// warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+//
+// See COPYING file for full licensing terms.
+// See https://www.nexedi.com/licensing for rationale and options.
+
+package probe
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+
+	"lab.nexedi.com/kirr/go123/exc"
+	"lab.nexedi.com/kirr/go123/internal/xtesting"
+	"lab.nexedi.com/kirr/go123/xnet"
+	"lab.nexedi.com/kirr/go123/xnet/pipenet"
+)
+
+// dialAccept dials from hβ to l on hα and returns client and server connections.
+func dialAccept(ctx context.Context, hβ xnet.Networker, l xnet.Listener) (cβ, cα net.Conn) {
+	X := exc.Raiseif
+	wg, ctx := errgroup.WithContext(ctx)
+	wg.Go(func() (err error) {
+		cα, err = l.Accept(ctx)
+		return err
+	})
+	cβ, err := hβ.Dial(ctx, l.Addr().String());  X(err)
+	X(wg.Wait())
+	return cβ, cα
+}
+
+func TestPing(t *testing.T) {
+	X := exc.Raiseif
+	assert := xtesting.Assert(t)
+	bg := context.Background()
+
+	pnet := pipenet.New("t")
+	hα := pnet.Host("α")
+	hβ := pnet.Host("β")
+
+	l, err := hα.Listen(bg, "");  X(err)
+	cβ, cα := dialAccept(bg, hβ, PingableListener(l))
+
+	// echo server
+	wg := &errgroup.Group{}
+	wg.Go(func() error {
+		_, err := io.Copy(cα, cα)
+		return err
+	})
+
+	// application data and pings are interleaved without interfering
+	buf := make([]byte, 5)
+	for _, msg := range []string{"hello", "world"} {
+		X(Ping(bg, cβ, time.Second))
+		_, err = cβ.Write([]byte(msg));  X(err)
+		_, err = io.ReadFull(cβ, buf);  X(err)
+		assert.Eq(string(buf), msg)
+	}
+	X(Ping(bg, cβ, time.Second))
+
+	X(cβ.Close())
+	X(wg.Wait())
+	X(l.Close())
+
+	// peer that does not echo -> timeout
+	l, err = hα.Listen(bg, "");  X(err)
+	cβ, cα = dialAccept(bg, hβ, l)
+
+	err = Ping(bg, cβ, 50*time.Millisecond)
+	e, ok := err.(*net.OpError)
+	if !(ok && e.Op == "ping" && e.Timeout()) {
+		t.Fatalf("ping non-echoing peer -> %v  ; want ping timeout", err)
+	}
+
+	// canceled ctx is reported as such
+	ctx, cancel := context.WithCancel(bg)
+	cancel()
+	err = Ping(ctx, cβ, time.Second)
+	e, ok = err.(*net.OpError)
+	if !(ok && e.Err == context.Canceled) {
+		t.Fatalf("ping with canceled ctx -> %v  ; want %v", err, context.Canceled)
+	}
+
+	X(cα.Close())
+	X(cβ.Close())
+	X(l.Close())
+
+	// peer that echoes, but is not pingable -> bad reply
+	l, err = hα.Listen(bg, "");  X(err)
+	cβ, cα = dialAccept(bg, hβ, l)
+	wg.Go(func() error {
+		_, err := io.Copy(cα, cα)
+		return err
+	})
+
+	err = Ping(bg, cβ, time.Second)
+	e, ok = err.(*net.OpError)
+	if !(ok && e.Op == "ping" && e.Err == ErrBadReply) {
+		t.Fatalf("ping non-pingable peer -> %v  ; want %v", err, ErrBadReply)
+	}
+
+	X(cβ.Close())
+	X(wg.Wait())
+	X(l.Close())
+}
+
+// TestPingSplit verifies that probe is recognized when it arrives split
+// across several reads, and that data resembling probe start is not lost.
+func TestPingSplit(t *testing.T) {
+	X := exc.Raiseif
+	assert := xtesting.Assert(t)
+	bg := context.Background()
+
+	pnet := pipenet.New("t")
+	hα := pnet.Host("α")
+	hβ := pnet.Host("β")
+
+	l, err := hα.Listen(bg, "");  X(err)
+	cβ, cα := dialAccept(bg, hβ, PingableListener(l))
+
+	// echo server
+	wg := &errgroup.Group{}
+	wg.Go(func() error {
+		_, err := io.Copy(cα, cα)
+		return err
+	})
+
+	// every write is received by separate read on pipenet
+	reply := make([]byte, len(pong))
+	for _, split := range []int{1, 5, len(magic)-1} {
+		_, err = cβ.Write(magic[:split]);  X(err)
+		_, err = cβ.Write(magic[split:]);  X(err)
+		_, err = io.ReadFull(cβ, reply);  X(err)
+		assert.Eq(string(reply), string(pong))
+	}
+
+	// probe start followed by other data is application data
+	data := append(append([]byte{}, magic[:5]...), "hello"...)
+	_, err = cβ.Write(data[:5]);  X(err)
+	_, err = cβ.Write(data[5:]);  X(err)
+	buf := make([]byte, len(data))
+	_, err = io.ReadFull(cβ, buf);  X(err)
+	assert.Eq(string(buf), string(data))
+
+	X(Ping(bg, cβ, time.Second))
+
+	X(cβ.Close())
+	X(wg.Wait())
+	X(l.Close())
+}
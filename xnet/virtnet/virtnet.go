@@ -70,15 +70,21 @@ package virtnet
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
+	"math/rand"
 	"net"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
+	"time"
 
 	"lab.nexedi.com/kirr/go123/xcontext"
 	"lab.nexedi.com/kirr/go123/xerr"
 	"lab.nexedi.com/kirr/go123/xnet"
+	"lab.nexedi.com/kirr/go123/xsync"
 )
 
 var (
@@ -88,13 +94,23 @@ var (
 	ErrAddrAlreadyUsed = errors.New("address already in use")
 	ErrAddrNoListen    = errors.New("cannot listen on requested address")
 	ErrConnRefused     = errors.New("connection refused")
+	ErrInvalidPort     = errors.New("cannot dial port 0")
+	ErrUnreachable     = errors.New("network is unreachable")
+	ErrNoRawConn       = errors.New("raw connection not supported")
+	ErrQuotaExceeded   = errors.New("byte quota exceeded")
 )
 
 // Addr represents address of a virtnet endpoint.
+//
+// Zone is opaque to virtnet and is useful to test protocols with richer
+// addressing. Zone of dialed address is carried through Dial and Accept:
+// it is reported in LocalAddr of accepted connection and in RemoteAddr of
+// the dialing side.
 type Addr struct {
 	Net  string // full network name, e.g. "pipeα" or "lonetβ"
 	Host string // name of host access point on the network
 	Port int    // port on host
+	Zone string // optional opaque zone, e.g. "z" in "α%z:1"; "" if absent
 }
 
 // SubNetwork represents one subnetwork of a virtnet network.
@@ -107,6 +123,20 @@ type Addr struct {
 // SubNetwork should be created by a particular virtnet network implementation
 // via NewSubNetwork.
 type SubNetwork struct {
+	// cumulative bytes read/written by connections on this subnetwork.
+	// first in the struct for 64-bit alignment of atomic access.
+	nread    int64
+	nwritten int64
+
+	// max size of one underlying write; 0 -> unlimited. accessed atomically.
+	segSize int64
+
+	// stepping mode: 1 -> writes wait for Step. accessed atomically.
+	stepping  uint32
+	stepMu    sync.Mutex
+	stepq     []*stepWaiter // writes pending in stepping mode, in arrival order
+	stepReady chan struct{} // closed and renewed when a write is queued to stepq
+
 	// full network name, e.g. "pipeα" or "lonetβ"
 	network string
 
@@ -117,8 +147,23 @@ type SubNetwork struct {
 	// {} hostname -> Host
 	hostMu     sync.Mutex
 	hostMap    map[string]*Host
-	nopenHosts int  // #(hosts-in-open-state) in hostMap
-	autoClose  bool // close SubNetwork when last host is Closed
+	nopenHosts int        // #(hosts-in-open-state) in hostMap
+	autoClose  bool       // close SubNetwork when last host is Closed
+	sparse     bool       // new hosts keep sockets in map instead of slice
+	nameRand   *rand.Rand // source for NewHostAuto names; nil -> global
+	nat        func(src *Addr) *Addr // !nil -> rewrites source address of incoming connections
+	clock      Clock                 // time source for deadlines; nil -> real time
+
+	// !nil -> called for refused incoming connections; see SetRefuseLogger
+	refuseLog func(src, dst *Addr, reason error)
+
+	// !nil -> rewrites payload of every conn write; see SetPayloadFilter
+	payloadFilter func(src, dst *Addr, data []byte) []byte
+
+	// ring of recent events; see SetEventLog
+	logMu   sync.Mutex
+	logv    []LogEntry // len(logv) = log size; 0 -> logging disabled
+	logNext int        // logv[logNext % len(logv)] is where next entry goes
 
 	down     chan struct{} // closed when no longer operational
 	downErr  error
@@ -133,14 +178,32 @@ type SubNetwork struct {
 //
 // It is safe to use Host from multiple goroutines simultaneously.
 type Host struct {
+	// bytes that host connections can still read/write; <0 -> unlimited.
+	// first in the struct for 64-bit alignment of atomic access.
+	rxBudget int64
+	txBudget int64
+
 	subnet *SubNetwork
 	name   string
 
-	// [] port -> listener | conn  ; [0] is always nil
-	sockMu  sync.Mutex
-	socketv []*socket
+	// port -> listener | conn  ; port 0 is never used
+	sockMu    sync.Mutex
+	socketTab socketTab
+	reuse     ReusePolicy // how autobind picks free ports
+	nextPort  int         // DelayedReuse: autobind searches for free port starting from here
+	maxPort   int         // DelayedReuse: ports above it are not picked while freed ports can be reused
+
+	// host is partitioned from the network; see SetPartition. protected by sockMu
+	partIn, partOut bool
+
+	// idle connections kept by PooledClose for reuse by PooledDial
+	poolMu          sync.Mutex
+	pool            map[string][]*conn // dialed address -> idle connections
+	poolMaxIdle     int                // max idle connections per address
+	poolIdleTimeout time.Duration      // idle connection is closed after it; 0 -> never
 
 	down      chan struct{} // closed when no longer operational
+	downErr   error         // errors we got from closing connections on shutdown
 	downOnce  sync.Once
 	closeOnce sync.Once
 }
@@ -153,9 +216,21 @@ var _ xnet.Networker = (*Host)(nil)
 type socket struct {
 	host *Host // host/port this socket is bound to
 	port int
+	zone string // zone of the address this socket is bound to
+
+	conn *conn // connection endpoint is here if != nil
+
+	// listeners are waiting here if !empty.
+	//
+	// there can be several listeners only if they all were created via
+	// ListenShared. Incoming connections are distributed in between them
+	// in round-robin order starting from listenerv[nextl].
+	listenerv []*listener
+	shared    bool // listenerv was created via ListenShared
+	nextl     int
 
-	conn     *conn     // connection endpoint is here if != nil
-	listener *listener // listener is waiting here if != nil
+	attrMu sync.Mutex
+	attrv  map[string]string // attributes of connection endpoint; see SetAttr
 }
 
 // conn represents one endpoint of a virtnet connection.
@@ -167,10 +242,17 @@ type conn struct {
 
 	net.Conn
 
-	down      uint32    // 1 after shutdown
+	down      uint32        // 1 after shutdown
+	downc     chan struct{} // closed on shutdown
 	downOnce  sync.Once
 	errClose  error     // error we got from closing underlying net.Conn
 	closeOnce sync.Once
+
+	poolKey   string      // dialed address if conn was created via PooledDial
+	idleTimer *time.Timer // !nil while conn is idle in the pool; protected by host.poolMu
+
+	// !nil -> reported as LocalAddr instead of socket address; see ListenRange
+	laddr *Addr
 }
 
 // listener implements xnet.Listener for Host.Listen .
@@ -180,6 +262,21 @@ type listener struct {
 
 	dialq chan dialReq // Dial requests to our port go here
 
+	backlog  chan struct{} // one entry per pending dial; nil if unlimited
+	overflow OverflowPolicy
+
+	acceptFilter func(src *Addr) error // !nil -> dials are checked with it; protected by host.sockMu
+
+	// accepted conns report dialed address as LocalAddr; see ListenRange
+	dialedLocal bool
+
+	acceptc    chan AcceptResult // see AcceptChan
+	acceptOnce sync.Once
+
+	// dials that found this listener and are not yet done, and accepts
+	// that are handshaking with them; see CloseGraceful
+	inflight sync.WaitGroup
+
 	down      chan struct{} // closed when no longer operational
 	downOnce  sync.Once
 	closeOnce sync.Once
@@ -188,6 +285,8 @@ type listener struct {
 // dialReq represents one dial request to listener from acceptor.
 type dialReq struct {
 	from *Addr
+	port int    // port of the address that was dialed
+	zone string // zone of the address that was dialed
 	conn net.Conn
 	resp chan *Accept
 }
@@ -214,11 +313,12 @@ type notifier struct {
 func NewSubNetwork(network string, engine Engine, registry Registry) (*SubNetwork, Notifier) {
 	// XXX prefix network with "virtnet/" ?
 	subnet := &SubNetwork{
-		network:  network,
-		engine:   engine,
-		registry: registry,
-		hostMap:  make(map[string]*Host),
-		down:     make(chan struct{}),
+		network:   network,
+		engine:    engine,
+		registry:  registry,
+		hostMap:   make(map[string]*Host),
+		stepReady: make(chan struct{}),
+		down:      make(chan struct{}),
 	}
 
 	return subnet, &notifier{subnet}
@@ -241,18 +341,25 @@ func (n *SubNetwork) shutdown(err error) error { return n._shutdown(err, true) }
 func (n *SubNetwork) _shutdown(err error, withHosts bool) error {
 	n.downOnce.Do(func() {
 		close(n.down)
+		n.logEvent("down", n.network, err)
+
+		var errv xerr.Errorv
+		errv.Appendif( err )
 
 		// shutdown hosts
 		if withHosts {
 			n.hostMu.Lock()
 			for _, host := range n.hostMap {
-				host.shutdown()
+				if ready(host.down) {
+					continue // already shut down and reported error via host.Close
+				}
+				if err := host.shutdown(); err != nil {
+					errv.Appendf("host %q: %s", host.name, err)
+				}
 			}
 			n.hostMu.Unlock()
 		}
 
-		var errv xerr.Errorv
-		errv.Appendif( err )
 		errv.Appendif( n.engine.Close() )
 		errv.Appendif( n.registry.Close() )
 
@@ -285,8 +392,14 @@ func (nn *notifier) VNetDown(err error) {
 //
 // Host names should be unique through whole virtnet network.
 // If not - an error with ErrHostDup cause will be returned.
+//
+// ctx bounds host creation: if ctx is canceled, or its deadline passes,
+// NewHost is aborted with an error having ctx error as the cause.
 func (n *SubNetwork) NewHost(ctx context.Context, name string) (_ *Host, err error) {
 	defer xerr.Contextf(&err, "virtnet %q: new host %q", n.network, name)
+	defer func() {
+		n.logEvent("new host", name, err)
+	}()
 
 	// cancel on network shutdown
 	origCtx := ctx
@@ -310,13 +423,211 @@ func (n *SubNetwork) NewHost(ctx context.Context, name string) (_ *Host, err err
 		panic("announced ok but .hostMap already !empty")
 	}
 
-	host := &Host{subnet: n, name: name, down: make(chan struct{})}
+	host := &Host{subnet: n, name: name, down: make(chan struct{}), rxBudget: -1, txBudget: -1, poolMaxIdle: 2,
+		maxPort: maxPort}
+	if n.sparse {
+		host.socketTab.m = make(map[int]*socket)
+	}
 	n.hostMap[name] = host
 	n.nopenHosts++
 
 	return host, nil
 }
 
+// NewHostAuto creates new Host with automatically generated name.
+//
+// The names are pseudo-random. Use SetNameSource to make them reproducible.
+func (n *SubNetwork) NewHostAuto(ctx context.Context) (*Host, error) {
+	for {
+		host, err := n.NewHost(ctx, n.genName())
+		if errors.Is(err, ErrHostDup) {
+			continue // name already taken - retry with another one
+		}
+		return host, err
+	}
+}
+
+// SetNameSource sets source of pseudo-random numbers for generated names.
+//
+// By default the global source from package math/rand is used. Seeded source
+// makes names generated by NewHostAuto reproducible.
+func (n *SubNetwork) SetNameSource(rand *rand.Rand) {
+	n.hostMu.Lock()
+	defer n.hostMu.Unlock()
+	n.nameRand = rand
+}
+
+// SetRefuseLogger installs logger for refused incoming connections.
+//
+// logger is called with address of the dialer, address it dials and the
+// reason why the connection was refused, e.g. ErrConnRefused when there is no
+// listener, the listener is closed or its backlog is full, or error returned
+// by accept filter. Dials canceled via their context are not logged. By
+// default refused connections are not logged.
+func (n *SubNetwork) SetRefuseLogger(logger func(src, dst *Addr, reason error)) {
+	n.hostMu.Lock()
+	defer n.hostMu.Unlock()
+	n.refuseLog = logger
+}
+
+// SetPayloadFilter installs filter for data written to connections.
+//
+// For every Write on a connection of this subnetwork, filter is called with
+// addresses of the writer and of the peer, and with copy of the data being
+// written. filter can modify the data in place, or return another slice, and
+// it is the returned data that is delivered to the peer. If filter returns nil
+// or empty slice the write is dropped. In any case the writer sees the whole
+// original data as written. This allows to test protocol handling of
+// corrupted, truncated or lost messages.
+//
+// The filter works at virtnet level, so e.g. tracing via xnet.NetTrace
+// wrapping a Host sees the data before it is filtered. nil filter removes
+// the filtering.
+func (n *SubNetwork) SetPayloadFilter(filter func(src, dst *Addr, data []byte) []byte) {
+	n.hostMu.Lock()
+	defer n.hostMu.Unlock()
+	n.payloadFilter = filter
+}
+
+// getPayloadFilter returns filter installed by SetPayloadFilter.
+func (n *SubNetwork) getPayloadFilter() func(src, dst *Addr, data []byte) []byte {
+	n.hostMu.Lock()
+	defer n.hostMu.Unlock()
+	return n.payloadFilter
+}
+
+// Clock is source of time used by virtnet for deadlines.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// realClock is Clock that uses real time.
+type realClock struct{}
+
+func (realClock) Now() time.Time                        { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// SetClock sets source of time for deadlines, e.g. those of Host.DialDeadline.
+//
+// By default real time is used. Tests can install clock that is driven by
+// simulated time to trigger timeouts without real sleeping.
+func (n *SubNetwork) SetClock(clock Clock) {
+	n.hostMu.Lock()
+	defer n.hostMu.Unlock()
+	n.clock = clock
+}
+
+// getClock returns clock to use for deadlines on the subnetwork.
+func (n *SubNetwork) getClock() Clock {
+	n.hostMu.Lock()
+	defer n.hostMu.Unlock()
+	if n.clock == nil {
+		return realClock{}
+	}
+	return n.clock
+}
+
+// SetNAT installs translation of source address for incoming connections.
+//
+// For every connection accepted on this subnetwork, nat is called with the
+// address of the dialer and the address it returns becomes RemoteAddr of the
+// accepted connection. Accept filters see translated address as well. The
+// dialer's view of the connection is unchanged. This allows to simulate
+// clients behind NAT.
+//
+// If nat returns nil, the address is left untranslated. nil nat removes the
+// translation.
+func (n *SubNetwork) SetNAT(nat func(src *Addr) *Addr) {
+	n.hostMu.Lock()
+	defer n.hostMu.Unlock()
+	n.nat = nat
+}
+
+// LogEntry represents one event recorded in subnetwork event log.
+//
+// See SubNetwork.SetEventLog for details.
+type LogEntry struct {
+	Time  time.Time
+	Event string // "new host", "listen", "dial", "accept", "close" or "down"
+	Addr  string // host, address, or "src->dst" for connections
+	Err   error  // !nil if operation failed
+}
+
+func (e LogEntry) String() string {
+	s := fmt.Sprintf("%s %s", e.Event, e.Addr)
+	if e.Err != nil {
+		s += ": " + e.Err.Error()
+	}
+	return s
+}
+
+// SetEventLog enables in-memory log of the last size lifecycle events on the subnetwork.
+//
+// Host creation and close, Listen, Dial, Accept, close of listeners and
+// connections, and subnetwork shutdown are logged, together with errors of
+// the corresponding operations. Recorded events are retrieved via EventLog
+// and are handy to understand what happened when a test fails.
+//
+// size <= 0 disables the log. Previously recorded events are discarded.
+func (n *SubNetwork) SetEventLog(size int) {
+	if size < 0 {
+		size = 0
+	}
+	n.logMu.Lock()
+	defer n.logMu.Unlock()
+	n.logv = make([]LogEntry, size)
+	n.logNext = 0
+}
+
+// EventLog returns events recorded in the log enabled by SetEventLog.
+//
+// The events are returned in the order they happened, oldest first.
+func (n *SubNetwork) EventLog() []LogEntry {
+	n.logMu.Lock()
+	defer n.logMu.Unlock()
+
+	size := len(n.logv)
+	if size == 0 {
+		return nil
+	}
+	var logv []LogEntry
+	start := 0
+	if n.logNext > size {
+		start = n.logNext - size
+	}
+	for i := start; i < n.logNext; i++ {
+		logv = append(logv, n.logv[i % size])
+	}
+	return logv
+}
+
+// logEvent records event into subnetwork event log, if the log is enabled.
+func (n *SubNetwork) logEvent(event, addr string, err error) {
+	n.logMu.Lock()
+	defer n.logMu.Unlock()
+
+	size := len(n.logv)
+	if size == 0 {
+		return
+	}
+	n.logv[n.logNext % size] = LogEntry{Time: time.Now(), Event: event, Addr: addr, Err: err}
+	n.logNext++
+}
+
+// genName generates new pseudo-random host name.
+func (n *SubNetwork) genName() string {
+	n.hostMu.Lock()
+	defer n.hostMu.Unlock()
+	var x uint32
+	if n.nameRand != nil {
+		x = n.nameRand.Uint32()
+	} else {
+		x = rand.Uint32()
+	}
+	return fmt.Sprintf("h%08x", x)
+}
+
 // Host returns host on the subnetwork by name.
 //
 // If there is no such host - nil is returned.
@@ -328,7 +639,7 @@ func (n *SubNetwork) Host(name string) *Host {
 }
 
 // shutdown is underlying worker for Close.
-func (h *Host) shutdown() {
+func (h *Host) shutdown() error {
 	h.downOnce.Do(func() {
 		close(h.down)
 
@@ -336,18 +647,19 @@ func (h *Host) shutdown() {
 		h.sockMu.Lock()
 		defer h.sockMu.Unlock()
 
-		for _, sk := range h.socketv {
-			if sk == nil {
-				continue
-			}
+		var errv xerr.Errorv
+		for _, sk := range h.socketTab.all() {
 			if sk.conn != nil {
-				sk.conn.shutdown()
+				errv.Appendif( sk.conn.shutdown() )
 			}
-			if sk.listener != nil {
-				sk.listener.shutdown()
+			for _, l := range sk.listenerv {
+				l.shutdown()
 			}
 		}
+		h.downErr = errv.Err()
 	})
+
+	return h.downErr
 }
 
 // Close shutdowns host.
@@ -357,9 +669,12 @@ func (h *Host) shutdown() {
 //
 // Close interrupts all currently in-flight blocked I/O operations on Host or
 // objects created from it: connections and listeners.
+//
+// Errors from closing underlying connections are returned merged together.
 func (h *Host) Close() (err error) {
 	defer xerr.Contextf(&err, "virtnet %q: host %q: close", h.subnet.network, h.name)
-	h.shutdown()
+	err = h.shutdown()
+	h.subnet.logEvent("close", h.name, err)
 
 	// close subnet if autoclose=y and we were the last open host
 	h.closeOnce.Do(func() {
@@ -371,13 +686,137 @@ func (h *Host) Close() (err error) {
 			panic("SubNetwork.nopenHosts < 0")
 		}
 		if n.autoClose && n.nopenHosts == 0 {
-			err = n.closeWithoutHosts()
+			err = xerr.Merge(err, n.closeWithoutHosts())
 		}
 	})
 
 	return err
 }
 
+// Migrate changes data of the host in subnetwork registry to newhostdata.
+//
+// It is useful to simulate a node that restarts on another address: dials to
+// the host, that are made after Migrate, use new host data. Already
+// established connections are not affected.
+//
+// Registry of the subnetwork must implement Reannouncer.
+func (h *Host) Migrate(ctx context.Context, newhostdata string) (err error) {
+	defer xerr.Contextf(&err, "virtnet %q: host %q: migrate", h.subnet.network, h.name)
+
+	if ready(h.down) {
+		return h.errDown()
+	}
+	r, ok := h.subnet.registry.(Reannouncer)
+	if !ok {
+		return ErrNoReannounce
+	}
+	return r.Reannounce(ctx, h.name, newhostdata)
+}
+
+// RegistrySnapshot returns all hosts announced to subnetwork registry and their data.
+//
+// It is useful for diagnostics. Registry of the subnetwork must implement
+// Lister.
+func (n *SubNetwork) RegistrySnapshot(ctx context.Context) (_ map[string]string, err error) {
+	defer xerr.Contextf(&err, "virtnet %q: registry snapshot", n.network)
+
+	r, ok := n.registry.(Lister)
+	if !ok {
+		return nil, ErrNoList
+	}
+	return r.List(ctx)
+}
+
+// ReusePolicy specifies how autobind picks ports freed after close.
+type ReusePolicy int
+
+const (
+	ImmediateReuse ReusePolicy = iota // the lowest free port is picked
+	DelayedReuse                      // ports are picked above the last picked one
+)
+
+// SetPortReusePolicy sets how autobind of the host picks free ports.
+//
+// With ImmediateReuse, which is the default, the lowest free port is
+// allocated, so a port is reused immediately after it is freed. With
+// DelayedReuse, the port is allocated above the last allocated one, and
+// freed ports are queued and reused, in the order they were freed, only
+// after all ports up to 65535 were used. This is closer to OS behaviour and
+// helps to catch use-after-close bugs. For hosts with many connections
+// DelayedReuse is better used together with SubNetwork.SparsePorts.
+func (h *Host) SetPortReusePolicy(p ReusePolicy) {
+	h.sockMu.Lock()
+	defer h.sockMu.Unlock()
+	h.reuse = p
+	h.socketTab.trackFree = (p == DelayedReuse)
+	if !h.socketTab.trackFree {
+		h.socketTab.freeq = nil
+	}
+}
+
+// SetPartition partitions the host from the network.
+//
+// While partitioned for inbound, dials to the host are refused with
+// ErrConnRefused. While partitioned for outbound, dials from the host fail
+// with ErrUnreachable. Already established connections are not affected.
+// SetPartition(false, false) heals the partition.
+//
+// SetPartition can be used to test how applications handle network partitions.
+func (h *Host) SetPartition(inbound, outbound bool) {
+	h.sockMu.Lock()
+	defer h.sockMu.Unlock()
+	h.partIn = inbound
+	h.partOut = outbound
+}
+
+// SetByteBudget limits how many bytes connections of the host can read and write.
+//
+// The budgets are shared by all connections of the host. Once rx bytes were
+// read, or tx bytes were written, further reads, or correspondingly writes,
+// fail with ErrQuotaExceeded. Negative budget means unlimited, which is the
+// default.
+//
+// SetByteBudget can be used to test how applications handle quota enforcement.
+func (h *Host) SetByteBudget(rx, tx int64) {
+	atomic.StoreInt64(&h.rxBudget, rx)
+	atomic.StoreInt64(&h.txBudget, tx)
+}
+
+// reserveBudget takes up to n bytes from budget.
+//
+// It returns how many bytes were taken, which is n if the budget is unlimited.
+func reserveBudget(budget *int64, n int) int {
+	for {
+		b := atomic.LoadInt64(budget)
+		if b < 0 {
+			return n
+		}
+		take := int64(n)
+		if take > b {
+			take = b
+		}
+		if atomic.CompareAndSwapInt64(budget, b, b-take) {
+			return int(take)
+		}
+	}
+}
+
+// refundBudget returns n bytes, previously taken via reserveBudget, back to budget.
+func refundBudget(budget *int64, n int) {
+	if n == 0 {
+		return
+	}
+	for {
+		b := atomic.LoadInt64(budget)
+		if b < 0 {
+			return // budget became unlimited
+		}
+		if atomic.CompareAndSwapInt64(budget, b, b+int64(n)) {
+			return
+		}
+	}
+}
+
 // AutoClose schedules Close to be called after last host on this subnetwork is closed.
 //
 // It is an error to call AutoClose with no opened hosts - this will panic.
@@ -390,114 +829,474 @@ func (n *SubNetwork) AutoClose() {
 	n.autoClose = true
 }
 
+// SparsePorts makes hosts created on this subnetwork after the call keep
+// their sockets in a map instead of a slice indexed by port.
+//
+// This is useful when large port numbers are used, as memory used by a host
+// becomes proportional to number of its sockets instead of its highest port.
+// Port allocation semantic is unchanged.
+func (n *SubNetwork) SparsePorts() {
+	n.hostMu.Lock()
+	defer n.hostMu.Unlock()
+	n.sparse = true
+}
+
+// SetSegmentSize limits how much data a connection on this subnetwork passes
+// to underlying transport in one go.
+//
+// Writes larger than size are split into several underlying writes, so the
+// peer might observe such data via several Reads. This mimics MTU-like limits
+// of real transports. Zero size means unlimited, which is the default.
+func (n *SubNetwork) SetSegmentSize(size int) {
+	if size < 0 {
+		panic("virtnet: negative segment size")
+	}
+	atomic.StoreInt64(&n.segSize, int64(size))
+}
+
+
+// EnableStepping switches the subnetwork into stepping mode.
+//
+// In stepping mode data exchange is driven by Step: every write to a
+// connection on this subnetwork, or every segment of it if SetSegmentSize is
+// in effect, waits for Step to let it proceed. This way a test can advance
+// the exchange in between hosts deterministically, one write at a time,
+// independently of goroutine scheduling.
+//
+// Write deadlines are not taken into account while a write waits for Step.
+func (n *SubNetwork) EnableStepping() {
+	atomic.StoreUint32(&n.stepping, 1)
+}
+
+// Step lets one write, pending on the subnetwork in stepping mode, to proceed.
+//
+// Step waits for a write to become pending and returns after the write
+// completes. Pending writes are let to proceed in the order they started to
+// wait for Step. Step returns ErrNetDown if the subnetwork is down.
+//
+// See EnableStepping for details.
+func (n *SubNetwork) Step(ctx context.Context) error {
+	for {
+		n.stepMu.Lock()
+		ready := n.stepReady
+		var w *stepWaiter
+		if len(n.stepq) > 0 {
+			w = n.stepq[0]
+			n.stepq = n.stepq[1:]
+			close(w.release)
+		}
+		n.stepMu.Unlock()
+
+		if w != nil {
+			select {
+			case <-w.done:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-n.down:
+				return ErrNetDown
+			}
+		}
+
+		select {
+		case <-ready:
+			// retry
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-n.down:
+			return ErrNetDown
+		}
+	}
+}
+
+// stepWaiter represents a write waiting for Step.
+type stepWaiter struct {
+	release chan struct{} // closed by Step to let the write proceed
+	done    chan struct{} // closed by the writer after the write
+}
+
 
 // Listen starts new listener on the host.
 //
 // It either allocates free port if laddr is "" or with 0 port, or binds to laddr.
 // Once listener is started, Dials could connect to listening address.
 // Connection requests created by Dials could be accepted via Accept.
-func (h *Host) Listen(ctx context.Context, laddr string) (_ xnet.Listener, err error) {
-	var netladdr net.Addr
-	defer func() {
-		if err != nil {
-			err = &net.OpError{Op: "listen", Net: h.Network(), Addr: netladdr, Err: err}
-		}
-	}()
-
+func (h *Host) Listen(ctx context.Context, laddr string) (xnet.Listener, error) {
 	if laddr == "" {
 		laddr = ":0"
 	}
 
-	if err := ctx.Err(); err != nil {
-		return nil, err
-	}
-
 	a, err := h.parseAddr(laddr)
 	if err != nil {
-		return nil, err
-	}
-	netladdr = a
-
-	// cannot listen on other hosts
-	if a.Host != h.name {
-		return nil, ErrAddrNoListen
+		return nil, &net.OpError{Op: "listen", Net: h.Network(), Err: err}
 	}
 
-	if ready(h.down) {
-		return nil, h.errDown()
-	}
+	return h.ListenAddr(ctx, a)
+}
 
-	h.sockMu.Lock()
-	defer h.sockMu.Unlock()
+// ListenAddr starts new listener on the host at pre-parsed address laddr.
+//
+// It is similar to Listen but avoids string -> Addr round-trip.
+// laddr.Net must be the same as network of the host.
+func (h *Host) ListenAddr(ctx context.Context, laddr *Addr) (xnet.Listener, error) {
+	return h.listen(ctx, laddr, false, ListenOptions{}, nil)
+}
 
-	var sk *socket
+// OverflowPolicy specifies what happens to Dial when backlog of the listener
+// it connects to is full.
+type OverflowPolicy int
 
-	// find first free port if autobind requested
-	if a.Port == 0 {
-		sk = h.allocFreeSocket()
+const (
+	Block  OverflowPolicy = iota // dialer waits for room in the backlog
+	Refuse                       // dialer gets ErrConnRefused
+)
 
-	// else allocate socket in-place
-	} else {
-		// grow if needed
-		for a.Port >= len(h.socketv) {
-			h.socketv = append(h.socketv, nil)
-		}
+// ListenOptions represents options for ListenWithOptions.
+type ListenOptions struct {
+	// Backlog limits number of pending dials - those that are waiting for
+	// the listener to Accept them. 0 means no limit.
+	Backlog int
 
-		if h.socketv[a.Port] != nil {
-			return nil, ErrAddrAlreadyUsed
-		}
+	// Overflow specifies how Dial behaves when the backlog is full.
+	Overflow OverflowPolicy
+}
 
-		sk = &socket{host: h, port: a.Port}
-		h.socketv[a.Port] = sk
+// ListenWithOptions starts new listener on the host similarly to Listen,
+// but allows to configure the listener with opt.
+func (h *Host) ListenWithOptions(ctx context.Context, laddr string, opt ListenOptions) (xnet.Listener, error) {
+	if laddr == "" {
+		laddr = ":0"
 	}
 
-	// create listener under socket
-	l := &listener{
-		socket: sk,
-		dialq:  make(chan dialReq),
-		down:   make(chan struct{}),
+	a, err := h.parseAddr(laddr)
+	if err != nil {
+		return nil, &net.OpError{Op: "listen", Net: h.Network(), Err: err}
 	}
-	sk.listener = l
 
-	return l, nil
+	return h.listen(ctx, a, false, opt, nil)
 }
 
-// shutdown shutdowns the listener.
+// ListenShared starts new listener on the host similarly to Listen, but
+// allows several listeners to share the same address.
 //
-// It interrupts all currently in-flight calls to Accept, but does not
-// unregister listener from host's socket map.
-func (l *listener) shutdown() {
-	l.downOnce.Do(func() {
-		close(l.down)
-	})
+// It is similar to SO_REUSEPORT: if laddr is already bound by listeners
+// created via ListenShared, the new listener joins their acceptor group
+// instead of failing with ErrAddrAlreadyUsed. Incoming connections are
+// distributed in between listeners of the group in round-robin order.
+func (h *Host) ListenShared(ctx context.Context, laddr string) (xnet.Listener, error) {
+	if laddr == "" {
+		laddr = ":0"
+	}
+
+	a, err := h.parseAddr(laddr)
+	if err != nil {
+		return nil, &net.OpError{Op: "listen", Net: h.Network(), Err: err}
+	}
+
+	return h.listen(ctx, a, true, ListenOptions{}, nil)
 }
 
-// Close closes the listener.
+// ListenRange starts new listener on the host that accepts on every port in [lo, hi].
 //
-// It interrupts all currently in-flight calls to Accept.
-func (l *listener) Close() error {
-	l.shutdown()
-	l.closeOnce.Do(func() {
-		sk := l.socket
-		h := sk.host
-
-		h.sockMu.Lock()
-		defer h.sockMu.Unlock()
+// LocalAddr of accepted connections is the address that was dialed, so
+// that it is possible to see on which port a connection arrived. The dialer
+// sees the same address as RemoteAddr of its connection. Addr of
+// the listener is the address with lo port. ListenRange fails with
+// ErrAddrAlreadyUsed if any port in the range is already bound.
+func (h *Host) ListenRange(ctx context.Context, lo, hi int) (_ xnet.Listener, err error) {
+	if !(0 < lo && lo <= hi) {
+		return nil, &net.OpError{Op: "listen", Net: h.Network(),
+			Err: fmt.Errorf("invalid port range %d-%d", lo, hi)}
+	}
 
-		sk.listener = nil
-		if sk.empty() {
-			h.socketv[sk.port] = nil
+	rl := &rangeListener{dialq: make(chan dialReq)}
+	defer func() {
+		if err != nil {
+			rl.Close()
 		}
+	}()
+	for port := lo; port <= hi; port++ {
+		l, err := h.listen(ctx, &Addr{Net: h.Network(), Host: h.name, Port: port}, false, ListenOptions{}, rl)
+		if err != nil {
+			return nil, err
+		}
+		rl.lv = append(rl.lv, l.(*listener))
+	}
+	return rl, nil
+}
+
+// rangeListener is listener created by ListenRange.
+//
+// Listeners of all ports share dialq, and lv[0] is used to accept from it.
+type rangeListener struct {
+	lv    []*listener // one per port
+	dialq chan dialReq
+}
+
+func (rl *rangeListener) Accept(ctx context.Context) (net.Conn, error) {
+	return rl.lv[0].Accept(ctx)
+}
+
+func (rl *rangeListener) Addr() net.Addr {
+	return rl.lv[0].Addr()
+}
+
+func (rl *rangeListener) Close() error {
+	var errv xerr.Errorv
+	for _, l := range rl.lv {
+		errv.Appendif( l.Close() )
+	}
+	return errv.Err()
+}
+
+// SetAcceptFilter implements AcceptFilterer.
+func (rl *rangeListener) SetAcceptFilter(filter func(src *Addr) error) {
+	for _, l := range rl.lv {
+		l.SetAcceptFilter(filter)
+	}
+}
+
+// AcceptChan implements AcceptChanner.
+func (rl *rangeListener) AcceptChan() <-chan AcceptResult {
+	return rl.lv[0].AcceptChan()
+}
+
+// listen serves ListenAddr, ListenShared, ListenWithOptions and ListenRange.
+//
+// rl is !nil if the listener is created as part of ListenRange.
+func (h *Host) listen(ctx context.Context, laddr *Addr, shared bool, opt ListenOptions, rl *rangeListener) (_ xnet.Listener, err error) {
+	var netladdr net.Addr
+	if laddr != nil {
+		netladdr = laddr
+	}
+	defer func() {
+		h.subnet.logEvent("listen", fmt.Sprint(netladdr), err)
+		if err != nil {
+			err = &net.OpError{Op: "listen", Net: h.Network(), Addr: netladdr, Err: err}
+		}
+	}()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	a, err := h.checkAddr(laddr)
+	if err != nil {
+		return nil, err
+	}
+	netladdr = a
+
+	// cannot listen on other hosts
+	if a.Host != h.name {
+		return nil, ErrAddrNoListen
+	}
+
+	if ready(h.down) {
+		return nil, h.errDown()
+	}
+
+	h.sockMu.Lock()
+	defer h.sockMu.Unlock()
+
+	var sk *socket
+
+	// find first free port if autobind requested
+	if a.Port == 0 {
+		sk = h.allocFreeSocket()
+		sk.shared = shared
+		sk.zone = a.Zone
+
+	// else allocate socket in-place
+	} else {
+		sk = h.socketTab.get(a.Port)
+		switch {
+		case sk == nil:
+			sk = &socket{host: h, port: a.Port, zone: a.Zone, shared: shared}
+			h.socketTab.set(a.Port, sk)
+
+		// join acceptor group of shared listeners
+		case shared && sk.shared && sk.conn == nil && len(sk.listenerv) > 0:
+			// ok
+
+		default:
+			return nil, ErrAddrAlreadyUsed
+		}
+	}
+
+	// create listener under socket
+	l := &listener{
+		socket:   sk,
+		dialq:    make(chan dialReq),
+		overflow: opt.Overflow,
+		down:     make(chan struct{}),
+	}
+	if opt.Backlog > 0 {
+		l.backlog = make(chan struct{}, opt.Backlog)
+	}
+	if rl != nil {
+		l.dialq = rl.dialq
+		l.dialedLocal = true
+	}
+	sk.listenerv = append(sk.listenerv, l)
+	netladdr = sk.addr()
+
+	return l, nil
+}
+
+// shutdown shutdowns the listener.
+//
+// It interrupts all currently in-flight calls to Accept, but does not
+// unregister listener from host's socket map.
+func (l *listener) shutdown() {
+	l.downOnce.Do(func() {
+		close(l.down)
 	})
+}
+
+// Close closes the listener.
+//
+// It interrupts all currently in-flight calls to Accept.
+func (l *listener) Close() error {
+	l.shutdown()
+	l.unregister()
 	return nil
 }
 
+// GracefulCloser is implemented by listeners created via Host.Listen* .
+type GracefulCloser interface {
+	// CloseGraceful closes the listener, but lets already queued dials to be accepted.
+	//
+	// New dials to the listener are refused right away, while dials that
+	// were already queued to the listener can still be delivered by Accept
+	// calls. CloseGraceful returns after all such dials are accepted, or
+	// canceled by dialers, and after that the listener is closed.
+	//
+	// If ctx is canceled before queued dials are drained, the listener is
+	// closed right away - remaining queued dials are refused - and ctx error
+	// is returned.
+	CloseGraceful(ctx context.Context) error
+}
+
+// CloseGraceful implements GracefulCloser.
+func (l *listener) CloseGraceful(ctx context.Context) error {
+	l.unregister()
+
+	drained := make(chan struct{})
+	go func() {
+		l.inflight.Wait()
+		close(drained)
+	}()
+
+	var err error
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	l.shutdown()
+
+	// after shutdown remaining dials complete promptly
+	<-drained
+	return err
+}
+
+// unregister unregisters the listener from host's socket map.
+//
+// Dials that already found the listener are not affected.
+func (l *listener) unregister() {
+	l.closeOnce.Do(func() {
+		sk := l.socket
+		h := sk.host
+		h.subnet.logEvent("close", l.Addr().String(), nil)
+
+		h.sockMu.Lock()
+		defer h.sockMu.Unlock()
+
+		for i, __ := range sk.listenerv {
+			if __ == l {
+				sk.listenerv = append(sk.listenerv[:i], sk.listenerv[i+1:]...)
+				break
+			}
+		}
+		if sk.empty() {
+			h.socketTab.set(sk.port, nil)
+		}
+	})
+}
+
+// AcceptFilterer is implemented by listeners created via Host.Listen* .
+type AcceptFilterer interface {
+	// SetAcceptFilter sets filter for incoming dials.
+	//
+	// A dial from src, for which filter returns error, is refused with
+	// that error without being queued to Accept. nil filter accepts all dials.
+	SetAcceptFilter(filter func(src *Addr) error)
+}
+
+// SetAcceptFilter implements AcceptFilterer.
+func (l *listener) SetAcceptFilter(filter func(src *Addr) error) {
+	h := l.socket.host
+	h.sockMu.Lock()
+	defer h.sockMu.Unlock()
+	l.acceptFilter = filter
+}
+
+// AcceptResult is result of one Accept delivered via AcceptChan.
+type AcceptResult struct {
+	Conn net.Conn
+	Err  error
+}
+
+// AcceptChanner is implemented by listeners created via Host.Listen* .
+type AcceptChanner interface {
+	// AcceptChan returns channel via which accepted connections are delivered.
+	//
+	// It allows servers to select on several listeners and other events
+	// simultaneously. The channel is closed after the listener is closed.
+	//
+	// Accept should not be called directly on a listener whose AcceptChan
+	// was used, as the two would compete for incoming connections.
+	AcceptChan() <-chan AcceptResult
+}
+
+// AcceptChan implements AcceptChanner.
+func (l *listener) AcceptChan() <-chan AcceptResult {
+	l.acceptOnce.Do(func() {
+		l.acceptc = make(chan AcceptResult)
+		go l.serveAcceptChan()
+	})
+	return l.acceptc
+}
+
+// serveAcceptChan accepts connections and delivers them to acceptc until the listener is closed.
+func (l *listener) serveAcceptChan() {
+	defer close(l.acceptc)
+	for {
+		conn, err := l.Accept(context.Background())
+		if err != nil && ready(l.down) {
+			return
+		}
+
+		select {
+		case l.acceptc <- AcceptResult{conn, err}:
+			// ok
+
+		case <-l.down:
+			if conn != nil {
+				conn.Close() // ignore err
+			}
+			return
+		}
+	}
+}
+
 // Accept tries to connect to Dial called with addr corresponding to our listener.
 func (l *listener) Accept(ctx context.Context) (_ net.Conn, err error) {
 	h := l.socket.host
 
 	defer func() {
 		if err != nil {
+			h.subnet.logEvent("accept", l.Addr().String(), err)
 			err = &net.OpError{Op: "accept", Net: h.Network(), Addr: l.Addr(), Err: err}
 		}
 	}()
@@ -515,90 +1314,175 @@ func (l *listener) Accept(ctx context.Context) (_ net.Conn, err error) {
 			// ok
 		}
 
-		// acceptor dials us - allocate empty socket so that we know accept address.
-		h.sockMu.Lock()
-		sk := h.allocFreeSocket()
-		h.sockMu.Unlock()
+		// the dialer is still in flight, so adding to inflight cannot race with CloseGraceful
+		l.inflight.Add(1)
+		c, err := l.accept(ctx, req)
+		l.inflight.Done()
+		if err == errAcceptRetry {
+			continue
+		}
+		return c, err
+	}
+}
 
-		// give acceptor feedback that we are accepting the connection.
-		ack := make(chan error)
-		req.resp <- &Accept{sk.addr(), ack}
+// errAcceptRetry is returned by accept when acceptor rejected the connection.
+var errAcceptRetry = errors.New("accept: retry")
 
-		// wait for ack from acceptor.
-		var noack error
-		select {
-		case <-ctx.Done():
-			noack = ctx.Err()
-		case <-l.down:
-			noack = l.errDown()
+// accept handles one dial request received by Accept.
+func (l *listener) accept(ctx context.Context, req dialReq) (_ net.Conn, err error) {
+	h := l.socket.host
 
-		case err = <-ack:
-			// ok
-		}
-		if noack != nil {
-			// acceptor was slow.
-			// we have to make sure we still receive on ack and
-			// close req.conn / unallocate the socket appropriately.
-			go func() {
-				err := <-ack
-				if err == nil {
-					// acceptor conveyed us the connection - close it
-					req.conn.Close()
-				}
-				h.sockMu.Lock()
-				h.socketv[sk.port] = nil
-				h.sockMu.Unlock()
-			}()
+	// acceptor dials us - allocate empty socket so that we know accept address.
+	h.sockMu.Lock()
+	sk := h.allocFreeSocket()
+	sk.zone = req.zone
+	h.sockMu.Unlock()
 
-			return nil, noack
-		}
+	// the address the accepted connection reports as local; it is conveyed
+	// to the dialer so that both ends agree on the connection addresses.
+	laddr := sk.addr()
+	if l.dialedLocal {
+		laddr = &Addr{Net: h.Network(), Host: h.name, Port: req.port, Zone: req.zone}
+	}
 
-		// we got feedback from acceptor
-		// if there is an error - unallocate the socket and continue waiting.
-		if err != nil {
+	// give acceptor feedback that we are accepting the connection.
+	ack := make(chan error)
+	req.resp <- &Accept{laddr, ack}
+
+	// wait for ack from acceptor.
+	var noack error
+	select {
+	case <-ctx.Done():
+		noack = ctx.Err()
+	case <-l.down:
+		noack = l.errDown()
+
+	case err = <-ack:
+		// ok
+	}
+	if noack != nil {
+		// acceptor was slow.
+		// we have to make sure we still receive on ack and
+		// close req.conn / unallocate the socket appropriately.
+		go func() {
+			err := <-ack
+			if err == nil {
+				// acceptor conveyed us the connection - close it
+				req.conn.Close()
+			}
 			h.sockMu.Lock()
-			h.socketv[sk.port] = nil
+			h.socketTab.set(sk.port, nil)
 			h.sockMu.Unlock()
-			continue
-		}
+		}()
 
-		// all ok - allocate conn, bind it to socket and we are done.
-		c := &conn{socket: sk, peerAddr: req.from, Conn: req.conn}
+		return nil, noack
+	}
+
+	// we got feedback from acceptor
+	// if there is an error - unallocate the socket and let Accept continue waiting.
+	if err != nil {
 		h.sockMu.Lock()
-		sk.conn = c
+		h.socketTab.set(sk.port, nil)
 		h.sockMu.Unlock()
+		return nil, errAcceptRetry
+	}
 
-		return c, nil
+	// all ok - allocate conn, bind it to socket and we are done.
+	c := &conn{socket: sk, peerAddr: req.from, Conn: req.conn, downc: make(chan struct{})}
+	if l.dialedLocal {
+		c.laddr = laddr
 	}
+	h.sockMu.Lock()
+	sk.conn = c
+	h.sockMu.Unlock()
+
+	h.subnet.logEvent("accept", fmt.Sprintf("%s->%s", req.from, sk.addr()), nil)
+	return c, nil
 }
 
 // VNetAccept implements Notifier by accepting or rejecting incoming connection.
 func (nn *notifier) VNetAccept(ctx context.Context, src, dst *Addr, netconn net.Conn) (*Accept, error) {
 	n := nn.subnet
+	accept, err := nn.vnetAccept(ctx, src, dst, netconn)
+	if err != nil && ctx.Err() == nil {
+		n.hostMu.Lock()
+		refuseLog := n.refuseLog
+		n.hostMu.Unlock()
+		if refuseLog != nil {
+			refuseLog(src, dst, err)
+		}
+	}
+	return accept, err
+}
+
+func (nn *notifier) vnetAccept(ctx context.Context, src, dst *Addr, netconn net.Conn) (*Accept, error) {
+	n := nn.subnet
 
 	n.hostMu.Lock()
 	host := n.hostMap[dst.Host]
+	nat := n.nat
 	n.hostMu.Unlock()
 	if host == nil {
 		return nil, &net.AddrError{Err: "no such host", Addr: dst.String()}
 	}
 
+	if nat != nil {
+		if natsrc := nat(src); natsrc != nil {
+			src = natsrc
+		}
+	}
+
 	host.sockMu.Lock()
 
-	if dst.Port >= len(host.socketv) {
+	if host.partIn {
 		host.sockMu.Unlock()
 		return nil, ErrConnRefused
 	}
 
-	sk := host.socketv[dst.Port]
-	if sk == nil || sk.listener == nil {
+	sk := host.socketTab.get(dst.Port)
+	if sk == nil || len(sk.listenerv) == 0 {
 		host.sockMu.Unlock()
 		return nil, ErrConnRefused
 	}
 
-	// there is listener corresponding to dst - let's connect it
-	l := sk.listener
+	// there is listener corresponding to dst - let's connect it.
+	// if there are several listeners - pick them in round-robin order.
+	l := sk.listenerv[sk.nextl % len(sk.listenerv)]
+	sk.nextl++
+	filter := l.acceptFilter
+	l.inflight.Add(1)
 	host.sockMu.Unlock()
+	defer l.inflight.Done()
+
+	if filter != nil {
+		err := filter(src)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// enter backlog of the listener
+	if l.backlog != nil {
+		select {
+		case l.backlog <- struct{}{}:
+			// ok
+		default:
+			if l.overflow == Refuse {
+				return nil, ErrConnRefused
+			}
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-l.down:
+				return nil, ErrConnRefused
+			case l.backlog <- struct{}{}:
+				// ok
+			}
+		}
+		defer func() {
+			<-l.backlog
+		}()
+	}
 
 	resp := make(chan *Accept)
 	select {
@@ -608,7 +1492,7 @@ func (nn *notifier) VNetAccept(ctx context.Context, src, dst *Addr, netconn net.
 	case <-l.down:
 		return nil, ErrConnRefused
 
-	case l.dialq <- dialReq{from: src, conn: netconn, resp: resp}:
+	case l.dialq <- dialReq{from: src, port: dst.Port, zone: dst.Zone, conn: netconn, resp: resp}:
 		return <-resp, nil
 	}
 }
@@ -617,7 +1501,64 @@ func (nn *notifier) VNetAccept(ctx context.Context, src, dst *Addr, netconn net.
 // Dial dials address on the network.
 //
 // It tries to connect to Accept called on listener corresponding to addr.
-func (h *Host) Dial(ctx context.Context, addr string) (_ net.Conn, err error) {
+// The listener can be also on the host itself.
+func (h *Host) Dial(ctx context.Context, addr string) (net.Conn, error) {
+	return h.dial(ctx, nil, func() (*Addr, error) {
+		return h.parseAddr(addr)
+	})
+}
+
+// DialDeadline is like Dial but fails with timeout error if connection is not
+// established by deadline.
+//
+// The deadline is tracked by subnetwork clock - see SetClock. The error
+// returned on timeout has context.DeadlineExceeded cause.
+func (h *Host) DialDeadline(ctx context.Context, addr string, deadline time.Time) (net.Conn, error) {
+	clock := h.subnet.getClock()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var expired int32 // 1 after deadline passes
+	timeout := clock.After(deadline.Sub(clock.Now()))
+	go func() {
+		select {
+		case <-timeout:
+			atomic.StoreInt32(&expired, 1)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	conn, err := h.Dial(ctx, addr)
+	if err != nil && atomic.LoadInt32(&expired) != 0 {
+		if e, ok := err.(*net.OpError); ok {
+			e.Err = context.DeadlineExceeded
+		}
+	}
+	return conn, err
+}
+
+// DialAddr dials pre-parsed address dst on the network.
+//
+// It is similar to Dial but avoids string -> Addr round-trip.
+// dst.Net must be the same as network of the host.
+func (h *Host) DialAddr(ctx context.Context, dst *Addr) (net.Conn, error) {
+	var netdst net.Addr
+	if dst != nil {
+		netdst = dst
+	}
+	return h.dial(ctx, netdst, func() (*Addr, error) {
+		return h.checkAddr(dst)
+	})
+}
+
+// dial implements Dial and DialAddr.
+//
+// The address to dial is provided by getDst, which is called after the
+// socket is allocated, so that the socket is reported in errors about
+// invalid address too. netdst, if !nil, is reported in such errors as the
+// address being dialed.
+func (h *Host) dial(ctx context.Context, netdst net.Addr, getDst func() (*Addr, error)) (_ net.Conn, err error) {
 	// allocate socket in empty state early, so we can see in the error who
 	// tries to dial.
 	h.sockMu.Lock()
@@ -626,13 +1567,13 @@ func (h *Host) Dial(ctx context.Context, addr string) (_ net.Conn, err error) {
 	defer func() {
 		if err != nil {
 			h.sockMu.Lock()
-			h.socketv[sk.port] = nil
+			h.socketTab.set(sk.port, nil)
 			h.sockMu.Unlock()
 		}
 	}()
 
-	var netdst net.Addr
 	defer func() {
+		h.subnet.logEvent("dial", fmt.Sprintf("%s->%v", sk.addr(), netdst), err)
 		if err != nil {
 			err = &net.OpError{Op: "dial", Net: h.Network(), Source: sk.addr(), Addr: netdst, Err: err}
 		}
@@ -640,12 +1581,24 @@ func (h *Host) Dial(ctx context.Context, addr string) (_ net.Conn, err error) {
 	}()
 
 
-	dst, err := h.parseAddr(addr)
+	dst, err := getDst()
 	if err != nil {
 		return nil, err
 	}
 	netdst = dst
 
+	// port 0 is used only to request autobind on listen
+	if dst.Port == 0 {
+		return nil, ErrInvalidPort
+	}
+
+	h.sockMu.Lock()
+	partOut := h.partOut
+	h.sockMu.Unlock()
+	if partOut {
+		return nil, ErrUnreachable
+	}
+
 	n := h.subnet
 
 	// cancel on host shutdown
@@ -672,7 +1625,7 @@ func (h *Host) Dial(ctx context.Context, addr string) (_ net.Conn, err error) {
 	}
 
 	// handshake performed ok - we are done.
-	c := &conn{socket: sk, peerAddr: acceptAddr, Conn: netconn}
+	c := &conn{socket: sk, peerAddr: acceptAddr, Conn: netconn, downc: make(chan struct{})}
 	h.sockMu.Lock()
 	sk.conn = c
 	h.sockMu.Unlock()
@@ -680,14 +1633,136 @@ func (h *Host) Dial(ctx context.Context, addr string) (_ net.Conn, err error) {
 	return c, nil
 }
 
+// PooledDial is like Dial but reuses idle connection to addr, if there is one.
+//
+// Connections become idle when they are returned to the pool via PooledClose.
+// Pooled connections are reused as is: it is the caller responsibility to
+// return to the pool only connections that are ready to be used again.
+func (h *Host) PooledDial(ctx context.Context, addr string) (net.Conn, error) {
+	dst, err := h.parseAddr(addr)
+	if err != nil {
+		return nil, &net.OpError{Op: "dial", Net: h.Network(), Err: err}
+	}
+	key := dst.String()
+
+	for {
+		c := h.poolGet(key)
+		if c == nil {
+			break
+		}
+		if !c.IsDown() {
+			return c, nil
+		}
+		c.Close() // ignore err
+	}
+
+	c, err := h.DialAddr(ctx, dst)
+	if err != nil {
+		return nil, err
+	}
+	c.(*conn).poolKey = key
+	return c, nil
+}
+
+// PooledClose returns connection c, created via PooledDial, to the pool of
+// idle connections instead of closing it.
+//
+// c is closed instead if it was not created via PooledDial on this host, if
+// it is already shut down, or if there are already maximum allowed idle
+// connections to the same address. See SetPoolLimits.
+func (h *Host) PooledClose(c net.Conn) error {
+	vc, ok := c.(*conn)
+	if !(ok && vc.poolKey != "" && vc.socket.host == h && !vc.IsDown()) {
+		return c.Close()
+	}
+
+	h.poolMu.Lock()
+	defer h.poolMu.Unlock()
+
+	idlev := h.pool[vc.poolKey]
+	if len(idlev) >= h.poolMaxIdle || ready(h.down) {
+		return vc.Close()
+	}
+	if h.pool == nil {
+		h.pool = make(map[string][]*conn)
+	}
+	h.pool[vc.poolKey] = append(idlev, vc)
+	if h.poolIdleTimeout > 0 {
+		vc.idleTimer = time.AfterFunc(h.poolIdleTimeout, func() {
+			h.poolExpire(vc)
+		})
+	}
+	return nil
+}
+
+// SetPoolLimits sets limits for pool of idle connections of the host.
+//
+// At most maxIdle idle connections are kept per dialed address, and idle
+// connection is closed after it was not reused for idleTimeout. Zero
+// idleTimeout means idle connections are kept until the host is closed.
+// By default maxIdle=2 and idleTimeout=0.
+func (h *Host) SetPoolLimits(maxIdle int, idleTimeout time.Duration) {
+	h.poolMu.Lock()
+	defer h.poolMu.Unlock()
+	h.poolMaxIdle = maxIdle
+	h.poolIdleTimeout = idleTimeout
+}
+
+// poolGet takes the most recently pooled idle connection to dialed address key out of the pool.
+func (h *Host) poolGet(key string) *conn {
+	h.poolMu.Lock()
+	defer h.poolMu.Unlock()
+
+	idlev := h.pool[key]
+	if len(idlev) == 0 {
+		return nil
+	}
+	c := idlev[len(idlev)-1]
+	h.pool[key] = idlev[:len(idlev)-1]
+	if c.idleTimer != nil {
+		c.idleTimer.Stop()
+		c.idleTimer = nil
+	}
+	return c
+}
+
+// poolExpire closes idle connection c after its idle timeout.
+func (h *Host) poolExpire(c *conn) {
+	h.poolMu.Lock()
+	idlev := h.pool[c.poolKey]
+	found := false
+	for i, __ := range idlev {
+		if __ == c {
+			h.pool[c.poolKey] = append(idlev[:i], idlev[i+1:]...)
+			c.idleTimer = nil
+			found = true
+			break
+		}
+	}
+	h.poolMu.Unlock()
+
+	// c was already taken out of the pool by PooledDial
+	if !found {
+		return
+	}
+	c.Close() // ignore err
+}
+
 // ---- conn ----
 
 // shutdown closes underlying network connection.
-func (c *conn) shutdown() {
+func (c *conn) shutdown() error {
+	return c._shutdown(net.Conn.Close)
+}
+
+// _shutdown closes underlying network connection via closeConn.
+func (c *conn) _shutdown(closeConn func(net.Conn) error) error {
 	c.downOnce.Do(func() {
 		atomic.StoreUint32(&c.down, 1)
-		c.errClose = c.Conn.Close()
+		close(c.downc)
+		c.errClose = closeConn(c.Conn)
 	})
+	return c.errClose
 }
 
 // Close closes network endpoint and unregisters conn from Host.
@@ -698,26 +1773,44 @@ func (c *conn) Close() error {
 	c.closeOnce.Do(func() {
 		sk := c.socket
 		h := sk.host
+		h.subnet.logEvent("close", fmt.Sprintf("%s->%s", sk.addr(), c.peerAddr), c.errClose)
 
 		h.sockMu.Lock()
 		defer h.sockMu.Unlock()
 
 		sk.conn = nil
 		if sk.empty() {
-			h.socketv[sk.port] = nil
+			h.socketTab.set(sk.port, nil)
 		}
 	})
 
 	return c.errClose
 }
 
+// AbortiveClose implements xnet.AbortCloser .
+//
+// It is like Close, but underlying network connection is closed via
+// xnet.AbortiveClose, so that the peer observes connection reset.
+func (c *conn) AbortiveClose() error {
+	c._shutdown(xnet.AbortiveClose)
+	return c.Close()
+}
+
 
 // Read implements net.Conn .
 //
 // it delegates the read to underlying net.Conn but amends error if it was due
-// to conn shutdown.
-func (c *conn) Read(p []byte) (int, error) {
-	n, err := c.Conn.Read(p)
+// to conn shutdown. The read is limited by host rx budget.
+func (c *conn) Read(p []byte) (n int, err error) {
+	h := c.socket.host
+	quota := reserveBudget(&h.rxBudget, len(p))
+	if quota == 0 && len(p) != 0 {
+		err = ErrQuotaExceeded
+	} else {
+		n, err = c.Conn.Read(p[:quota])
+		refundBudget(&h.rxBudget, quota - n)
+	}
+	atomic.AddInt64(&h.subnet.nread, int64(n))
 	if err != nil && err != io.EOF {
 		if !errIsTimeout(err) {
 			// an error that might be due to shutdown
@@ -740,9 +1833,63 @@ func (c *conn) Read(p []byte) (int, error) {
 // Write implements net.Conn .
 //
 // it delegates the write to underlying net.Conn but amends error if it was due
-// to conn shutdown.
-func (c *conn) Write(p []byte) (int, error) {
-	n, err := c.Conn.Write(p)
+// to conn shutdown. The write is split into segments if subnetwork has
+// segment size set. In stepping mode every segment waits for Step. The write
+// is limited by host tx budget. The data is passed through subnetwork payload
+// filter, if installed.
+func (c *conn) Write(p []byte) (n int, err error) {
+	filter := c.socket.host.subnet.getPayloadFilter()
+	if filter == nil {
+		return c.write(p)
+	}
+
+	data := filter(c.socket.addr(), c.peerAddr, append([]byte(nil), p...))
+	if len(data) == 0 {
+		return len(p), nil // dropped
+	}
+	n, err = c.write(data)
+	if err == nil || n > len(p) {
+		n = len(p)
+	}
+	return n, err
+}
+
+// write performs Write of p without payload filtering.
+func (c *conn) write(p []byte) (n int, err error) {
+	h := c.socket.host
+	subnet := h.subnet
+	seg := int(atomic.LoadInt64(&subnet.segSize))
+
+	// limit the write to what is left in host budget
+	quota := reserveBudget(&h.txBudget, len(p))
+	overQuota := (quota < len(p))
+	p = p[:quota]
+
+	for len(p) > 0 || !overQuota {
+		chunk := p
+		if seg > 0 && len(chunk) > seg {
+			chunk = chunk[:seg]
+		}
+		var m int
+		var stepped func()
+		stepped, err = c.stepWait()
+		if err != nil {
+			break
+		}
+		m, err = c.Conn.Write(chunk)
+		stepped()
+		atomic.AddInt64(&subnet.nwritten, int64(m))
+		n += m
+		p = p[m:]
+		if err != nil || len(p) == 0 {
+			break
+		}
+	}
+
+	refundBudget(&h.txBudget, quota - n)
+	if err == nil && overQuota {
+		err = ErrQuotaExceeded
+	}
 	if err != nil {
 		if !errIsTimeout(err) {
 			err = c.errOrDown(err)
@@ -759,11 +1906,56 @@ func (c *conn) Write(p []byte) (int, error) {
 	return n, err
 }
 
+// stepWait waits for Step to let next write on c proceed if subnetwork is in
+// stepping mode.
+//
+// On success returned stepped must be called after the write is complete.
+func (c *conn) stepWait() (stepped func(), err error) {
+	h := c.socket.host
+	n := h.subnet
+	if atomic.LoadUint32(&n.stepping) == 0 {
+		return func() {}, nil
+	}
+
+	w := &stepWaiter{release: make(chan struct{}), done: make(chan struct{})}
+	stepped = func() { close(w.done) }
+	n.stepMu.Lock()
+	n.stepq = append(n.stepq, w)
+	close(n.stepReady)
+	n.stepReady = make(chan struct{})
+	n.stepMu.Unlock()
+
+	select {
+	case <-w.release:
+		return stepped, nil
+	case <-n.down:
+		err = ErrNetDown
+	case <-h.down:
+		err = ErrHostDown
+	case <-c.downc:
+		err = ErrSockDown
+	}
+
+	// dequeue, or, if Step has already released us, tell it we are done
+	n.stepMu.Lock()
+	defer n.stepMu.Unlock()
+	for i, w_ := range n.stepq {
+		if w_ == w {
+			n.stepq = append(n.stepq[:i], n.stepq[i+1:]...)
+			return nil, err
+		}
+	}
+	stepped()
+	return nil, err
+}
 
 // LocalAddr implements net.Conn.
 //
 // it returns virtnet address of local end of connection.
 func (c *conn) LocalAddr() net.Addr {
+	if c.laddr != nil {
+		return c.laddr
+	}
 	return c.socket.addr()
 }
 
@@ -774,49 +1966,463 @@ func (c *conn) RemoteAddr() net.Addr {
 	return c.peerAddr
 }
 
+// SyscallConn implements syscall.Conn .
+//
+// It delegates to underlying net.Conn if it supports syscall.Conn, e.g. for
+// lonet. Otherwise, e.g. for pipenet, error with ErrNoRawConn cause is returned.
+func (c *conn) SyscallConn() (syscall.RawConn, error) {
+	sc, ok := c.Conn.(syscall.Conn)
+	if !ok {
+		return nil, &net.OpError{Op: "raw-conn", Net: c.socket.host.Network(),
+			Source: c.LocalAddr(), Addr: c.RemoteAddr(), Err: ErrNoRawConn}
+	}
+	return sc.SyscallConn()
+}
+
+// Pipe returns connected pair of connections in between hostA and hostB.
+//
+// The hosts are created if they do not exist yet. The connection is
+// established via regular dial/accept, so cA and cB have addresses as if
+// hostA dialed hostB, but without the need for user to setup a listener.
+func (n *SubNetwork) Pipe(ctx context.Context, hostA, hostB string) (cA, cB net.Conn, err error) {
+	defer xerr.Contextf(&err, "virtnet %q: pipe %q %q", n.network, hostA, hostB)
+
+	hA, err := n.hostOrNew(ctx, hostA)
+	if err != nil {
+		return nil, nil, err
+	}
+	hB, err := n.hostOrNew(ctx, hostB)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	l, err := hB.Listen(ctx, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer l.Close()
+
+	wg := xsync.NewWorkGroup(ctx)
+	wg.Go(func(ctx context.Context) (err error) {
+		cB, err = l.Accept(ctx)
+		return err
+	})
+	cA, err = hA.Dial(ctx, l.Addr().String())
+	if err != nil {
+		l.Close() // interrupts Accept
+		wg.Wait()
+		if cB != nil {
+			cB.Close()
+		}
+		return nil, nil, err
+	}
+	err = wg.Wait()
+	if err != nil {
+		cA.Close()
+		return nil, nil, err
+	}
+
+	return cA, cB, nil
+}
+
+// hostOrNew returns host with given name creating it if needed.
+func (n *SubNetwork) hostOrNew(ctx context.Context, name string) (*Host, error) {
+	host := n.Host(name)
+	if host != nil {
+		return host, nil
+	}
+	host, err := n.NewHost(ctx, name)
+	if errors.Is(err, ErrHostDup) {
+		// created by someone else in parallel to us
+		host = n.Host(name)
+		if host != nil {
+			err = nil
+		}
+	}
+	return host, err
+}
+
+// Broadcast sends payload from host fromHost to port on every other host of
+// the subnetwork.
+//
+// Hosts are dialed concurrently. For every such host, returned map contains
+// the error of delivering payload to it, or nil on success. For example hosts
+// that do not listen on port get an error with ErrConnRefused cause.
+//
+// The error is returned only if broadcasting could not be performed at all.
+func (n *SubNetwork) Broadcast(ctx context.Context, fromHost string, port int, payload []byte) (_ map[string]error, err error) {
+	defer xerr.Contextf(&err, "virtnet %q: broadcast from %q", n.network, fromHost)
+
+	n.hostMu.Lock()
+	from := n.hostMap[fromHost]
+	var dstv []string
+	for name := range n.hostMap {
+		if name != fromHost {
+			dstv = append(dstv, name)
+		}
+	}
+	n.hostMu.Unlock()
+
+	if from == nil {
+		return nil, &net.AddrError{Err: "no such host", Addr: fromHost}
+	}
+
+	var mu sync.Mutex
+	errMap := make(map[string]error, len(dstv))
+
+	wg := xsync.NewWorkGroup(ctx)
+	for _, dst := range dstv {
+		dst := dst
+		wg.Go(func(ctx context.Context) error {
+			err := send(ctx, from, &Addr{Net: n.network, Host: dst, Port: port}, payload)
+			mu.Lock()
+			errMap[dst] = err
+			mu.Unlock()
+			return nil // errors are reported per-host
+		})
+	}
+	wg.Wait()
+
+	return errMap, nil
+}
+
+// send dials dst from host and writes payload to established connection.
+func send(ctx context.Context, host *Host, dst *Addr, payload []byte) error {
+	c, err := host.DialAddr(ctx, dst)
+	if err != nil {
+		return err
+	}
+
+	// interrupt write on ctx cancel
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			c.Close()
+		}
+	}()
+
+	_, err = c.Write(payload)
+	if err != nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	return xerr.First(err, c.Close())
+}
+
+// ServeEcho starts echo server on the host.
+//
+// It listens on laddr similarly to Listen and spawns accept loop that, for
+// every accepted connection, echoes received bytes back until ctx is canceled
+// or returned listener is closed. When ctx is canceled the listener is closed
+// too. It is handy as scaffolding in tests.
+//
+// Returned listener must not be used to Accept connections.
+// Its Close stops the server and returns error, if any, from the serve loop
+// and from echoing on individual connections. Peer closing its side of a
+// connection is not an error.
+func (h *Host) ServeEcho(ctx context.Context, laddr string) (xnet.Listener, error) {
+	l, err := h.Listen(ctx, laddr)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	wg := xsync.NewWorkGroup(ctx)
+	e := &echoListener{Listener: l, serveWG: wg, serveCancel: cancel}
+	wg.Go(e.serve)
+	wg.Go(func(ctx context.Context) error {
+		// close the listener on ctx cancel so that nothing is accepted anymore
+		<-ctx.Done()
+		e.closeListener() // error is reported by Close
+		return nil
+	})
+	return e, nil
+}
+
+// echoListener is the listener returned by ServeEcho.
+type echoListener struct {
+	xnet.Listener
+	serveWG     *xsync.WorkGroup // accept and echo loops are run under serveWG
+	serveCancel func()           // Close calls serveCancel to request serve loops shutdown
+
+	closeOnce sync.Once
+	closeErr  error // error from closing Listener
+
+	echoMu   sync.Mutex
+	echoErrv xerr.Errorv // errors from echo loops
+}
+
+var errEchoAccept = errors.New("accept on echo listener")
+
+// serve accepts connections and spawns echo loop for each of them.
+//
+// errors on individual connections only terminate that connection, not the
+// whole server. They are reported by Close.
+func (e *echoListener) serve(ctx context.Context) error {
+	for {
+		c, err := e.Listener.Accept(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil // canceled
+			}
+			return err
+		}
+
+		done := make(chan struct{})
+		e.serveWG.Go(func(ctx context.Context) error {
+			// interrupt IO on cancel
+			select {
+			case <-ctx.Done():
+				c.Close() // ignore err
+			case <-done:
+			}
+			return nil
+		})
+		e.serveWG.Go(func(ctx context.Context) error {
+			defer close(done)
+			err := echo(ctx, c)
+			if err != nil {
+				e.echoMu.Lock()
+				e.echoErrv.Append(err)
+				e.echoMu.Unlock()
+			}
+			return nil
+		})
+	}
+}
+
+// echo sends back to c everything received from it until EOF, error or ctx cancel.
+//
+// c is closed on return. Error, unless it is due to ctx cancel, is returned.
+func echo(ctx context.Context, c net.Conn) error {
+	_, err := io.Copy(c, c)
+	c.Close() // ignore err
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+// closeListener closes underlying listener once.
+func (e *echoListener) closeListener() error {
+	e.closeOnce.Do(func() {
+		e.closeErr = e.Listener.Close()
+	})
+	return e.closeErr
+}
+
+func (e *echoListener) Accept(ctx context.Context) (net.Conn, error) {
+	return nil, &net.OpError{Op: "accept", Net: e.Addr().Network(), Addr: e.Addr(), Err: errEchoAccept}
+}
+
+// Close stops echo server and closes the listener.
+func (e *echoListener) Close() error {
+	e.serveCancel()
+	var errv xerr.Errorv
+	errv.Appendif(e.serveWG.Wait())
+	errv.Appendif(e.closeListener())
+	e.echoMu.Lock()
+	errv = append(errv, e.echoErrv...)
+	e.echoMu.Unlock()
+	return errv.Err()
+}
+
+// Underlying returns network connection that virtnet connection c wraps.
+//
+// If c is connection returned by Host.Dial or by listener.Accept, the
+// connection created by network engine is returned. Otherwise - if c is not
+// a virtnet connection - (nil, false) is returned.
+func Underlying(c net.Conn) (net.Conn, bool) {
+	vc, ok := c.(*conn)
+	if !ok {
+		return nil, false
+	}
+	return vc.Conn, true
+}
+
+// SetAttr associates value val with key on virtnet connection c.
+//
+// Attributes are local to connection endpoint: they are not transmitted and
+// the peer does not see them. They can be used e.g. to correlate connections
+// in tests. If c is not a virtnet connection, SetAttr does nothing.
+func SetAttr(c net.Conn, key, val string) {
+	vc, ok := c.(*conn)
+	if !ok {
+		return
+	}
+	sk := vc.socket
+	sk.attrMu.Lock()
+	defer sk.attrMu.Unlock()
+	if sk.attrv == nil {
+		sk.attrv = make(map[string]string)
+	}
+	sk.attrv[key] = val
+}
+
+// GetAttr returns value associated with key on virtnet connection c via SetAttr.
+func GetAttr(c net.Conn, key string) (val string, ok bool) {
+	vc, ok := c.(*conn)
+	if !ok {
+		return "", false
+	}
+	sk := vc.socket
+	sk.attrMu.Lock()
+	defer sk.attrMu.Unlock()
+	val, ok = sk.attrv[key]
+	return val, ok
+}
+
 // ----------------------------------------
 
+// maxPort is the maximum port autobind picks with DelayedReuse before
+// starting to reuse freed ports.
+const maxPort = 1<<16 - 1
+
 // allocFreeSocket finds first free port and allocates socket entry for it.
 //
-// must be called with SubNetwork.mu held.
+// must be called with Host.sockMu held.
 func (h *Host) allocFreeSocket() *socket {
-	// find first free port
-	port := 1 // never allocate port 0 - it is used for autobind on listen only
-	for ; port < len(h.socketv); port++ {
-		if h.socketv[port] == nil {
-			break
+	port := 0
+	if h.reuse == DelayedReuse {
+		port = h.allocDelayed()
+	}
+	if port == 0 {
+		// find first free port
+		port = 1 // never allocate port 0 - it is used for autobind on listen only
+		for h.socketTab.get(port) != nil {
+			port++
+		}
+	}
+
+	sk := &socket{host: h, port: port}
+	h.socketTab.set(port, sk)
+	return sk
+}
+
+// allocDelayed finds free port according to DelayedReuse policy.
+//
+// It returns port above the last picked one, or, if ports up to maxPort are
+// exhausted, the port that was freed the earliest. 0 is returned if there is
+// no such port.
+//
+// must be called with Host.sockMu held.
+func (h *Host) allocDelayed() int {
+	port := h.nextPort
+	if port < 1 {
+		port = 1
+	}
+	for port <= h.maxPort && h.socketTab.get(port) != nil {
+		port++
+	}
+	if port <= h.maxPort {
+		h.nextPort = port + 1
+		return port
+	}
+
+	// fresh ports are exhausted - reuse freed ones
+	t := &h.socketTab
+	for len(t.freeq) > 0 {
+		port, t.freeq = t.freeq[0], t.freeq[1:]
+		if t.get(port) == nil {
+			return port
+		}
+		// the port was bound again explicitly after it was freed
+	}
+	return 0
+}
+
+// socketTab is port -> socket table of a host.
+//
+// It is either dense - []socket indexed by port, or sparse - {} port -> socket.
+type socketTab struct {
+	v []*socket       // dense; [0] is always nil
+	m map[int]*socket // sparse; nil if dense
+
+	// ports in the order they were freed; maintained only if trackFree
+	trackFree bool
+	freeq     []int
+}
+
+// get returns socket bound to port, or nil.
+func (t *socketTab) get(port int) *socket {
+	if t.m != nil {
+		return t.m[port]
+	}
+	if port < 0 || port >= len(t.v) {
+		return nil
+	}
+	return t.v[port]
+}
+
+// set binds sk to port; sk=nil unbinds the port.
+func (t *socketTab) set(port int, sk *socket) {
+	if sk == nil && t.trackFree && t.get(port) != nil {
+		t.freeq = append(t.freeq, port)
+	}
+
+	if t.m != nil {
+		if sk == nil {
+			delete(t.m, port)
+		} else {
+			t.m[port] = sk
 		}
+		return
 	}
-	// if all busy it exits with port >= len(h.socketv)
 
 	// grow if needed
-	for port >= len(h.socketv) {
-		h.socketv = append(h.socketv, nil)
+	if sk != nil && port >= len(t.v) {
+		t.v = append(t.v, make([]*socket, port+1-len(t.v))...)
 	}
+	if port < len(t.v) {
+		t.v[port] = sk
+	}
+}
 
-	sk := &socket{host: h, port: port}
-	h.socketv[port] = sk
-	return sk
+// all returns all bound sockets.
+func (t *socketTab) all() []*socket {
+	var skv []*socket
+	if t.m != nil {
+		for _, sk := range t.m {
+			skv = append(skv, sk)
+		}
+		return skv
+	}
+	for _, sk := range t.v {
+		if sk != nil {
+			skv = append(skv, sk)
+		}
+	}
+	return skv
 }
 
-// empty checks whether socket's both conn and listener are all nil.
+// empty checks whether socket has neither conn nor listeners.
 func (sk *socket) empty() bool {
-	return sk.conn == nil && sk.listener == nil
+	return sk.conn == nil && len(sk.listenerv) == 0
 }
 
 // addr returns address corresponding to socket.
 func (sk *socket) addr() *Addr {
 	h := sk.host
-	return &Addr{Net: h.Network(), Host: h.name, Port: sk.port}
+	return &Addr{Net: h.Network(), Host: h.name, Port: sk.port, Zone: sk.zone}
 }
 
 // Network implements net.Addr .
 func (a *Addr) Network() string { return a.Net }
 
 // String implements net.Addr .
-func (a *Addr) String() string { return net.JoinHostPort(a.Host, strconv.Itoa(a.Port)) }
+func (a *Addr) String() string {
+	host := a.Host
+	if a.Zone != "" {
+		host += "%" + a.Zone
+	}
+	return net.JoinHostPort(host, strconv.Itoa(a.Port))
+}
 
 // ParseAddr parses addr into virtnet address for named network.
+//
+// The address has the form "host:port" or "host%zone:port".
 func ParseAddr(network, addr string) (*Addr, error) {
 	host, portstr, err := net.SplitHostPort(addr)
 	if err != nil {
@@ -826,7 +2432,11 @@ func ParseAddr(network, addr string) (*Addr, error) {
 	if err != nil || port < 0 {
 		return nil, &net.AddrError{Err: "invalid port", Addr: addr}
 	}
-	return &Addr{Net: network, Host: host, Port: port}, nil
+	zone := ""
+	if i := strings.Index(host, "%"); i != -1 {
+		host, zone = host[:i], host[i+1:]
+	}
+	return &Addr{Net: network, Host: host, Port: port, Zone: zone}, nil
 }
 
 // parseAddr parses addr into virtnet address from host point of view.
@@ -847,11 +2457,77 @@ func (h *Host) parseAddr(addr string) (*Addr, error) {
 	return a, nil
 }
 
+// checkAddr verifies pre-parsed virtnet address from host point of view.
+//
+// The address must be on the same network as the host. Similarly to parseAddr
+// empty host is resolved to the host itself - in such case a copy of a with
+// adjusted host is returned.
+func (h *Host) checkAddr(a *Addr) (*Addr, error) {
+	if a == nil {
+		return nil, &net.AddrError{Err: "no address"}
+	}
+	if a.Net != h.Network() {
+		return nil, &net.AddrError{Err: "network mismatch", Addr: a.Net + "/" + a.String()}
+	}
+	if a.Port < 0 {
+		return nil, &net.AddrError{Err: "invalid port", Addr: a.String()}
+	}
+
+	// local host if host name omitted
+	if a.Host == "" {
+		a = &Addr{Net: a.Net, Host: h.name, Port: a.Port, Zone: a.Zone}
+	}
+
+	return a, nil
+}
+
 // Addr returns address where listener is accepting incoming connections.
 func (l *listener) Addr() net.Addr {
 	return l.socket.addr()
 }
 
+// SubNetStats represents snapshot of SubNetwork statistics.
+type SubNetStats struct {
+	Hosts     int // number of hosts that are not closed
+	Listeners int // number of active listeners
+	Conns     int // number of active connections
+
+	BytesRead    int64 // cumulative bytes read by all connections
+	BytesWritten int64 // cumulative bytes written by all connections
+}
+
+// Stats returns current statistics of the subnetwork.
+//
+// Only listeners and connections on hosts that are not yet closed are counted
+// as active.
+func (n *SubNetwork) Stats() SubNetStats {
+	st := SubNetStats{
+		BytesRead:    atomic.LoadInt64(&n.nread),
+		BytesWritten: atomic.LoadInt64(&n.nwritten),
+	}
+
+	n.hostMu.Lock()
+	defer n.hostMu.Unlock()
+
+	for _, h := range n.hostMap {
+		if ready(h.down) {
+			continue
+		}
+		st.Hosts++
+
+		h.sockMu.Lock()
+		for _, sk := range h.socketTab.all() {
+			st.Listeners += len(sk.listenerv)
+			if sk.conn != nil {
+				st.Conns++
+			}
+		}
+		h.sockMu.Unlock()
+	}
+
+	return st
+}
+
 // Network returns full network name this subnetwork is part of.
 func (n *SubNetwork) Network() string { return n.network }
 
@@ -863,6 +2539,30 @@ func (h *Host) Name() string { return h.name }
 
 // ----------------------------------------
 
+// DownChecker is implemented by connections created via Host.Dial and listener.Accept .
+type DownChecker interface {
+	// IsDown returns whether the connection was shut down, e.g. closed
+	// by Close or due to shutdown of its host.
+	IsDown() bool
+}
+
+var _ DownChecker = (*conn)(nil)
+
+// IsDown returns whether subnetwork is no longer operational.
+func (n *SubNetwork) IsDown() bool {
+	return ready(n.down)
+}
+
+// IsDown returns whether host is no longer operational.
+func (h *Host) IsDown() bool {
+	return ready(h.down)
+}
+
+// IsDown implements DownChecker.
+func (c *conn) IsDown() bool {
+	return ready(c.downc)
+}
+
 // errDown returns appropriate error cause when h.down is found ready.
 func (h *Host) errDown() error {
 	switch {
@@ -21,9 +21,16 @@ package virtnet_test
 
 import (
 	"context"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"math/rand"
 	"net"
+	"runtime"
+	"sort"
 	"strings"
+	"sync"
+	"syscall"
 	"testing"
 	"time"
 
@@ -402,3 +409,1740 @@ func TestAutoClose(t0 *testing.T) {
 	assert.Eq(errors.Cause(err), ErrNetDown)
 	assert.Eq(err.Error(), "virtnet \"pipet\": new host \"δ\": network is down")
 }
+
+// TestDialListenAddr verifies DialAddr and ListenAddr that accept pre-parsed addresses.
+func TestDialListenAddr(t0 *testing.T) {
+	t := newTestNet(t0)
+	X := exc.Raiseif
+	assert := xtesting.Assert(t0)
+	bg := context.Background()
+
+	// listen with pre-parsed address; empty host means local host
+	l, err := t.hβ.ListenAddr(bg, &Addr{Net: "pipet", Host: "", Port: 5});  X(err)
+	assert.Eq(l.Addr(), &Addr{Net: "pipet", Host: "β", Port: 5})
+
+	// dial with pre-parsed address should behave the same as dial with string
+	wg := &errgroup.Group{}
+	wg.Go(func() error {
+		for i := 0; i < 2; i++ {
+			c, err := l.Accept(bg)
+			if err != nil {
+				return err
+			}
+			c.Close()
+		}
+		return nil
+	})
+
+	c1, err := t.hα.DialAddr(bg, &Addr{Net: "pipet", Host: "β", Port: 5});  X(err)
+	assert.Eq(c1.LocalAddr(), &Addr{Net: "pipet", Host: "α", Port: 3})
+	assert.Eq(c1.RemoteAddr().(*Addr).Host, "β")
+	X(c1.Close())
+
+	c2, err := t.hα.Dial(bg, "β:5");  X(err)
+	assert.Eq(c2.LocalAddr(), &Addr{Net: "pipet", Host: "α", Port: 3})
+	assert.Eq(c2.RemoteAddr().(*Addr).Host, "β")
+	X(c2.Close())
+
+	X(wg.Wait())
+
+	// address on another network is rejected
+	xdst := &Addr{Net: "pipeq", Host: "β", Port: 5}
+	c, err := t.hα.DialAddr(bg, xdst)
+	assert.Eq(c, nil)
+	assert.Eq(err, &net.OpError{Op: "dial", Net: "pipet",
+		Source: &Addr{Net: "pipet", Host: "α", Port: 3}, Addr: xdst,
+		Err: &net.AddrError{Err: "network mismatch", Addr: "pipeq/β:5"}})
+
+	l2, err := t.hα.ListenAddr(bg, xdst)
+	assert.Eq(l2, nil)
+	assert.Eq(err, &net.OpError{Op: "listen", Net: "pipet", Addr: xdst,
+		Err: &net.AddrError{Err: "network mismatch", Addr: "pipeq/β:5"}})
+
+	// nil address is rejected
+	c, err = t.hα.DialAddr(bg, nil)
+	assert.Eq(c, nil)
+	assert.Eq(err, &net.OpError{Op: "dial", Net: "pipet",
+		Source: &Addr{Net: "pipet", Host: "α", Port: 3},
+		Err: &net.AddrError{Err: "no address"}})
+
+	l2, err = t.hα.ListenAddr(bg, nil)
+	assert.Eq(l2, nil)
+	assert.Eq(err, &net.OpError{Op: "listen", Net: "pipet",
+		Err: &net.AddrError{Err: "no address"}})
+
+	// Dial reports who tries to dial also on address parse error
+	c, err = t.hα.Dial(bg, "β:port")
+	assert.Eq(c, nil)
+	e, ok := err.(*net.OpError)
+	if !(ok && e.Source != nil && e.Source.String() == "α:3" && e.Addr == nil) {
+		t.Fatalf("dial with bad address: %#v  ; want *net.OpError with Source=α:3", err)
+	}
+}
+
+// TestListenShared verifies that listeners created via ListenShared share
+// the port and get incoming connections in round-robin order.
+func TestListenShared(t0 *testing.T) {
+	t := newTestNet(t0)
+	X := exc.Raiseif
+	assert := xtesting.Assert(t0)
+	bg := context.Background()
+
+	hγ, err := t.net.NewHost(bg, "γ");  X(err)
+	l1, err := hγ.ListenShared(bg, ":1");  X(err)
+	l2, err := hγ.ListenShared(bg, ":1");  X(err)
+	assert.Eq(l1.Addr(), l2.Addr())
+
+	// shared and non-shared listeners cannot be mixed
+	_, err = hγ.Listen(bg, ":1")
+	assert.Eq(errors.Cause(err.(*net.OpError).Err), ErrAddrAlreadyUsed)
+	_, err = t.hα.ListenShared(bg, ":1")
+	assert.Eq(errors.Cause(err.(*net.OpError).Err), ErrAddrAlreadyUsed)
+
+	ctx, cancel := context.WithCancel(bg)
+	naccept := make([]int, 2)
+	wg := &errgroup.Group{}
+	for i, l := range []xnet.Listener{l1, l2} {
+		i, l := i, l
+		wg.Go(func() error {
+			for {
+				c, err := l.Accept(ctx)
+				if err != nil {
+					if ctx.Err() != nil {
+						return nil
+					}
+					return err
+				}
+				naccept[i]++
+				c.Close()
+			}
+		})
+	}
+
+	for i := 0; i < 4; i++ {
+		c, err := t.hα.Dial(bg, "γ:1");  X(err)
+		X(c.Close())
+	}
+
+	cancel()
+	X(wg.Wait())
+	assert.Eq(naccept, []int{2, 2})
+
+	// after one listener of the group is closed, the other continues to
+	// accept all incoming connections.
+	X(l1.Close())
+	wg.Go(func() error {
+		c, err := l2.Accept(bg)
+		if err != nil {
+			return err
+		}
+		return c.Close()
+	})
+	c, err := t.hα.Dial(bg, "γ:1");  X(err)
+	X(c.Close())
+	X(wg.Wait())
+
+	// when all listeners are closed the port is freed
+	X(l2.Close())
+	_, err = t.hα.Dial(bg, "γ:1")
+	assert.Eq(errors.Cause(err.(*net.OpError).Err), ErrConnRefused)
+}
+
+// TestStats verifies SubNetwork.Stats .
+func TestStats(t0 *testing.T) {
+	t := newTestNet(t0)
+	X := exc.Raiseif
+	assert := xtesting.Assert(t0)
+	bg := context.Background()
+
+	assert.Eq(t.net.Stats(), SubNetStats{Hosts: 2, Listeners: 2, Conns: 2})
+
+	wg := &errgroup.Group{}
+	wg.Go(func() error {
+		_, err := t.cαβ.Write([]byte("hello"))
+		return err
+	})
+	buf := make([]byte, 5)
+	_, err := io.ReadFull(t.cβα, buf);  X(err)
+	X(wg.Wait())
+
+	assert.Eq(t.net.Stats(), SubNetStats{Hosts: 2, Listeners: 2, Conns: 2,
+		BytesRead: 5, BytesWritten: 5})
+
+	hγ, err := t.net.NewHost(bg, "γ");  X(err)
+	_, err = hγ.Listen(bg, "");  X(err)
+	assert.Eq(t.net.Stats(), SubNetStats{Hosts: 3, Listeners: 3, Conns: 2,
+		BytesRead: 5, BytesWritten: 5})
+
+	X(t.cαβ.Close())
+	assert.Eq(t.net.Stats(), SubNetStats{Hosts: 3, Listeners: 3, Conns: 1,
+		BytesRead: 5, BytesWritten: 5})
+
+	X(t.hβ.Close())
+	assert.Eq(t.net.Stats(), SubNetStats{Hosts: 2, Listeners: 2, Conns: 0,
+		BytesRead: 5, BytesWritten: 5})
+
+	X(t.net.Close())
+	assert.Eq(t.net.Stats(), SubNetStats{BytesRead: 5, BytesWritten: 5})
+}
+
+// TestUnderlying verifies Underlying.
+func TestUnderlying(t0 *testing.T) {
+	t := newTestNet(t0)
+	assert := xtesting.Assert(t0)
+
+	// pipenet engine uses net.Pipe for connections
+	for _, c := range []net.Conn{t.cαβ, t.cβα} {
+		raw, ok := Underlying(c)
+		assert.Eq(ok, true)
+		assert.Eq(raw.LocalAddr().Network(), "pipe")
+	}
+
+	// non-virtnet connection
+	p1, p2 := net.Pipe()
+	defer p1.Close()
+	defer p2.Close()
+	raw, ok := Underlying(p1)
+	assert.Eq(ok, false)
+	assert.Eq(raw, nil)
+}
+
+// TestServeEcho verifies Host.ServeEcho .
+func TestServeEcho(t0 *testing.T) {
+	t := newTestNet(t0)
+	X := exc.Raiseif
+	assert := xtesting.Assert(t0)
+	bg := context.Background()
+
+	l, err := t.hβ.ServeEcho(bg, "");  X(err)
+	assert.Eq(l.Addr(), &Addr{Net: "pipet", Host: "β", Port: 3})
+
+	for i := 0; i < 2; i++ {
+		c, err := t.hα.Dial(bg, "β:3");  X(err)
+
+		data := []byte("hello world")
+		wg := &errgroup.Group{}
+		wg.Go(func() error {
+			_, err := c.Write(data)
+			return err
+		})
+		buf := make([]byte, len(data))
+		_, err = io.ReadFull(c, buf);  X(err)
+		X(wg.Wait())
+		assert.Eq(buf, data)
+		if i == 0 {
+			X(c.Close())
+		}
+		// second connection is left open - it is closed by l.Close
+	}
+
+	// echo listener does not allow Accept
+	_, err = l.Accept(bg)
+	assert.Eq(err != nil, true)
+
+	X(l.Close())
+	_, err = t.hα.Dial(bg, "β:3")
+	assert.Eq(errors.Cause(err.(*net.OpError).Err), ErrConnRefused)
+}
+
+// TestServeEchoCancel verifies that ServeEcho closes its listener on ctx cancel
+// and reports errors of echo loops via Close.
+func TestServeEchoCancel(t0 *testing.T) {
+	t := newTestNet(t0)
+	X := exc.Raiseif
+	assert := xtesting.Assert(t0)
+	bg := context.Background()
+
+	// ctx cancel -> the listener is closed and dials are refused
+	ctx, cancel := context.WithCancel(bg)
+	l, err := t.hβ.ServeEcho(ctx, "");  X(err)
+	cancel()
+	for {
+		c, err := t.hα.Dial(bg, l.Addr().String())
+		if err != nil {
+			assert.Eq(errors.Cause(err.(*net.OpError).Err), ErrConnRefused)
+			break
+		}
+		c.Close()
+		time.Sleep(time.Millisecond)
+	}
+	X(l.Close())
+
+	// error on echoed connection is reported by Close
+	l, err = t.hβ.ServeEcho(bg, "");  X(err)
+	t.hβ.SetByteBudget(0, -1)
+	c, err := t.hα.Dial(bg, l.Addr().String());  X(err)
+	_, err = c.Read(make([]byte, 1))
+	assert.Eq(err, io.EOF) // echo loop closed the connection on read error
+	X(c.Close())
+	err = l.Close()
+	if errors.Cause(err).(*net.OpError).Err != ErrQuotaExceeded {
+		t.Fatalf("close: %v  ; want %v", err, ErrQuotaExceeded)
+	}
+}
+
+// TestDialSelf verifies that a host can dial its own listener.
+func TestDialSelf(t0 *testing.T) {
+	t := newTestNet(t0)
+	X := exc.Raiseif
+	assert := xtesting.Assert(t0)
+	bg := context.Background()
+
+	wg := &errgroup.Group{}
+	var cs net.Conn
+	wg.Go(func() error {
+		c, err := t.lα.Accept(bg)
+		if err != nil {
+			return err
+		}
+		cs = c
+		return nil
+	})
+
+	cc, err := t.hα.Dial(bg, "α:1");  X(err)
+	X(wg.Wait())
+
+	assert.Eq(cc.LocalAddr(),  &Addr{Net: "pipet", Host: "α", Port: 3})
+	assert.Eq(cc.RemoteAddr(), &Addr{Net: "pipet", Host: "α", Port: 4})
+	assert.Eq(cs.LocalAddr(),  &Addr{Net: "pipet", Host: "α", Port: 4})
+	assert.Eq(cs.RemoteAddr(), &Addr{Net: "pipet", Host: "α", Port: 3})
+
+	// exchange data
+	data := []byte("hello self")
+	wg.Go(func() error {
+		_, err := cc.Write(data)
+		return err
+	})
+	buf := make([]byte, len(data))
+	_, err = io.ReadFull(cs, buf);  X(err)
+	X(wg.Wait())
+	assert.Eq(buf, data)
+
+	X(cc.Close())
+	X(cs.Close())
+}
+
+// TestSparsePorts verifies hosts with sparse socket table.
+func TestSparsePorts(t *testing.T) {
+	X := exc.Raiseif
+	assert := xtesting.Assert(t)
+	bg := context.Background()
+
+	vnet := pipenet.AsVirtNet(pipenet.New("s"))
+	defer func() {
+		X(vnet.Close())
+	}()
+	vnet.SparsePorts()
+
+	h, err := vnet.NewHost(bg, "α");  X(err)
+
+	l1, err := h.Listen(bg, ":1");  X(err)
+
+	// memory is proportional to #sockets, not to highest port.
+	// TotalAlloc is process-wide: measure with other goroutines mostly
+	// stopped and with bound well below 8MB that dense table would take.
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(1))
+	var m0, m1 runtime.MemStats
+	runtime.ReadMemStats(&m0)
+	lM, err := h.Listen(bg, ":1000000");  X(err)
+	runtime.ReadMemStats(&m1)
+	if alloc := m1.TotalAlloc - m0.TotalAlloc; alloc > 1<<20 {
+		t.Errorf("listen on port 1000000: allocated %d bytes", alloc)
+	}
+
+	assert.Eq(l1.Addr(), &Addr{Net: "pipes", Host: "α", Port: 1})
+	assert.Eq(lM.Addr(), &Addr{Net: "pipes", Host: "α", Port: 1000000})
+
+	// autobind picks lowest free port
+	l2, err := h.Listen(bg, "");  X(err)
+	assert.Eq(l2.Addr(), &Addr{Net: "pipes", Host: "α", Port: 2})
+	X(l1.Close())
+	l1_, err := h.Listen(bg, "");  X(err)
+	assert.Eq(l1_.Addr(), &Addr{Net: "pipes", Host: "α", Port: 1})
+
+	// dial to high port works
+	wg := &errgroup.Group{}
+	wg.Go(func() error {
+		c, err := lM.Accept(bg)
+		if err != nil {
+			return err
+		}
+		return c.Close()
+	})
+	c, err := h.Dial(bg, "α:1000000");  X(err)
+	X(wg.Wait())
+	X(c.Close())
+	assert.Eq(vnet.Stats(), SubNetStats{Hosts: 1, Listeners: 3})
+}
+
+// closeErrEngine is pipenet-like Engine whose connections return errCloseConn on Close.
+type closeErrEngine struct {
+	notify Notifier
+}
+
+var errCloseConn = errors.New("close problem")
+
+type closeErrConn struct {
+	net.Conn
+}
+
+func (c *closeErrConn) Close() error {
+	c.Conn.Close()
+	return errCloseConn
+}
+
+func (e *closeErrEngine) VNetNewHost(ctx context.Context, hostname string, registry Registry) error {
+	return registry.Announce(ctx, hostname, "")
+}
+
+func (e *closeErrEngine) VNetDial(ctx context.Context, src, dst *Addr, _ string) (net.Conn, *Addr, error) {
+	pc, ps := net.Pipe()
+	accept, err := e.notify.VNetAccept(ctx, src, dst, ps)
+	if err != nil {
+		pc.Close()
+		ps.Close()
+		return nil, nil, err
+	}
+	accept.Ack <- nil
+	return &closeErrConn{pc}, accept.Addr, nil
+}
+
+func (e *closeErrEngine) Close() error {
+	return nil
+}
+
+func TestRegistrySnapshot(t *testing.T) {
+	X := exc.Raiseif
+	assert := xtesting.Assert(t)
+	bg := context.Background()
+
+	// pipenet registry supports list
+	subnet := pipenet.AsVirtNet(pipenet.New("t"))
+	snap, err := subnet.RegistrySnapshot(bg);  X(err)
+	assert.Eq(snap, map[string]string{})
+	for _, host := range []string{"α", "β", "γ"} {
+		_, err = subnet.NewHost(bg, host);  X(err)
+	}
+	snap, err = subnet.RegistrySnapshot(bg);  X(err)
+	assert.Eq(snap, map[string]string{"α": "", "β": "", "γ": ""})
+	X(subnet.Close())
+	_, err = subnet.RegistrySnapshot(bg)
+	assert.Eq(errors.Is(err, ErrRegistryDown), true)
+
+	// registry without list
+	subnet, _ = NewSubNetwork("e", &closeErrEngine{}, nopRegistry{})
+	_, err = subnet.RegistrySnapshot(bg)
+	assert.Eq(err.Error(), `virtnet "e": registry snapshot: registry does not support list`)
+}
+
+// nopRegistry is Registry that accepts all announces and answers all queries with "".
+type nopRegistry struct{}
+
+func (r nopRegistry) Announce(ctx context.Context, hostname, hostdata string) error { return nil }
+func (r nopRegistry) Query(ctx context.Context, hostname string) (string, error)    { return "", nil }
+func (r nopRegistry) Close() error                                                  { return nil }
+
+// TestCloseError verifies that errors from closing connections on shutdown
+// are reported by Host.Close and SubNetwork.Close.
+func TestCloseError(t *testing.T) {
+	X := exc.Raiseif
+	assert := xtesting.Assert(t)
+	bg := context.Background()
+
+	// newPair creates new subnetwork with hosts α and β and connection α -> β established.
+	newPair := func() (*SubNetwork, *Host) {
+		e := &closeErrEngine{}
+		vnet, notify := NewSubNetwork("e", e, nopRegistry{})
+		e.notify = notify
+		hα, err := vnet.NewHost(bg, "α");  X(err)
+		hβ, err := vnet.NewHost(bg, "β");  X(err)
+		l, err := hβ.Listen(bg, "");  X(err)
+
+		wg := &errgroup.Group{}
+		wg.Go(func() error {
+			_, err := l.Accept(bg)
+			return err
+		})
+		_, err = hα.Dial(bg, "β:1");  X(err)
+		X(wg.Wait())
+		return vnet, hα
+	}
+
+	// Host.Close
+	vnet, hα := newPair()
+	err := hα.Close()
+	assert.Eq(errors.Cause(err), errCloseConn)
+	assert.Eq(err.Error(), `virtnet "e": host "α": close: close problem`)
+	X(vnet.Close())
+
+	// SubNetwork.Close
+	vnet, _ = newPair()
+	err = vnet.Close()
+	assert.Eq(err.Error(), `virtnet "e": close: host "α": close problem`)
+}
+
+// TestPipe verifies SubNetwork.Pipe.
+func TestPipe(t0 *testing.T) {
+	t := newTestNet(t0)
+	X := exc.Raiseif
+	assert := xtesting.Assert(t0)
+	bg := context.Background()
+
+	// existing α and new γ
+	cA, cB, err := t.net.Pipe(bg, "α", "γ");  X(err)
+	hγ := t.net.Host("γ")
+	assert.Eq(hγ != nil, true)
+
+	assert.Eq(cA.LocalAddr(),  &Addr{Net: "pipet", Host: "α", Port: 3})
+	assert.Eq(cA.RemoteAddr(), &Addr{Net: "pipet", Host: "γ", Port: 2})
+	assert.Eq(cB.LocalAddr(),  &Addr{Net: "pipet", Host: "γ", Port: 2})
+	assert.Eq(cB.RemoteAddr(), &Addr{Net: "pipet", Host: "α", Port: 3})
+
+	// data flows in both directions
+	for _, dir := range [][2]net.Conn{{cA, cB}, {cB, cA}} {
+		src, dst := dir[0], dir[1]
+		wg := &errgroup.Group{}
+		wg.Go(func() error {
+			_, err := src.Write([]byte("hello"))
+			return err
+		})
+		buf := make([]byte, 5)
+		_, err = io.ReadFull(dst, buf);  X(err)
+		X(wg.Wait())
+		assert.Eq(string(buf), "hello")
+	}
+
+	// the temporary listener is gone
+	assert.Eq(t.net.Stats().Listeners, 2)
+
+	X(cA.Close())
+	X(cB.Close())
+
+	// canceled ctx -> error and nothing is left behind
+	ctx, cancel := context.WithCancel(bg)
+	cancel()
+	conns := t.net.Stats().Conns
+	cA, cB, err = t.net.Pipe(ctx, "α", "γ")
+	assert.Eq(cA, nil)
+	assert.Eq(cB, nil)
+	assert.Eq(errors.Is(err, context.Canceled), true)
+	assert.Eq(t.net.Stats().Listeners, 2)
+	assert.Eq(t.net.Stats().Conns, conns)
+}
+
+// TestSyscallConn verifies that conn over pipenet reports raw connection as not supported.
+func TestSyscallConn(t0 *testing.T) {
+	t := newTestNet(t0)
+	assert := xtesting.Assert(t0)
+
+	sc, ok := t.cαβ.(syscall.Conn)
+	assert.Eq(ok, true)
+	raw, err := sc.SyscallConn()
+	assert.Eq(raw, nil)
+	assert.Eq(errors.Is(err, ErrNoRawConn), true)
+	assert.Eq(err.Error(), "raw-conn pipet α:2->β:2: raw connection not supported")
+}
+
+// TestNewHostAuto verifies that auto-generated host names are reproducible
+// with seeded name source.
+func TestNewHostAuto(t *testing.T) {
+	X := exc.Raiseif
+	assert := xtesting.Assert(t)
+	bg := context.Background()
+
+	// names returns names of n hosts auto-created on new subnetwork seeded with seed.
+	names := func(seed int64, n int) []string {
+		vnet := pipenet.AsVirtNet(pipenet.New("t"))
+		defer func() {
+			X(vnet.Close())
+		}()
+		vnet.SetNameSource(rand.New(rand.NewSource(seed)))
+
+		namev := []string{}
+		for i := 0; i < n; i++ {
+			h, err := vnet.NewHostAuto(bg);  X(err)
+			assert.Eq(vnet.Host(h.Name()), h)
+			namev = append(namev, h.Name())
+		}
+		return namev
+	}
+
+	names1 := names(1, 5)
+	assert.Eq(names(1, 5), names1)
+
+	seen := map[string]bool{}
+	for _, name := range names1 {
+		assert.Eq(seen[name], false)
+		seen[name] = true
+	}
+
+	assert.Eq(names(2, 5)[0] != names1[0], true)
+}
+
+// TestSegmentSize verifies that writes larger than segment size are delivered
+// to peer in several chunks.
+func TestSegmentSize(t0 *testing.T) {
+	t := newTestNet(t0)
+	X := exc.Raiseif
+	assert := xtesting.Assert(t0)
+
+	t.net.SetSegmentSize(1500)
+	data := make([]byte, 10*1024)
+	rand.Read(data)
+
+	wg := &errgroup.Group{}
+	wg.Go(func() error {
+		n, err := t.cαβ.Write(data)
+		if err == nil && n != len(data) {
+			err = io.ErrShortWrite
+		}
+		return err
+	})
+
+	var rxv []byte
+	nchunk := 0
+	buf := make([]byte, 2*len(data))
+	for len(rxv) < len(data) {
+		n, err := t.cβα.Read(buf);  X(err)
+		if n > 1500 {
+			t.Fatalf("read %d bytes in one chunk; segment size is 1500", n)
+		}
+		rxv = append(rxv, buf[:n]...)
+		nchunk++
+	}
+	X(wg.Wait())
+
+	assert.Eq(rxv, data)
+	assert.Eq(nchunk, (len(data) + 1500-1) / 1500)
+
+	// zero segment size -> unlimited
+	t.net.SetSegmentSize(0)
+	wg.Go(func() error {
+		_, err := t.cαβ.Write(data)
+		return err
+	})
+	n, err := t.cβα.Read(buf);  X(err)
+	X(wg.Wait())
+	assert.Eq(n, len(data))
+}
+
+// TestStepping verifies that in stepping mode data exchange in between hosts
+// is advanced only by Step and in reproducible order.
+func TestStepping(t0 *testing.T) {
+	t := newTestNet(t0)
+	X := exc.Raiseif
+	assert := xtesting.Assert(t0)
+	bg := context.Background()
+
+	t.net.EnableStepping()
+
+	var mu sync.Mutex
+	var eventv []string
+	event := func(format string, argv ...interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		eventv = append(eventv, fmt.Sprintf(format, argv...))
+	}
+
+	// α sends pings; β answers each ping with pong
+	const N = 3
+	wg := &errgroup.Group{}
+	wg.Go(func() error {
+		buf := make([]byte, 5)
+		for i := 0; i < N; i++ {
+			_, err := fmt.Fprintf(t.cαβ, "ping%d", i)
+			if err != nil {
+				return err
+			}
+			_, err = io.ReadFull(t.cαβ, buf)
+			if err != nil {
+				return err
+			}
+			event("α: rx %s", buf)
+		}
+		return nil
+	})
+	wg.Go(func() error {
+		buf := make([]byte, 5)
+		for i := 0; i < N; i++ {
+			_, err := io.ReadFull(t.cβα, buf)
+			if err != nil {
+				return err
+			}
+			event("β: rx %s", buf)
+			_, err = fmt.Fprintf(t.cβα, "pong%d", i)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	// nothing is delivered without Step
+	time.Sleep(10*time.Millisecond)
+	mu.Lock()
+	assert.Eq(len(eventv), 0)
+	mu.Unlock()
+
+	for i := 0; i < 2*N; i++ {
+		X(t.net.Step(bg))
+	}
+	X(wg.Wait())
+
+	assert.Eq(eventv, []string{
+		"β: rx ping0",
+		"α: rx pong0",
+		"β: rx ping1",
+		"α: rx pong1",
+		"β: rx ping2",
+		"α: rx pong2",
+	})
+
+	// write waiting for Step is interrupted by Close
+	wg.Go(func() error {
+		_, err := t.cαβ.Write([]byte("hello"))
+		return err
+	})
+	waitStepPending(t.net, 1)
+	X(t.cαβ.Close())
+	err := wg.Wait()
+	assert.Eq(err, xneterr("write", "α:2->β:2", ErrSockDown))
+	assert.Eq(StepPending(t.net), 0)
+
+	// Step is canceled by ctx
+	ctx, cancel := context.WithCancel(bg)
+	cancel()
+	assert.Eq(t.net.Step(ctx), context.Canceled)
+
+	// Step on down subnetwork does not block
+	X(t.net.Close())
+	assert.Eq(t.net.Step(bg), ErrNetDown)
+}
+
+// TestSteppingOrder verifies that Step lets concurrently pending writes to
+// proceed in the order they started to wait.
+func TestSteppingOrder(t *testing.T) {
+	X := exc.Raiseif
+	assert := xtesting.Assert(t)
+	bg := context.Background()
+
+	orderv := []int{3, 0, 4, 1, 2}
+	for run := 0; run < 10; run++ {
+		subnet := pipenet.AsVirtNet(pipenet.New("t"))
+		hα, err := subnet.NewHost(bg, "α");  X(err)
+		hβ, err := subnet.NewHost(bg, "β");  X(err)
+		lβ, err := hβ.Listen(bg, "");  X(err)
+
+		// α:i -> β connections; β side reports index of the connection it received data on
+		rxq := make(chan int)
+		wg := &errgroup.Group{}
+		cv := make([]net.Conn, len(orderv))
+		for i := range cv {
+			dialq := make(chan error)
+			go func() {
+				var err error
+				cv[i], err = hα.Dial(bg, "β:1")
+				dialq <- err
+			}()
+			c, err := lβ.Accept(bg);  X(err)
+			X(<-dialq)
+			i := i
+			wg.Go(func() error {
+				_, err := c.Read(make([]byte, 1))
+				rxq <- i
+				return err
+			})
+		}
+
+		subnet.EnableStepping()
+		for k, i := range orderv {
+			c := cv[i]
+			wg.Go(func() error {
+				_, err := c.Write([]byte{1})
+				return err
+			})
+			waitStepPending(subnet, k+1)
+		}
+
+		var rxv []int
+		for range orderv {
+			X(subnet.Step(bg))
+			rxv = append(rxv, <-rxq)
+		}
+		X(wg.Wait())
+		assert.Eq(rxv, orderv)
+
+		X(subnet.Close())
+	}
+}
+
+// waitStepPending waits until n writes are waiting for Step on the subnetwork.
+func waitStepPending(subnet *SubNetwork, n int) {
+	for StepPending(subnet) != n {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestBroadcast verifies that Broadcast delivers payload to hosts listening on
+// the port and reports per-host errors for the others.
+func TestBroadcast(t *testing.T) {
+	X := exc.Raiseif
+	assert := xtesting.Assert(t)
+	bg := context.Background()
+
+	subnet := pipenet.AsVirtNet(pipenet.New("t"))
+	defer subnet.Close()
+	hα, err := subnet.NewHost(bg, "α");  X(err)
+	hβ, err := subnet.NewHost(bg, "β");  X(err)
+	_,  err  = subnet.NewHost(bg, "γ");  X(err) // does not listen
+	_,  err  = subnet.NewHost(bg, "δ");  X(err) // sender
+	lα, err := hα.Listen(bg, ":5");  X(err)
+	lβ, err := hβ.Listen(bg, ":5");  X(err)
+
+	// receivers
+	wg := &errgroup.Group{}
+	rxq := make(chan string, 2)
+	for _, l := range []xnet.Listener{lα, lβ} {
+		l := l
+		wg.Go(func() error {
+			c, err := l.Accept(bg)
+			if err != nil {
+				return err
+			}
+			defer c.Close()
+			data, err := ioutil.ReadAll(c)
+			if err != nil {
+				return err
+			}
+			rxq <- l.Addr().(*Addr).Host + ": " + string(data)
+			return nil
+		})
+	}
+
+	errMap, err := subnet.Broadcast(bg, "δ", 5, []byte("hello"));  X(err)
+	X(wg.Wait())
+	close(rxq)
+
+	assert.Eq(len(errMap), 3)
+	assert.Eq(errMap["α"], nil)
+	assert.Eq(errMap["β"], nil)
+	assert.Eq(errors.Is(errMap["γ"], ErrConnRefused), true)
+
+	var rxv []string
+	for rx := range rxq {
+		rxv = append(rxv, rx)
+	}
+	sort.Strings(rxv)
+	assert.Eq(rxv, []string{"α: hello", "β: hello"})
+
+	// unknown sender
+	_, err = subnet.Broadcast(bg, "ε", 5, nil)
+	assert.Eq(err.Error(), `virtnet "pipet": broadcast from "ε": address ε: no such host`)
+}
+
+// TestListenBacklogRefuse verifies that with Refuse overflow policy dial to
+// listener with full backlog is refused instead of blocking.
+func TestListenBacklogRefuse(t0 *testing.T) {
+	t := newTestNet(t0)
+	X := exc.Raiseif
+	bg := context.Background()
+
+	l, err := t.hβ.ListenWithOptions(bg, ":5", ListenOptions{Backlog: 1, Overflow: Refuse});  X(err)
+	defer l.Close()
+
+	// first dial stays pending in the backlog
+	dialq := make(chan error, 1)
+	go func() {
+		c, err := t.hα.Dial(bg, "β:5")
+		if err == nil {
+			err = c.Close()
+		}
+		dialq <- err
+	}()
+
+	for ListenerBacklogLen(l) != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// second dial is refused instead of blocking
+	ctx, cancel := context.WithTimeout(bg, time.Second)
+	defer cancel()
+	_, err = t.hα.Dial(ctx, "β:5")
+	if !errors.Is(err, ErrConnRefused) {
+		t.Fatalf("dial over backlog: err = %v  ; want %v", err, ErrConnRefused)
+	}
+
+	// the pending dial is accepted
+	c, err := l.Accept(bg);  X(err)
+	X(<-dialq)
+	X(c.Close())
+
+	// backlog has room again
+	go func() {
+		c, err := t.hα.Dial(bg, "β:5")
+		if err == nil {
+			err = c.Close()
+		}
+		dialq <- err
+	}()
+	c, err = l.Accept(bg);  X(err)
+	X(<-dialq)
+	X(c.Close())
+}
+
+// TestMigrate verifies Migrate error handling.
+func TestMigrate(t *testing.T) {
+	X := exc.Raiseif
+	assert := xtesting.Assert(t)
+	bg := context.Background()
+
+	// pipenet registry supports reannounce
+	subnet := pipenet.AsVirtNet(pipenet.New("t"))
+	h, err := subnet.NewHost(bg, "α");  X(err)
+	X(h.Migrate(bg, "new"))
+	X(h.Close())
+	err = h.Migrate(bg, "new2")
+	assert.Eq(errors.Is(err, ErrHostDown), true)
+	X(subnet.Close())
+
+	// registry without reannounce
+	subnet, _ = NewSubNetwork("e", &closeErrEngine{}, nopRegistry{})
+	h, err = subnet.NewHost(bg, "α");  X(err)
+	err = h.Migrate(bg, "new")
+	assert.Eq(err.Error(), `virtnet "e": host "α": migrate: registry does not support reannounce`)
+}
+
+// TestAcceptFilter verifies that dials rejected by accept filter are refused
+// with the filter error.
+func TestAcceptFilter(t0 *testing.T) {
+	t := newTestNet(t0)
+	X := exc.Raiseif
+	assert := xtesting.Assert(t0)
+	bg := context.Background()
+
+	hγ, err := t.net.NewHost(bg, "γ");  X(err)
+
+	errDenied := errors.New("β is denied")
+	t.lα.(AcceptFilterer).SetAcceptFilter(func(src *Addr) error {
+		if src.Host == "β" {
+			return errDenied
+		}
+		return nil
+	})
+
+	// β is refused
+	_, err = t.hβ.Dial(bg, "α:1")
+	assert.Eq(errors.Is(err, errDenied), true)
+	assert.Eq(err.Error(), "dial pipet β:3->α:1: β is denied")
+
+	// γ is accepted
+	wg := &errgroup.Group{}
+	wg.Go(func() error {
+		c, err := t.lα.Accept(bg)
+		if err != nil {
+			return err
+		}
+		return c.Close()
+	})
+	c, err := hγ.Dial(bg, "α:1");  X(err)
+	X(wg.Wait())
+	X(c.Close())
+}
+
+// TestNAT verifies that SetNAT rewrites source address observed by accept side.
+func TestNAT(t0 *testing.T) {
+	t := newTestNet(t0)
+	X := exc.Raiseif
+	assert := xtesting.Assert(t0)
+	bg := context.Background()
+
+	gw := &Addr{Net: "pipet", Host: "gw", Port: 1}
+	t.net.SetNAT(func(src *Addr) *Addr {
+		if src.Host == "β" {
+			return gw
+		}
+		return nil
+	})
+
+	hγ, err := t.net.NewHost(bg, "γ");  X(err)
+
+	// dialAccept dials α:1 from h and returns dialed and accepted connections.
+	dialAccept := func(h *Host) (cd, ca net.Conn) {
+		wg := &errgroup.Group{}
+		wg.Go(func() (err error) {
+			ca, err = t.lα.Accept(bg)
+			return err
+		})
+		cd, err := h.Dial(bg, "α:1");  X(err)
+		X(wg.Wait())
+		return cd, ca
+	}
+
+	// β is translated on accept side only
+	cβ, cα := dialAccept(t.hβ)
+	assert.Eq(cα.RemoteAddr(), gw)
+	assert.Eq(cβ.LocalAddr(), &Addr{Net: "pipet", Host: "β", Port: 3})
+	assert.Eq(cβ.RemoteAddr(), cα.LocalAddr())
+	X(cβ.Close())
+	X(cα.Close())
+
+	// γ is not translated
+	cγ, cα := dialAccept(hγ)
+	assert.Eq(cα.RemoteAddr(), cγ.LocalAddr())
+	X(cγ.Close())
+	X(cα.Close())
+
+	// NAT removal
+	t.net.SetNAT(nil)
+	cβ, cα = dialAccept(t.hβ)
+	assert.Eq(cα.RemoteAddr(), cβ.LocalAddr())
+	X(cβ.Close())
+	X(cα.Close())
+}
+
+// TestByteBudget verifies that host IO is limited by SetByteBudget.
+func TestByteBudget(t0 *testing.T) {
+	t := newTestNet(t0)
+	X := exc.Raiseif
+	assert := xtesting.Assert(t0)
+	bg := context.Background()
+
+	// tx budget: writes up to the budget succeed, the rest fails
+	t.hα.SetByteBudget(-1, 10)
+	wg := &errgroup.Group{}
+	var rx []byte
+	wg.Go(func() error {
+		rx = make([]byte, 10)
+		_, err := io.ReadFull(t.cβα, rx)
+		return err
+	})
+
+	n, err := t.cαβ.Write([]byte("01234567"));  X(err)
+	assert.Eq(n, 8)
+	n, err = t.cαβ.Write([]byte("89abc"))
+	assert.Eq(n, 2)
+	assert.Eq(err, xneterr("write", "α:2->β:2", ErrQuotaExceeded))
+	n, err = t.cαβ.Write([]byte("x"))
+	assert.Eq(n, 0)
+	assert.Eq(err, xneterr("write", "α:2->β:2", ErrQuotaExceeded))
+	X(wg.Wait())
+	assert.Eq(string(rx), "0123456789")
+
+	// other hosts are unaffected
+	hγ, err := t.net.NewHost(bg, "γ");  X(err)
+	wg.Go(func() error {
+		c, err := t.lα.Accept(bg)
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+		_, err = io.Copy(ioutil.Discard, c)
+		return err
+	})
+	c, err := hγ.Dial(bg, "α:1");  X(err)
+	_, err = c.Write(make([]byte, 100));  X(err)
+	X(c.Close())
+	X(wg.Wait())
+
+	// rx budget
+	t.hα.SetByteBudget(-1, -1)
+	t.hβ.SetByteBudget(3, -1)
+	wg.Go(func() error {
+		_, err := t.cαβ.Write([]byte("hello"))
+		return err
+	})
+	buf := make([]byte, 10)
+	n, err = t.cβα.Read(buf);  X(err)
+	assert.Eq(string(buf[:n]), "hel")
+	n, err = t.cβα.Read(buf)
+	assert.Eq(n, 0)
+	assert.Eq(err, xneterr("read", "α:2->β:2", ErrQuotaExceeded))
+
+	// negative budget -> unlimited
+	t.hβ.SetByteBudget(-1, -1)
+	n, err = t.cβα.Read(buf);  X(err)
+	assert.Eq(string(buf[:n]), "lo")
+	X(wg.Wait())
+}
+
+// TestIsDown verifies IsDown of subnetwork, hosts and connections.
+func TestIsDown(t0 *testing.T) {
+	t := newTestNet(t0)
+	X := exc.Raiseif
+	assert := xtesting.Assert(t0)
+
+	isDown := func(c net.Conn) bool {
+		return c.(DownChecker).IsDown()
+	}
+
+	assert.Eq(t.net.IsDown(), false)
+	assert.Eq(t.hα.IsDown(), false)
+	assert.Eq(t.hβ.IsDown(), false)
+	assert.Eq(isDown(t.cαβ), false)
+	assert.Eq(isDown(t.cβα), false)
+
+	// conn Close
+	X(t.cαβ.Close())
+	assert.Eq(isDown(t.cαβ), true)
+	assert.Eq(isDown(t.cβα), false)
+	assert.Eq(t.hα.IsDown(), false)
+
+	// host Close shuts down its connections
+	X(t.hβ.Close())
+	assert.Eq(t.hβ.IsDown(), true)
+	assert.Eq(isDown(t.cβα), true)
+	assert.Eq(t.hα.IsDown(), false)
+	assert.Eq(t.net.IsDown(), false)
+
+	// subnetwork Close shuts down its hosts
+	X(t.net.Close())
+	assert.Eq(t.net.IsDown(), true)
+	assert.Eq(t.hα.IsDown(), true)
+}
+
+func TestAttr(t0 *testing.T) {
+	t := newTestNet(t0)
+	defer t.net.Close()
+	assert := xtesting.Assert(t0)
+
+	getAttr := func(c net.Conn, key string) [2]interface{} {
+		val, ok := GetAttr(c, key)
+		return [2]interface{}{val, ok}
+	}
+
+	assert.Eq(getAttr(t.cαβ, "reqid"), [2]interface{}{"", false})
+
+	SetAttr(t.cαβ, "reqid", "123")
+	assert.Eq(getAttr(t.cαβ, "reqid"), [2]interface{}{"123", true})
+	assert.Eq(getAttr(t.cβα, "reqid"), [2]interface{}{"", false})
+
+	SetAttr(t.cαβ, "reqid", "456")
+	assert.Eq(getAttr(t.cαβ, "reqid"), [2]interface{}{"456", true})
+	assert.Eq(getAttr(t.cαβ, "other"), [2]interface{}{"", false})
+
+	// non-virtnet connection
+	p1, p2 := net.Pipe()
+	defer p1.Close()
+	defer p2.Close()
+	SetAttr(p1, "reqid", "789")
+	assert.Eq(getAttr(p1, "reqid"), [2]interface{}{"", false})
+}
+
+func TestEventLog(t *testing.T) {
+	X := exc.Raiseif
+	assert := xtesting.Assert(t)
+	bg := context.Background()
+
+	subnet := pipenet.AsVirtNet(pipenet.New("t"))
+	defer subnet.Close()
+
+	eventLog := func() []string {
+		var logv []string
+		for _, e := range subnet.EventLog() {
+			logv = append(logv, e.String())
+		}
+		return logv
+	}
+
+	assert.Eq(eventLog(), []string(nil))
+
+	subnet.SetEventLog(4)
+	hα, err := subnet.NewHost(bg, "α"); X(err)
+	hβ, err := subnet.NewHost(bg, "β"); X(err)
+	assert.Eq(eventLog(), []string{"new host α", "new host β"})
+
+	lβ, err := hβ.Listen(bg, ""); X(err)
+	wg := &errgroup.Group{}
+	var cβα net.Conn
+	wg.Go(func() error {
+		c, err := lβ.Accept(bg)
+		cβα = c
+		return err
+	})
+	cαβ, err := hα.Dial(bg, "β:1"); X(err)
+	X(wg.Wait())
+	X(cβα.Close())
+
+	_, err = hα.Dial(bg, "β:2")
+	assert.Eq(err, xneterr("dial", "α:2->β:2", ErrConnRefused))
+	X(cαβ.Close())
+	X(lβ.Close())
+	X(hα.Close())
+
+	// only the most recent entries are kept
+	assert.Eq(eventLog(), []string{
+		"dial α:2->β:2: " + ErrConnRefused.Error(),
+		"close α:1->β:2",
+		"close β:1",
+		"close α",
+	})
+}
+
+func TestAcceptChan(t0 *testing.T) {
+	t := newTestNet(t0)
+	defer t.net.Close()
+	X := exc.Raiseif
+	assert := xtesting.Assert(t0)
+	bg := context.Background()
+
+	acceptα := t.lα.(AcceptChanner).AcceptChan()
+	acceptβ := t.lβ.(AcceptChanner).AcceptChan()
+
+	wg := &errgroup.Group{}
+	wg.Go(func() error {
+		_, err := t.hα.Dial(bg, "β:1")
+		return err
+	})
+	wg.Go(func() error {
+		_, err := t.hβ.Dial(bg, "α:1")
+		return err
+	})
+
+	// multiplex accepts from both listeners
+	var hostv []string
+	for len(hostv) < 2 {
+		var r AcceptResult
+		select {
+		case r = <-acceptα:
+		case r = <-acceptβ:
+		}
+		X(r.Err)
+		hostv = append(hostv, r.Conn.LocalAddr().(*Addr).Host)
+	}
+	X(wg.Wait())
+	sort.Strings(hostv)
+	assert.Eq(hostv, []string{"α", "β"})
+
+	// Close closes the channel
+	X(t.lα.Close())
+	select {
+	case r, ok := <-acceptα:
+		if ok {
+			t.Fatalf("accept chan: received %v after close", r)
+		}
+	case <-time.After(5*time.Second):
+		t.Fatal("accept chan: not closed after listener close")
+	}
+}
+
+func TestPortReusePolicy(t *testing.T) {
+	X := exc.Raiseif
+	assert := xtesting.Assert(t)
+	bg := context.Background()
+
+	subnet := pipenet.AsVirtNet(pipenet.New("t"))
+	defer subnet.Close()
+
+	for _, tt := range []struct {
+		policy ReusePolicy
+		port   int // port of new socket after port 1 was closed
+	}{
+		{ImmediateReuse, 1},
+		{DelayedReuse, 2},
+	} {
+		h, err := subnet.NewHostAuto(bg); X(err)
+		h.SetPortReusePolicy(tt.policy)
+
+		l, err := h.Listen(bg, ""); X(err)
+		assert.Eq(l.Addr().(*Addr).Port, 1)
+		X(l.Close())
+
+		l, err = h.Listen(bg, ""); X(err)
+		assert.Eq(l.Addr().(*Addr).Port, tt.port)
+		X(l.Close())
+	}
+
+	// with DelayedReuse freed ports come back, in the order they were
+	// freed, after fresh ports are exhausted
+	h, err := subnet.NewHostAuto(bg); X(err)
+	h.SetPortReusePolicy(DelayedReuse)
+	SetMaxPort(h, 4)
+
+	lv := make([]xnet.Listener, 5)
+	for i := 1; i <= 4; i++ {
+		lv[i], err = h.Listen(bg, ""); X(err)
+		assert.Eq(lv[i].Addr().(*Addr).Port, i)
+	}
+	X(lv[3].Close())
+	X(lv[1].Close())
+
+	for _, port := range []int{3, 1} {
+		l, err := h.Listen(bg, ""); X(err)
+		assert.Eq(l.Addr().(*Addr).Port, port)
+		defer l.Close()
+	}
+	X(lv[2].Close())
+	X(lv[4].Close())
+}
+
+// fakeClock is virtnet Clock driven by simulated time.
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiterv []fakeWaiter
+	added   chan struct{} // closed and renewed when a waiter is registered
+}
+
+type fakeWaiter struct {
+	at time.Time
+	c  chan time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w := fakeWaiter{c.now.Add(d), make(chan time.Time, 1)}
+	if d <= 0 {
+		w.c <- c.now
+	} else {
+		c.waiterv = append(c.waiterv, w)
+		if c.added != nil {
+			close(c.added)
+			c.added = nil
+		}
+	}
+	return w.c
+}
+
+// Waiters returns channel that is ready when the clock has at least n
+// registered waiters.
+func (c *fakeClock) Waiters(n int) <-chan struct{} {
+	ready := make(chan struct{})
+	go func() {
+		defer close(ready)
+		for {
+			c.mu.Lock()
+			if len(c.waiterv) >= n {
+				c.mu.Unlock()
+				return
+			}
+			if c.added == nil {
+				c.added = make(chan struct{})
+			}
+			added := c.added
+			c.mu.Unlock()
+			<-added
+		}
+	}()
+	return ready
+}
+
+// Advance moves the clock forward by d and fires waiters whose time came.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	var waiterv []fakeWaiter
+	for _, w := range c.waiterv {
+		if w.at.After(c.now) {
+			waiterv = append(waiterv, w)
+		} else {
+			w.c <- c.now
+		}
+	}
+	c.waiterv = waiterv
+}
+
+func TestDialDeadline(t0 *testing.T) {
+	t := newTestNet(t0)
+	defer t.net.Close()
+	assert := xtesting.Assert(t0)
+	bg := context.Background()
+
+	clock := &fakeClock{now: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	t.net.SetClock(clock)
+
+	// β:1 listener does not accept - the dial blocks until deadline
+	errc := make(chan error)
+	go func() {
+		_, err := t.hα.DialDeadline(bg, "β:1", clock.Now().Add(time.Hour))
+		errc <- err
+	}()
+
+	select {
+	case err := <-errc:
+		t.Fatalf("dial: returned before deadline: %v", err)
+	case <-clock.Waiters(1):
+	}
+
+	clock.Advance(2*time.Hour)
+	err := <-errc
+	assert.Eq(err, xneterr("dial", "α:3->β:1", context.DeadlineExceeded))
+	if !err.(*net.OpError).Timeout() {
+		t.Fatalf("dial: error is not timeout: %v", err)
+	}
+}
+
+func TestRefuseLogger(t0 *testing.T) {
+	t := newTestNet(t0)
+	defer t.net.Close()
+	assert := xtesting.Assert(t0)
+	bg := context.Background()
+
+	type refused struct {
+		src, dst string
+		reason   error
+	}
+	var mu sync.Mutex
+	var refusev []refused
+	t.net.SetRefuseLogger(func(src, dst *Addr, reason error) {
+		mu.Lock()
+		defer mu.Unlock()
+		refusev = append(refusev, refused{src.String(), dst.String(), reason})
+	})
+
+	_, err := t.hα.Dial(bg, "β:5")
+	assert.Eq(err, xneterr("dial", "α:3->β:5", ErrConnRefused))
+
+	// dial canceled via ctx is not logged
+	ctx, cancel := context.WithCancel(bg)
+	cancel()
+	_, err = t.hα.Dial(ctx, "β:1")
+	if err == nil {
+		t0.Fatal("dial with canceled ctx: connected")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Eq(refusev, []refused{{"α:3", "β:5", ErrConnRefused}})
+}
+
+func TestAddrZone(t0 *testing.T) {
+	X := exc.Raiseif
+	assert := xtesting.Assert(t0)
+	bg := context.Background()
+
+	for _, tt := range []struct {
+		addr string
+		ok   *Addr
+	}{
+		{"α:1", &Addr{Net: "t", Host: "α", Port: 1}},
+		{"α%zone:1", &Addr{Net: "t", Host: "α", Port: 1, Zone: "zone"}},
+		{"%z:0", &Addr{Net: "t", Host: "", Port: 0, Zone: "z"}},
+	} {
+		a, err := ParseAddr("t", tt.addr); X(err)
+		assert.Eq(a, tt.ok)
+		assert.Eq(a.String(), tt.addr)
+	}
+
+	// zone survives Dial/Accept round-trip
+	t := newTestNet(t0)
+	defer t.net.Close()
+
+	wg := &errgroup.Group{}
+	var cβα net.Conn
+	wg.Go(func() error {
+		c, err := t.lβ.Accept(bg)
+		cβα = c
+		return err
+	})
+	cαβ, err := t.hα.Dial(bg, "β%z:1"); X(err)
+	X(wg.Wait())
+
+	assert.Eq(cαβ.LocalAddr().String(), "α:3")
+	assert.Eq(cαβ.RemoteAddr().String(), "β%z:3")
+	assert.Eq(cβα.LocalAddr().String(), "β%z:3")
+	assert.Eq(cβα.RemoteAddr().String(), "α:3")
+}
+
+func TestPooledDial(t0 *testing.T) {
+	t := newTestNet(t0)
+	defer t.net.Close()
+	X := exc.Raiseif
+	assert := xtesting.Assert(t0)
+	bg := context.Background()
+
+	t.hα.SetPoolLimits(1, 50*time.Millisecond)
+
+	// β accepts connections in background
+	wg := &errgroup.Group{}
+	defer func() {
+		X(wg.Wait())
+	}()
+	wg.Go(func() error {
+		for {
+			_, err := t.lβ.Accept(bg)
+			if err != nil {
+				return nil // listener closed on shutdown
+			}
+		}
+	})
+
+	c1, err := t.hα.PooledDial(bg, "β:1"); X(err)
+	X(t.hα.PooledClose(c1))
+	assert.Eq(c1.(DownChecker).IsDown(), false)
+
+	// second dial reuses pooled connection
+	c2, err := t.hα.PooledDial(bg, "β:1"); X(err)
+	u1, _ := Underlying(c1)
+	u2, _ := Underlying(c2)
+	if !(c2 == c1 && u2 == u1) {
+		t.Fatalf("pooled dial: connection not reused")
+	}
+
+	// while c2 is in use, another dial creates new connection;
+	// returning it to the pool, that is already full, closes it
+	c3, err := t.hα.PooledDial(bg, "β:1"); X(err)
+	if c3 == c2 {
+		t.Fatalf("pooled dial: connection in use was reused")
+	}
+	X(t.hα.PooledClose(c2))
+	X(t.hα.PooledClose(c3))
+	assert.Eq(c3.(DownChecker).IsDown(), true)
+
+	// idle timeout closes pooled connection
+	deadline := time.Now().Add(5*time.Second)
+	for !c2.(DownChecker).IsDown() {
+		if time.Now().After(deadline) {
+			t.Fatalf("pooled connection not closed after idle timeout")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	c4, err := t.hα.PooledDial(bg, "β:1"); X(err)
+	if c4 == c2 {
+		t.Fatalf("pooled dial: expired connection was reused")
+	}
+	X(t.hα.PooledClose(c4))
+
+	X(t.lβ.Close())
+}
+
+func TestPayloadFilter(t0 *testing.T) {
+	t := newTestNet(t0)
+	defer t.net.Close()
+	assert := xtesting.Assert(t0)
+	X := exc.Raiseif
+
+	type call struct{ src, dst string }
+	var callv []call
+	t.net.SetPayloadFilter(func(src, dst *Addr, data []byte) []byte {
+		callv = append(callv, call{src.String(), dst.String()})
+		switch string(data) {
+		case "drop":
+			return nil
+		case "truncate":
+			return data[:5]
+		}
+		data[0] ^= 0xff
+		return data
+	})
+
+	xread := func(c net.Conn, n int) string {
+		buf := make([]byte, n)
+		_, err := io.ReadFull(c, buf)
+		X(err)
+		return string(buf)
+	}
+
+	xwrite := func(c net.Conn, data string) {
+		buf := []byte(data)
+		n, err := c.Write(buf)
+		X(err)
+		assert.Eq(n, len(data))
+		assert.Eq(string(buf), data) // writer's data is not modified
+	}
+
+	// pipenet conns are synchronous - write in background
+	xwriteBg := func(c net.Conn, data string) chan struct{} {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			xwrite(c, data)
+		}()
+		return done
+	}
+
+	done := xwriteBg(t.cαβ, "hello")
+	assert.Eq(xread(t.cβα, 5), "\x97ello")
+	<-done
+
+	xwrite(t.cαβ, "drop") // does not block as nothing is delivered
+	done = xwriteBg(t.cαβ, "truncate")
+	assert.Eq(xread(t.cβα, 5), "trunc")
+	<-done
+
+	// filter removed
+	t.net.SetPayloadFilter(nil)
+	done = xwriteBg(t.cβα, "world")
+	assert.Eq(xread(t.cαβ, 5), "world")
+	<-done
+
+	assert.Eq(callv, []call{
+		{"α:2", "β:2"},
+		{"α:2", "β:2"},
+		{"α:2", "β:2"},
+	})
+}
+
+func TestCloseGraceful(t0 *testing.T) {
+	t := newTestNet(t0)
+	defer t.net.Close()
+	X := exc.Raiseif
+	assert := xtesting.Assert(t0)
+	bg := context.Background()
+
+	// queue a dial to β:1; the filter tells when the dial found the listener
+	queued := make(chan struct{})
+	t.lβ.(AcceptFilterer).SetAcceptFilter(func(src *Addr) error {
+		close(queued)
+		return nil
+	})
+	wg := &errgroup.Group{}
+	var cdial net.Conn
+	wg.Go(func() error {
+		var err error
+		cdial, err = t.hα.Dial(bg, "β:1")
+		return err
+	})
+	<-queued
+
+	// concurrent Accept
+	var caccept net.Conn
+	wg.Go(func() error {
+		var err error
+		caccept, err = t.lβ.Accept(bg)
+		return err
+	})
+
+	X(t.lβ.(GracefulCloser).CloseGraceful(bg))
+	X(wg.Wait())
+	assert.Eq(cdial.LocalAddr(), caccept.RemoteAddr())
+	assert.Eq(caccept.LocalAddr().(*Addr).Host, "β")
+
+	// the listener is closed after CloseGraceful
+	_, err := t.hα.Dial(bg, "β:1")
+	assert.Eq(errors.Is(err, ErrConnRefused), true)
+	_, err = t.lβ.Accept(bg)
+	assert.Eq(err, xneterr("accept", "β:1", ErrSockDown))
+}
+
+// TestCloseGracefulCancel verifies that CloseGraceful with queued dial and
+// without Accept closes the listener on ctx cancel.
+func TestCloseGracefulCancel(t0 *testing.T) {
+	t := newTestNet(t0)
+	defer t.net.Close()
+	assert := xtesting.Assert(t0)
+	bg := context.Background()
+
+	// queue a dial to β:1 that nobody accepts
+	queued := make(chan struct{})
+	t.lβ.(AcceptFilterer).SetAcceptFilter(func(src *Addr) error {
+		close(queued)
+		return nil
+	})
+	dialErr := make(chan error, 1)
+	go func() {
+		_, err := t.hα.Dial(bg, "β:1")
+		dialErr <- err
+	}()
+	<-queued
+
+	ctx, cancel := context.WithTimeout(bg, 50*time.Millisecond)
+	defer cancel()
+	err := t.lβ.(GracefulCloser).CloseGraceful(ctx)
+	assert.Eq(err, context.DeadlineExceeded)
+
+	// the queued dial is refused
+	err = <-dialErr
+	assert.Eq(errors.Is(err, ErrConnRefused), true)
+	_, err = t.lβ.Accept(bg)
+	assert.Eq(err, xneterr("accept", "β:1", ErrSockDown))
+}
+
+func TestListenRange(t0 *testing.T) {
+	t := newTestNet(t0)
+	defer t.net.Close()
+	X := exc.Raiseif
+	assert := xtesting.Assert(t0)
+	bg := context.Background()
+
+	l, err := t.hα.ListenRange(bg, 10, 12);  X(err)
+	assert.Eq(l.Addr(), &Addr{Net: "pipet", Host: "α", Port: 10})
+
+	// any port in the range is already bound
+	_, err = t.hα.ListenRange(bg, 8, 10)
+	assert.Eq(errors.Is(err, ErrAddrAlreadyUsed), true)
+	_, err = t.hα.Listen(bg, "α:9") // rolled back
+	X(err)
+
+	wg := &errgroup.Group{}
+	var cdial net.Conn
+	wg.Go(func() error {
+		var err error
+		cdial, err = t.hβ.Dial(bg, "α:11")
+		return err
+	})
+	c, err := l.Accept(bg);  X(err)
+	X(wg.Wait())
+	assert.Eq(c.LocalAddr(), &Addr{Net: "pipet", Host: "α", Port: 11})
+	assert.Eq(c.RemoteAddr(), cdial.LocalAddr())
+	assert.Eq(cdial.RemoteAddr(), c.LocalAddr())
+
+	// ports are released on close
+	X(l.Close())
+	_, err = t.hβ.Dial(bg, "α:12")
+	assert.Eq(errors.Is(err, ErrConnRefused), true)
+	_, err = t.hα.ListenRange(bg, 10, 12);  X(err)
+}
+
+func TestPartition(t0 *testing.T) {
+	t := newTestNet(t0)
+	defer t.net.Close()
+	X := exc.Raiseif
+	assert := xtesting.Assert(t0)
+	bg := context.Background()
+
+	// xconnect verifies that h can dial addr accepted on l.
+	xconnect := func(h *Host, l xnet.Listener, addr string) {
+		wg := &errgroup.Group{}
+		wg.Go(func() error {
+			c, err := l.Accept(bg)
+			if err != nil {
+				return err
+			}
+			return c.Close()
+		})
+		c, err := h.Dial(bg, addr);  X(err)
+		X(wg.Wait())
+		X(c.Close())
+	}
+
+	// xexchange verifies that data can be exchanged over established α-β connection.
+	xexchange := func() {
+		wg := &errgroup.Group{}
+		wg.Go(func() error {
+			_, err := t.cαβ.Write([]byte("ping"))
+			return err
+		})
+		buf := make([]byte, 4)
+		_, err := io.ReadFull(t.cβα, buf);  X(err)
+		X(wg.Wait())
+		assert.Eq(string(buf), "ping")
+	}
+
+	// α partitioned inbound
+	t.hα.SetPartition(true, false)
+	_, err := t.hβ.Dial(bg, "α:1")
+	assert.Eq(err, xneterr("dial", "β:3->α:1", ErrConnRefused))
+	xexchange()
+	xconnect(t.hα, t.lβ, "β:1") // outbound still works
+
+	// α partitioned outbound
+	t.hα.SetPartition(false, true)
+	_, err = t.hα.Dial(bg, "β:1")
+	assert.Eq(err, xneterr("dial", "α:3->β:1", ErrUnreachable))
+	xexchange()
+	xconnect(t.hβ, t.lα, "α:1") // inbound works again
+
+	// healed
+	t.hα.SetPartition(false, false)
+	xconnect(t.hβ, t.lα, "α:1")
+	xconnect(t.hα, t.lβ, "β:1")
+	xexchange()
+}
@@ -0,0 +1,155 @@
+// Copyright (C) 2026  Nexedi SA and Contributors.
+//                     Kirill Smelkov <kirr@nexedi.com>
+//
+// This program is free software: you can Use, Study, Modify and Redistribute
+// it under the terms of the GNU General Public License version 3, or (at your
+// option) any later version, as published by the Free Software Foundation.
+//
+// You can also Link and Combine this program with other software covered by
+// the terms of any of the Free Software licenses or any of the Open Source
+// Initiative approved licenses and Convey the resulting work. Corresponding
+// source of such a combination shall include the source code for all other
+// software used.
+//
+// This program is distributed WITHOUT ANY WARRANTY; without even the implied
+// warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+//
+// See COPYING file for full licensing terms.
+// See https://www.nexedi.com/licensing for rationale and options.
+
+// Package leaktest provides goroutine leak detection for tests.
+package leaktest
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Snapshot represents set of goroutines that existed at the time of
+// SnapshotGoroutines call.
+type Snapshot struct {
+	goidSet map[int64]bool
+}
+
+// SnapshotGoroutines takes snapshot of currently running goroutines.
+//
+// It is useful to detect goroutine leaks in tests:
+//
+//	func TestXXX(t *testing.T) {
+//		s := leaktest.SnapshotGoroutines()
+//		defer s.AssertNoLeaks(t, time.Second)
+//		...
+//	}
+func SnapshotGoroutines() Snapshot {
+	s := Snapshot{make(map[int64]bool)}
+	for _, g := range goroutines() {
+		s.goidSet[g.id] = true
+	}
+	return s
+}
+
+// AssertNoLeaks asserts that there are no goroutines besides the ones in the snapshot.
+//
+// Goroutines of runtime and testing packages are ignored. Since goroutines
+// might be still shutting down, the check is retried until within time
+// passes. If there are leaked goroutines after that, the test is failed with
+// their tracebacks.
+func (s Snapshot) AssertNoLeaks(t testing.TB, within time.Duration) {
+	t.Helper()
+
+	deadline := time.Now().Add(within)
+	for {
+		leaked := s.leaked()
+		if len(leaked) == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Errorf("%d goroutine(s) leaked:\n\n%s", len(leaked), strings.Join(leaked, "\n\n"))
+			return
+		}
+		time.Sleep(10*time.Millisecond)
+	}
+}
+
+// leaked returns tracebacks of goroutines that are not in the snapshot.
+func (s Snapshot) leaked() []string {
+	var leaked []string
+	for _, g := range goroutines() {
+		if s.goidSet[g.id] || g.ignored() {
+			continue
+		}
+		leaked = append(leaked, g.stack)
+	}
+	return leaked
+}
+
+// goroutine represents one goroutine parsed from runtime.Stack output.
+type goroutine struct {
+	id    int64
+	stack string // full traceback including "goroutine <id> [<state>]:" header
+}
+
+// goroutines returns all goroutines except the calling one.
+func goroutines() []goroutine {
+	buf := make([]byte, 64*1024)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+
+	var gv []goroutine
+	for i, block := range bytes.Split(buf, []byte("\n\n")) {
+		if i == 0 {
+			continue // the calling goroutine comes first
+		}
+		g := goroutine{stack: string(block)}
+		_, err := fmt.Sscanf(g.stack, "goroutine %d ", &g.id)
+		if err != nil {
+			panic(fmt.Sprintf("leaktest: cannot parse goroutine traceback: %q", g.stack))
+		}
+		gv = append(gv, g)
+	}
+	return gv
+}
+
+// ignoredPkgs lists packages whose goroutines belong to runtime or testing
+// infrastructure.
+var ignoredPkgs = []string{
+	"runtime.",
+	"testing.",
+	"os/signal.",
+}
+
+// ignored returns whether g belongs to runtime or testing infrastructure.
+//
+// It is judged by the function goroutine started with.
+func (g *goroutine) ignored() bool {
+	// traceback is header followed by "<func>(...)\n\t<file:line>" pairs,
+	// topmost frame first, and optional "created by ..." trailer.
+	start := ""
+	linev := strings.Split(g.stack, "\n")
+	for i := 1; i < len(linev); i += 2 {
+		if strings.HasPrefix(linev[i], "created by ") {
+			break
+		}
+		if strings.HasPrefix(linev[i], "runtime.goexit(") {
+			continue // shown at the bottom for some goroutines
+		}
+		start = linev[i]
+	}
+
+	for _, pkg := range ignoredPkgs {
+		if strings.HasPrefix(start, pkg) {
+			return true
+		}
+	}
+	return false
+}
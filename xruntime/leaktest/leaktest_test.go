@@ -0,0 +1,67 @@
+// Copyright (C) 2026  Nexedi SA and Contributors.
+//                     Kirill Smelkov <kirr@nexedi.com>
+//
+// This program is free software: you can Use, Study, Modify and Redistribute
+// it under the terms of the GNU General Public License version 3, or (at your
+// option) any later version, as published by the Free Software Foundation.
+//
+// You can also Link and Combine this program with other software covered by
+// the terms of any of the Free Software licenses or any of the Open Source
+// Initiative approved licenses and Convey the resulting work. Corresponding
+// source of such a combination shall include the source code for all other
+// software used.
+//
+// This program is distributed WITHOUT ANY WARRANTY; without even the implied
+// warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+//
+// See COPYING file for full licensing terms.
+// See https://www.nexedi.com/licensing for rationale and options.
+
+package leaktest
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// failRecorder is testing.TB that records failures instead of failing the test.
+type failRecorder struct {
+	testing.TB
+	failv []string
+}
+
+func (r *failRecorder) Helper() {}
+func (r *failRecorder) Errorf(format string, argv ...interface{}) {
+	r.failv = append(r.failv, fmt.Sprintf(format, argv...))
+}
+
+func leakyWorker(stop chan struct{}) {
+	<-stop
+}
+
+func TestAssertNoLeaks(t *testing.T) {
+	// leaked goroutine is detected
+	s := SnapshotGoroutines()
+	stop := make(chan struct{})
+	go leakyWorker(stop)
+
+	r := &failRecorder{TB: t}
+	s.AssertNoLeaks(r, 50*time.Millisecond)
+	close(stop)
+	if len(r.failv) != 1 {
+		t.Fatalf("leak not detected: failures: %q", r.failv)
+	}
+	msg := r.failv[0]
+	if !(strings.HasPrefix(msg, "1 goroutine(s) leaked:") && strings.Contains(msg, "leaktest.leakyWorker(")) {
+		t.Fatalf("leak report unexpected:\n%s", msg)
+	}
+
+	// goroutine that shuts down in time is not reported
+	s = SnapshotGoroutines()
+	stop = make(chan struct{})
+	go leakyWorker(stop)
+	time.AfterFunc(20*time.Millisecond, func() { close(stop) })
+	s.AssertNoLeaks(t, 5*time.Second)
+}
@@ -59,3 +59,93 @@ func HeadTail(s, sep string) (head, tail string, err error) {
 	}
 	return parts[0], parts[1], nil
 }
+
+// Expand replaces <KEY> placeholders in s with values from vars.
+//
+// Placeholders with keys not present in vars are left intact.
+func Expand(s string, vars map[string]string) string {
+	return ExpandFunc(s, func(key string) (string, bool) {
+		v, ok := vars[key]
+		return v, ok
+	})
+}
+
+// ExpandFunc replaces <KEY> placeholders in s with values returned by f.
+//
+// If f returns ok=false the placeholder is left intact.
+func ExpandFunc(s string, f func(key string) (value string, ok bool)) string {
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(s, '<')
+		if i < 0 {
+			break
+		}
+		j := strings.IndexByte(s[i+1:], '>')
+		if j < 0 {
+			break
+		}
+		j += i+1
+
+		// <...<KEY> - the placeholder starts at the last '<'
+		if k := strings.LastIndexByte(s[i:j], '<'); k > 0 {
+			i += k
+		}
+
+		b.WriteString(s[:i])
+		key := s[i+1:j]
+		if v, ok := f(key); ok && key != "" {
+			b.WriteString(v)
+		} else {
+			b.WriteString(s[i:j+1])
+		}
+		s = s[j+1:]
+	}
+	b.WriteString(s)
+	return b.String()
+}
+
+// Wrap word-wraps s into lines no longer than width characters.
+//
+// Words are separated by whitespace, which is collapsed on wrapping. Words
+// longer than width are broken into several lines. Existing newlines
+// separate paragraphs, each of which is wrapped independently; an empty
+// paragraph results in empty line.
+//
+// width must be > 0.
+func Wrap(s string, width int) []string {
+	if width <= 0 {
+		panic("xstrings: wrap: width must be > 0")
+	}
+
+	var linev []string
+	for _, para := range strings.Split(s, "\n") {
+		line := []rune{}
+		for _, word := range strings.Fields(para) {
+			w := []rune(word)
+			if len(line) > 0 && len(line) + 1 + len(w) <= width {
+				line = append(line, ' ')
+				line = append(line, w...)
+				continue
+			}
+			if len(line) > 0 {
+				linev = append(linev, string(line))
+			}
+			// break too long word
+			for len(w) > width {
+				linev = append(linev, string(w[:width]))
+				w = w[width:]
+			}
+			line = w
+		}
+		linev = append(linev, string(line))
+	}
+	return linev
+}
+
+// Fill word-wraps s into lines no longer than width characters and returns
+// them joined with newlines.
+//
+// See Wrap for details.
+func Fill(s string, width int) string {
+	return strings.Join(Wrap(s, width), "\n")
+}
@@ -79,3 +79,62 @@ func TestHeadtail(t *testing.T) {
 		}
 	}
 }
+
+func TestExpand(t *testing.T) {
+	vars := map[string]string{"TIME": ".+s", "LINE": "[0-9]+", "X": "<LINE>"}
+
+	var tests = []struct { input, output string } {
+		{"",				""},
+		{"hello",			"hello"},
+		{"<TIME>",			".+s"},
+		{"--- FAIL: T (<TIME>)",	"--- FAIL: T (.+s)"},
+		{"a.go:<LINE>: b.go:<LINE>",	"a.go:[0-9]+: b.go:[0-9]+"},
+		{"<UNKNOWN> <LINE>",		"<UNKNOWN> [0-9]+"},
+		{"<> <",			"<> <"},
+		{"<<LINE>>",			"<[0-9]+>"},
+		{"a < b > c",			"a < b > c"},
+		{"<X>",				"<LINE>"},	// no recursive expansion
+	}
+
+	for _, tt := range tests {
+		s := Expand(tt.input, vars)
+		if s != tt.output {
+			t.Errorf("expand(%q) -> %q  ; want %q", tt.input, s, tt.output)
+		}
+	}
+}
+
+func TestWrap(t *testing.T) {
+	var tests = []struct { input string; width int; output []string } {
+		{"", 5, []string{""}},
+		{"hello", 5, []string{"hello"}},
+		{"hello world", 5, []string{"hello", "world"}},
+		{"hello world", 11, []string{"hello world"}},
+		{"hello world", 10, []string{"hello", "world"}},
+		{"a b c d e", 3, []string{"a b", "c d", "e"}},
+		{"  a   b  ", 3, []string{"a b"}},
+
+		// long words are broken
+		{"abcdefgh", 3, []string{"abc", "def", "gh"}},
+		{"abcdef", 3, []string{"abc", "def"}},
+		{"x abcdefgh y", 3, []string{"x", "abc", "def", "gh", "y"}},
+		{"αβγδε ζ", 2, []string{"αβ", "γδ", "ε", "ζ"}},
+
+		// paragraphs are wrapped independently
+		{"hello world\nab cd", 5, []string{"hello", "world", "ab cd"}},
+		{"a b\n\nc d", 10, []string{"a b", "", "c d"}},
+		{"a b\n", 10, []string{"a b", ""}},
+	}
+
+	for _, tt := range tests {
+		linev := Wrap(tt.input, tt.width)
+		if !reflect.DeepEqual(linev, tt.output) {
+			t.Errorf("wrap(%q, %d) -> %q  ; want %q", tt.input, tt.width, linev, tt.output)
+		}
+	}
+
+	s := Fill("hello world\nab cd", 5)
+	if want := "hello\nworld\nab cd"; s != want {
+		t.Errorf("fill -> %q  ; want %q", s, want)
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright (C) 2026  Nexedi SA and Contributors.
+//                     Kirill Smelkov <kirr@nexedi.com>
+//
+// This program is free software: you can Use, Study, Modify and Redistribute
+// it under the terms of the GNU General Public License version 3, or (at your
+// option) any later version, as published by the Free Software Foundation.
+//
+// You can also Link and Combine this program with other software covered by
+// the terms of any of the Free Software licenses or any of the Open Source
+// Initiative approved licenses and Convey the resulting work. Corresponding
+// source of such a combination shall include the source code for all other
+// software used.
+//
+// This program is distributed WITHOUT ANY WARRANTY; without even the implied
+// warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+//
+// See COPYING file for full licensing terms.
+// See https://www.nexedi.com/licensing for rationale and options.
+
+//go:build go1.18
+// +build go1.18
+
+package xsync
+
+import (
+	"context"
+)
+
+// Map applies f to every element of in concurrently and returns the results.
+//
+// At most limit invocations of f run at the same time; limit <= 0 means no
+// limit. Results are returned in the order of corresponding input elements.
+//
+// f is invoked under work context derived from ctx similarly to WorkGroup.
+// If an invocation fails, the work context is canceled, no new invocations
+// are started, and Map returns the error from the first failed invocation.
+func Map[T, R any](ctx context.Context, in []T, limit int, f func(ctx context.Context, x T) (R, error)) ([]R, error) {
+	out := make([]R, len(in))
+	wg := NewWorkGroup(ctx)
+
+	var sem chan struct{} // one entry per running f
+	if limit > 0 {
+		sem = make(chan struct{}, limit)
+	}
+
+	stopped := false
+loop:
+	for i := range in {
+		if sem != nil {
+			select {
+			case sem <- struct{}{}:
+				// ok
+			case <-wg.ctx.Done():
+			}
+		}
+		// don't start new work after failure or cancel even if slot was free
+		if wg.ctx.Err() != nil {
+			stopped = true
+			break loop
+		}
+
+		i := i
+		wg.Go(func(ctx context.Context) error {
+			r, err := f(ctx, in[i])
+			if err != nil {
+				// keep the slot occupied so that no new work is started
+				// until wg.Go cancels work context upon our error.
+				return err
+			}
+			out[i] = r
+			if sem != nil {
+				<-sem
+			}
+			return nil
+		})
+	}
+
+	err := wg.Wait()
+	if err == nil && stopped {
+		err = ctx.Err()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,102 @@
+// Copyright (C) 2026  Nexedi SA and Contributors.
+//                     Kirill Smelkov <kirr@nexedi.com>
+//
+// This program is free software: you can Use, Study, Modify and Redistribute
+// it under the terms of the GNU General Public License version 3, or (at your
+// option) any later version, as published by the Free Software Foundation.
+//
+// You can also Link and Combine this program with other software covered by
+// the terms of any of the Free Software licenses or any of the Open Source
+// Initiative approved licenses and Convey the resulting work. Corresponding
+// source of such a combination shall include the source code for all other
+// software used.
+//
+// This program is distributed WITHOUT ANY WARRANTY; without even the implied
+// warranty of MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.
+//
+// See COPYING file for full licensing terms.
+// See https://www.nexedi.com/licensing for rationale and options.
+
+//go:build go1.18
+// +build go1.18
+
+package xsync
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync/atomic"
+	"testing"
+)
+
+func TestMap(t *testing.T) {
+	bg := context.Background()
+
+	in := make([]int, 100)
+	want := make([]int, len(in))
+	for i := range in {
+		in[i] = i
+		want[i] = 2*i
+	}
+
+	// order is preserved and concurrency is limited
+	var nrun, nrunMax int32
+	out, err := Map(bg, in, 8, func(ctx context.Context, x int) (int, error) {
+		n := atomic.AddInt32(&nrun, 1)
+		defer atomic.AddInt32(&nrun, -1)
+		for {
+			m := atomic.LoadInt32(&nrunMax)
+			if n <= m || atomic.CompareAndSwapInt32(&nrunMax, m, n) {
+				break
+			}
+		}
+		return 2*x, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out, want) {
+		t.Fatalf("out:\nhave: %v\nwant: %v", out, want)
+	}
+	if nrunMax > 8 {
+		t.Fatalf("max concurrency = %d  ; want <= 8", nrunMax)
+	}
+
+	// first error cancels the rest
+	errBoom := errors.New("boom")
+	var nstarted, ncanceled int32
+	out, err = Map(bg, in, 8, func(ctx context.Context, x int) (int, error) {
+		atomic.AddInt32(&nstarted, 1)
+		switch {
+		case x < 10:
+			return x, nil
+		case x == 10:
+			return 0, errBoom
+		default:
+			<-ctx.Done()
+			atomic.AddInt32(&ncanceled, 1)
+			return 0, ctx.Err()
+		}
+	})
+	if !(out == nil && err == errBoom) {
+		t.Fatalf("error: have %v, %v  ; want nil, %v", out, err, errBoom)
+	}
+	if nstarted > 10+8 {
+		t.Fatalf("error: started %d  ; want <= %d", nstarted, 10+8)
+	}
+	if ncanceled != nstarted - 11 {
+		t.Fatalf("error: canceled %d  ; want %d", ncanceled, nstarted - 11)
+	}
+
+	// canceled ctx
+	ctx, cancel := context.WithCancel(bg)
+	cancel()
+	out, err = Map(ctx, in, 8, func(ctx context.Context, x int) (int, error) {
+		<-ctx.Done()
+		return 0, nil
+	})
+	if !(out == nil && err == context.Canceled) {
+		t.Fatalf("canceled: have %v, %v  ; want nil, %v", out, err, context.Canceled)
+	}
+}
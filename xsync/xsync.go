@@ -20,6 +20,11 @@
 // Package xsync complements standard package sync.
 //
 //   - `WorkGroup` allows to spawn group of goroutines working on a common task.
+//   - `Barrier` allows to wait, with cancellation, for externally-managed
+//     goroutines to complete.
+//   - `Event` allows to wait, with cancellation, for a one-shot signal.
+//   - `Map` applies a function to slice elements concurrently with bounded
+//     parallelism.
 //
 // Functionality provided by xsync package is also provided by Pygolang(*) in its
 // standard package sync.
@@ -29,7 +34,11 @@ package xsync
 
 import (
 	"context"
+	"sort"
 	"sync"
+	"time"
+
+	"lab.nexedi.com/kirr/go123/xerr"
 )
 
 // WorkGroup represents group of goroutines working on a common task.
@@ -63,7 +72,14 @@ type WorkGroup struct {
 	cancel func()          // aborts ctx
 	waitg  sync.WaitGroup  // wait group for workers
 	mu     sync.Mutex
-	err    error           // error of the first failed worker
+	err    error             // error of the first failed worker
+	nextid uint64            // id for next named worker
+	named  map[uint64]string // id -> name of running named workers
+
+	deadline bool // workers that return after deadline fired make Wait fail with DeadlineExceeded
+
+	noCancel bool        // failed worker does not cancel the group; errors are collected into errv
+	errv     xerr.Errorv // errors of all failed workers if noCancel
 }
 
 // NewWorkGroup creates new WorkGroup working under ctx.
@@ -75,23 +91,80 @@ func NewWorkGroup(ctx context.Context) *WorkGroup {
 	return g
 }
 
+// NewWorkGroupDeadline creates new WorkGroup working under ctx whose workers
+// must finish by deadline.
+//
+// Context passed to workers carries the deadline. If workers are still
+// running when the deadline passes, they are canceled and Wait returns
+// context.DeadlineExceeded, unless a worker failed before that. A worker
+// that returns its own error after the deadline has it reported as is.
+func NewWorkGroupDeadline(ctx context.Context, deadline time.Time) *WorkGroup {
+	g := &WorkGroup{deadline: true}
+	g.ctx, g.cancel = context.WithDeadline(ctx, deadline)
+	return g
+}
+
+// NewWorkGroupNoCancel creates new WorkGroup working under ctx whose workers
+// run to completion regardless of failures of each other.
+//
+// Contrary to NewWorkGroup, failure of a worker does not cancel context of
+// other workers. Wait returns errors of all failed workers merged via xerr.Merge.
+func NewWorkGroupNoCancel(ctx context.Context) *WorkGroup {
+	g := &WorkGroup{noCancel: true}
+	g.ctx, g.cancel = context.WithCancel(ctx)
+	return g
+}
+
 // Go spawns new worker under workgroup.
 //
 // See WorkGroup documentation for details.
 func (g *WorkGroup) Go(f func(context.Context) error) {
+	g.GoNamed("", f)
+}
+
+// GoNamed is like Go but also assigns name to spawned worker.
+//
+// Names of workers that did not yet return are reported by Running.
+func (g *WorkGroup) GoNamed(name string, f func(context.Context) error) {
+	var id uint64
+	if name != "" {
+		g.mu.Lock()
+		if g.named == nil {
+			g.named = make(map[uint64]string)
+		}
+		id = g.nextid
+		g.nextid++
+		g.named[id] = name
+		g.mu.Unlock()
+	}
+
 	g.waitg.Add(1)
 	go func() {
 		defer g.waitg.Done()
 
 		err := f(g.ctx)
-		if err == nil {
+		if err == nil && g.deadline && g.ctx.Err() == context.DeadlineExceeded {
+			// worker was still running when deadline fired, unless it
+			// returned right before it
+			if d, _ := g.ctx.Deadline(); !time.Now().Before(d) {
+				err = context.DeadlineExceeded
+			}
+		}
+		if err == nil && name == "" {
 			return
 		}
 
 		g.mu.Lock()
 		defer g.mu.Unlock()
 
-		if g.err == nil {
+		if name != "" {
+			delete(g.named, id)
+		}
+		if err != nil && g.noCancel {
+			g.errv.Append(err)
+			return
+		}
+		if err != nil && g.err == nil {
 			// this goroutine is the first failed task
 			g.err = err
 			g.cancel()
@@ -99,12 +172,182 @@ func (g *WorkGroup) Go(f func(context.Context) error) {
 	}()
 }
 
+// Running returns names of workers, spawned via GoNamed, that did not yet return.
+//
+// The names are returned in the order the workers were spawned. It is useful
+// for debugging e.g. stuck Wait. Workers spawned via Go are not reported.
+func (g *WorkGroup) Running() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	idv := make([]uint64, 0, len(g.named))
+	for id := range g.named {
+		idv = append(idv, id)
+	}
+	sort.Slice(idv, func(i, j int) bool {
+		return idv[i] < idv[j]
+	})
+
+	namev := make([]string, len(idv))
+	for i, id := range idv {
+		namev[i] = g.named[id]
+	}
+	return namev
+}
+
 // Wait waits for all spawned workers to complete.
 //
-// It returns the error, if any, from the first failed worker.
+// It returns the error, if any, from the first failed worker, or, for
+// WorkGroup created via NewWorkGroupNoCancel, errors from all failed workers.
 // See WorkGroup documentation for details.
 func (g *WorkGroup) Wait() error {
 	g.waitg.Wait()
 	g.cancel()
+	if g.noCancel {
+		return g.errv.Err()
+	}
 	return g.err
 }
+
+// Barrier allows to wait for completion of a set of externally-managed goroutines.
+//
+// It is similar to sync.WaitGroup, but its Wait can be canceled via context:
+//
+//	var b xsync.Barrier
+//	b.Add(2)
+//	go func() { defer b.Done(); ... }()
+//	go func() { defer b.Done(); ... }()
+//	err := b.Wait(ctx)
+//
+// Contrary to WorkGroup, Barrier does not spawn goroutines itself.
+//
+// Barrier is one-shot: after Wait returned successfully, the barrier must not
+// be reused - calling Add after that panics.
+//
+// Zero value of Barrier is ready to use.
+type Barrier struct {
+	mu     sync.Mutex
+	n      int           // counter
+	zero   chan struct{} // closed when counter drops to 0; created on demand by Wait
+	waited bool          // Wait returned successfully
+}
+
+// Add adds delta, which may be negative, to the barrier counter.
+//
+// If the counter becomes negative, Add panics.
+func (b *Barrier) Add(delta int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.waited {
+		panic("xsync: Barrier.Add called after Wait")
+	}
+
+	b.n += delta
+	if b.n < 0 {
+		panic("xsync: negative Barrier counter")
+	}
+	if b.n == 0 && b.zero != nil {
+		close(b.zero)
+		b.zero = nil
+	}
+}
+
+// Done decrements the barrier counter by one.
+func (b *Barrier) Done() {
+	b.Add(-1)
+}
+
+// Wait waits for the barrier counter to become zero.
+//
+// It returns nil when the counter reaches zero, or ctx.Err() if ctx is
+// canceled before that.
+func (b *Barrier) Wait(ctx context.Context) error {
+	b.mu.Lock()
+	if b.n == 0 {
+		b.waited = true
+		b.mu.Unlock()
+		return nil
+	}
+	if b.zero == nil {
+		b.zero = make(chan struct{})
+	}
+	zero := b.zero
+	b.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+
+	case <-zero:
+		b.mu.Lock()
+		b.waited = true
+		b.mu.Unlock()
+		return nil
+	}
+}
+
+// Event is one-shot latch that allows goroutines to wait, with cancellation,
+// for something to happen, for example for a server to become ready:
+//
+//	var ready xsync.Event
+//	go func() { ...; ready.Set(); ... }()
+//	err := ready.Wait(ctx)
+//
+// Once set, the event stays set and all current and future Waits return
+// immediately.
+//
+// Zero value of Event is ready to use.
+type Event struct {
+	mu   sync.Mutex
+	set  bool
+	setq chan struct{} // closed on Set; created on demand by Wait
+}
+
+// Set sets the event and wakes up all waiters.
+//
+// Calling Set on already set event does nothing.
+func (e *Event) Set() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.set {
+		return
+	}
+	e.set = true
+	if e.setq != nil {
+		close(e.setq)
+	}
+}
+
+// IsSet returns whether the event was set.
+func (e *Event) IsSet() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.set
+}
+
+// Wait waits for the event to be set.
+//
+// It returns nil when the event is set, or ctx.Err() if ctx is canceled
+// before that.
+func (e *Event) Wait(ctx context.Context) error {
+	e.mu.Lock()
+	if e.set {
+		e.mu.Unlock()
+		return nil
+	}
+	if e.setq == nil {
+		e.setq = make(chan struct{})
+	}
+	setq := e.setq
+	e.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+
+	case <-setq:
+		return nil
+	}
+}
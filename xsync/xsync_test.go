@@ -23,7 +23,11 @@ import (
 	"context"
 	"fmt"
 	"reflect"
+	"runtime"
 	"testing"
+	"time"
+
+	"lab.nexedi.com/kirr/go123/xerr"
 )
 
 func TestWorkGroup(t *testing.T) {
@@ -115,3 +119,225 @@ func TestWorkGroup(t *testing.T) {
 	cancel() // parent cancel - must be propagated into workgroup
 	xwait("", 1, 2)
 }
+
+func TestWorkGroupRunning(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	wg := NewWorkGroup(ctx)
+	if r := wg.Running(); len(r) != 0 {
+		t.Fatalf("running: %q  ; want []", r)
+	}
+
+	wg.GoNamed("a", func(context.Context) error { return nil })
+	wg.GoNamed("parked", func(ctx context.Context) error {
+		<-ctx.Done()
+		return nil
+	})
+	wg.GoNamed("b", func(context.Context) error { return nil })
+	wg.Go(func(ctx context.Context) error { // unnamed - not reported
+		<-ctx.Done()
+		return nil
+	})
+
+	// wait for a and b to return
+	deadline := time.Now().Add(5*time.Second)
+	for {
+		r := wg.Running()
+		if reflect.DeepEqual(r, []string{"parked"}) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("running: %q  ; want [parked]", r)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// cancel releases parked worker
+	cancel()
+	err := wg.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := wg.Running(); len(r) != 0 {
+		t.Fatalf("running after wait: %q  ; want []", r)
+	}
+}
+
+func TestWorkGroupDeadline(t *testing.T) {
+	// workers that finish in time
+	wg := NewWorkGroupDeadline(context.Background(), time.Now().Add(5*time.Second))
+	wg.Go(func(context.Context) error { return nil })
+	err := wg.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// worker parked past deadline is canceled
+	wg = NewWorkGroupDeadline(context.Background(), time.Now().Add(10*time.Millisecond))
+	var errParked error
+	wg.Go(func(ctx context.Context) error {
+		<-ctx.Done()
+		errParked = ctx.Err()
+		return nil
+	})
+	err = wg.Wait()
+	if err != context.DeadlineExceeded {
+		t.Fatalf("wait: %v  ; want %v", err, context.DeadlineExceeded)
+	}
+	if errParked != context.DeadlineExceeded {
+		t.Fatalf("parked worker: ctx.Err = %v  ; want %v", errParked, context.DeadlineExceeded)
+	}
+
+	// error of worker that failed before deadline is reported
+	wg = NewWorkGroupDeadline(context.Background(), time.Now().Add(5*time.Second))
+	wg.Go(func(context.Context) error { return fmt.Errorf("fail") })
+	wg.Go(func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	err = wg.Wait()
+	if !(err != nil && err.Error() == "fail") {
+		t.Fatalf("wait: %v  ; want fail", err)
+	}
+
+	// error returned by worker after deadline is reported as is
+	wg = NewWorkGroupDeadline(context.Background(), time.Now().Add(10*time.Millisecond))
+	wg.Go(func(ctx context.Context) error {
+		<-ctx.Done()
+		return fmt.Errorf("worker: %w", ctx.Err())
+	})
+	err = wg.Wait()
+	if !(err != nil && err.Error() == "worker: context deadline exceeded") {
+		t.Fatalf("wait: %v  ; want worker: context deadline exceeded", err)
+	}
+}
+
+func TestWorkGroupNoCancel(t *testing.T) {
+	errA := fmt.Errorf("a")
+	errB := fmt.Errorf("b")
+
+	wg := NewWorkGroupNoCancel(context.Background())
+	var ctxErrB error
+	wg.GoNamed("a", func(context.Context) error {
+		return errA
+	})
+	wg.Go(func(ctx context.Context) error {
+		// a is removed from Running together with recording its error,
+		// which is where the group would wrongly cancel ctx
+		for len(wg.Running()) != 0 {
+			runtime.Gosched()
+		}
+		ctxErrB = ctx.Err()
+		return errB
+	})
+
+	err := wg.Wait()
+	if ctxErrB != nil {
+		t.Fatalf("worker b: ctx canceled after sibling failure: %v", ctxErrB)
+	}
+	errv, ok := err.(xerr.Errorv)
+	if !(ok && len(errv) == 2 && errv.CausesAre(errA, errB)) {
+		t.Fatalf("wait: %v  ; want errors a and b", err)
+	}
+}
+
+func TestBarrier(t *testing.T) {
+	bg := context.Background()
+
+	// Wait returns on completion
+	var b Barrier
+	b.Add(2)
+	for i := 0; i < 2; i++ {
+		go b.Done()
+	}
+	err := b.Wait(bg)
+	if err != nil {
+		t.Fatalf("wait: %s", err)
+	}
+
+	// Wait returns on cancel
+	var b2 Barrier
+	b2.Add(1)
+	ctx, cancel := context.WithCancel(bg)
+	cancel()
+	err = b2.Wait(ctx)
+	if err != context.Canceled {
+		t.Fatalf("wait canceled: err = %v  ; want %v", err, context.Canceled)
+	}
+	// after canceled Wait the barrier can still be used
+	b2.Add(1)
+	b2.Done()
+	b2.Done()
+	err = b2.Wait(bg)
+	if err != nil {
+		t.Fatalf("wait after cancel: %s", err)
+	}
+
+	// Add after Wait panics
+	func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Fatal("Add after Wait: not paniced")
+			}
+		}()
+		b.Add(1)
+	}()
+
+	// negative counter panics
+	func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Fatal("negative counter: not paniced")
+			}
+		}()
+		var b3 Barrier
+		b3.Done()
+	}()
+}
+
+func TestEvent(t *testing.T) {
+	bg := context.Background()
+
+	var e Event
+	if e.IsSet() {
+		t.Fatal("new event is set")
+	}
+
+	// waiter canceled before Set
+	ctx, cancel := context.WithCancel(bg)
+	werr := make(chan error)
+	go func() {
+		werr <- e.Wait(ctx)
+	}()
+	cancel()
+	err := <-werr
+	if err != context.Canceled {
+		t.Fatalf("wait canceled: err = %v  ; want %v", err, context.Canceled)
+	}
+
+	// several waiters released by single Set
+	const N = 3
+	for i := 0; i < N; i++ {
+		go func() {
+			werr <- e.Wait(bg)
+		}()
+	}
+	e.Set()
+	for i := 0; i < N; i++ {
+		err := <-werr
+		if err != nil {
+			t.Fatalf("wait: %s", err)
+		}
+	}
+	if !e.IsSet() {
+		t.Fatal("event is not set after Set")
+	}
+
+	// Set is idempotent; Wait after Set returns immediately even with canceled ctx
+	e.Set()
+	err = e.Wait(ctx)
+	if err != nil {
+		t.Fatalf("wait after set: %s", err)
+	}
+}